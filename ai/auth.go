@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRateLimit = 30 // requests per minute per token
+	maxAuditEntries  = 1000
+)
+
+var (
+	errMissingToken = errors.New("missing API token")
+	errInvalidToken = errors.New("invalid API token")
+	errRevokedToken = errors.New("API token has been revoked")
+)
+
+// APIToken binds a secret credential to a single user identity.
+// Only the SHA-256 hash of the secret is kept in memory.
+type APIToken struct {
+	ID        string     `json:"id"`
+	User      string     `json:"user"`
+	RateLimit int        `json:"rate_limit"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+
+	hash        string
+	windowStart time.Time
+	windowCount int
+}
+
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	TokenID   string    `json:"token_id,omitempty"`
+	User      string    `json:"user,omitempty"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target,omitempty"`
+	Allowed   bool      `json:"allowed"`
+	Reason    string    `json:"reason,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+type TokenStore struct {
+	sync.Mutex
+	tokens     map[string]*APIToken // keyed by secret hash
+	byID       map[string]*APIToken
+	audit      []AuditEntry
+	adminToken string
+}
+
+func NewTokenStore(adminToken string) *TokenStore {
+	return &TokenStore{
+		tokens:     make(map[string]*APIToken),
+		byID:       make(map[string]*APIToken),
+		audit:      make([]AuditEntry, 0),
+		adminToken: adminToken,
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// issue creates a new token for user and returns the plaintext secret.
+// The secret is never stored and cannot be recovered later.
+func (ts *TokenStore) issue(user string, rateLimit int) (string, *APIToken) {
+	if rateLimit <= 0 {
+		rateLimit = defaultRateLimit
+	}
+
+	secret := "ak_" + randomHex(24)
+	token := &APIToken{
+		ID:        "tok_" + randomHex(6),
+		User:      user,
+		RateLimit: rateLimit,
+		CreatedAt: time.Now(),
+		hash:      hashSecret(secret),
+	}
+
+	ts.Lock()
+	defer ts.Unlock()
+	ts.tokens[token.hash] = token
+	ts.byID[token.ID] = token
+	return secret, token
+}
+
+func (ts *TokenStore) revoke(id string) bool {
+	ts.Lock()
+	defer ts.Unlock()
+
+	token := ts.byID[id]
+	if token == nil || token.RevokedAt != nil {
+		return false
+	}
+	now := time.Now()
+	token.RevokedAt = &now
+	return true
+}
+
+func (ts *TokenStore) list(user string) []APIToken {
+	ts.Lock()
+	defer ts.Unlock()
+
+	result := make([]APIToken, 0, len(ts.byID))
+	for _, token := range ts.byID {
+		if user != "" && token.User != user {
+			continue
+		}
+		result = append(result, *token)
+	}
+	return result
+}
+
+func (ts *TokenStore) authenticate(secret string) (*APIToken, error) {
+	if secret == "" {
+		return nil, errMissingToken
+	}
+
+	ts.Lock()
+	defer ts.Unlock()
+
+	token := ts.tokens[hashSecret(secret)]
+	if token == nil {
+		return nil, errInvalidToken
+	}
+	if token.RevokedAt != nil {
+		return nil, errRevokedToken
+	}
+	return token, nil
+}
+
+// allow applies a fixed one-minute window rate limit to the token.
+func (ts *TokenStore) allow(token *APIToken) bool {
+	ts.Lock()
+	defer ts.Unlock()
+
+	now := time.Now()
+	if now.Sub(token.windowStart) >= time.Minute {
+		token.windowStart = now
+		token.windowCount = 0
+	}
+	if token.windowCount >= token.RateLimit {
+		return false
+	}
+	token.windowCount++
+	return true
+}
+
+func (ts *TokenStore) isAdmin(secret string) bool {
+	if ts.adminToken == "" || secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(ts.adminToken)) == 1
+}
+
+func (ts *TokenStore) recordAudit(entry AuditEntry) {
+	entry.Time = time.Now()
+
+	ts.Lock()
+	ts.audit = append(ts.audit, entry)
+	if len(ts.audit) > maxAuditEntries {
+		ts.audit = ts.audit[len(ts.audit)-maxAuditEntries:]
+	}
+	ts.Unlock()
+
+	logger.Info("audit", "token_id", entry.TokenID, "user", entry.User, "action", entry.Action,
+		"target", entry.Target, "allowed", entry.Allowed, "reason", entry.Reason, "request_id", entry.RequestID)
+}
+
+// auditLog returns the most recent entries, newest first, optionally
+// filtered by the user that made the request or the profile accessed.
+func (ts *TokenStore) auditLog(user string, limit int) []AuditEntry {
+	ts.Lock()
+	defer ts.Unlock()
+
+	result := make([]AuditEntry, 0)
+	for i := len(ts.audit) - 1; i >= 0 && len(result) < limit; i-- {
+		entry := ts.audit[i]
+		if user != "" && entry.User != user && entry.Target != user {
+			continue
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+var tokenStore = NewTokenStore("")
+
+type contextKey string
+
+const authTokenKey contextKey = "auth_token"
+
+func tokenFromContext(ctx context.Context) *APIToken {
+	token, _ := ctx.Value(authTokenKey).(*APIToken)
+	return token
+}
+
+// credentialFromRequest accepts either "Authorization: Bearer <token>"
+// or "X-API-Key: <token>". Browsers cannot set headers on WebSocket
+// handshakes, so those may pass the token as a "token" query parameter.
+func credentialFromRequest(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if key := strings.TrimSpace(r.Header.Get("X-API-Key")); key != "" {
+		return key
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return strings.TrimSpace(r.URL.Query().Get("token"))
+	}
+	return ""
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// requireAuth resolves the caller's identity from its API token and
+// enforces the per-token rate limit before calling next. CORS preflight
+// requests carry no credentials, so they are answered here and never
+// reach a handler without a token.
+func requireAuth(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		token, err := tokenStore.authenticate(credentialFromRequest(r))
+		if err != nil {
+			tokenStore.recordAudit(AuditEntry{Action: action, Allowed: false, Reason: err.Error(), RequestID: requestIDFromContext(r.Context())})
+			writeJSONError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		if !tokenStore.allow(token) {
+			tokenStore.recordAudit(AuditEntry{TokenID: token.ID, User: token.User, Action: action, Allowed: false, Reason: "rate limit exceeded", RequestID: requestIDFromContext(r.Context())})
+			w.Header().Set("Retry-After", "60")
+			writeJSONError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), authTokenKey, token)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !tokenStore.isAdmin(credentialFromRequest(r)) {
+			writeJSONError(w, http.StatusUnauthorized, "Admin credentials required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// checkUserAccess rejects requests that name a user other than the one
+// bound to the caller's token, and records the access either way.
+func checkUserAccess(w http.ResponseWriter, r *http.Request, action, requested string) (string, bool) {
+	token := tokenFromContext(r.Context())
+	if token == nil {
+		writeJSONError(w, http.StatusUnauthorized, "Missing API token")
+		return "", false
+	}
+	if requested != "" && requested != token.User {
+		tokenStore.recordAudit(AuditEntry{TokenID: token.ID, User: token.User, Action: action, Target: requested, Allowed: false, Reason: "user mismatch", RequestID: requestIDFromContext(r.Context())})
+		writeJSONError(w, http.StatusForbidden, "Token is not authorized for this user")
+		return "", false
+	}
+	tokenStore.recordAudit(AuditEntry{TokenID: token.ID, User: token.User, Action: action, Target: token.User, Allowed: true, RequestID: requestIDFromContext(r.Context())})
+	memoryStore.restore(token.User)
+	return token.User, true
+}
+
+type tokenRequest struct {
+	User      string `json:"user"`
+	RateLimit int    `json:"rate_limit,omitempty"`
+}
+
+// handleAdminTokens issues (POST), lists (GET) and revokes (DELETE) tokens.
+func handleAdminTokens(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		var req tokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		if strings.TrimSpace(req.User) == "" {
+			writeJSONError(w, http.StatusBadRequest, "User field is required")
+			return
+		}
+
+		secret, token := tokenStore.issue(strings.TrimSpace(req.User), req.RateLimit)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      secret,
+			"id":         token.ID,
+			"user":       token.User,
+			"rate_limit": token.RateLimit,
+			"created_at": token.CreatedAt,
+		})
+
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(tokenStore.list(r.URL.Query().Get("user")))
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			writeJSONError(w, http.StatusBadRequest, "Id parameter is required")
+			return
+		}
+		if !tokenStore.revoke(id) {
+			writeJSONError(w, http.StatusNotFound, "Token not found or already revoked")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "revoked", "id": id})
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Only GET, POST and DELETE methods allowed")
+	}
+}
+
+func handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Only GET method allowed")
+		return
+	}
+
+	limit := 100
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= maxAuditEntries {
+		limit = l
+	}
+
+	json.NewEncoder(w).Encode(tokenStore.auditLog(r.URL.Query().Get("user"), limit))
+}
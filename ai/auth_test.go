@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPreflightIsAnsweredWithoutToken(t *testing.T) {
+	handler := requireAuth("persona", handlePersona)
+
+	req := httptest.NewRequest(http.MethodOptions, "/persona?user=alice", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status %d, want 204", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin %q", got)
+	}
+}
+
+func TestCheckUserAccessWithoutToken(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if _, ok := checkUserAccess(rec, httptest.NewRequest(http.MethodGet, "/facts", nil), "facts", ""); ok {
+		t.Fatal("request without a token was allowed")
+	}
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status %d, want 401", rec.Code)
+	}
+}
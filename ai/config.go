@@ -0,0 +1,768 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TierConfig controls how much memory is packed into the prompt for a
+// given timeout type.
+type TierConfig struct {
+	MaxHistoryItems int `json:"max_history_items" yaml:"max_history_items"`
+	MaxContextSize  int `json:"max_context_size" yaml:"max_context_size"`
+}
+
+type Config struct {
+	ListenAddr         string
+	OllamaURL          string
+	Model              string
+	MaxHistory         int
+	Timeouts           TimeoutConfig
+	ComplexityKeywords []string
+	Tiers              map[string]TierConfig // "short", "medium", "long", "default"
+	Adaptive           AdaptiveConfig
+	Tools              ToolsConfig
+	Documents          DocumentsConfig
+	Memory             MemoryConfig
+	Fallback           FallbackConfig
+	Reminders          RemindersConfig
+}
+
+// fileTimeouts holds durations as strings ("30s", "2m") as written in the
+// config file.
+type fileTimeouts struct {
+	Short       string `json:"short" yaml:"short"`
+	Medium      string `json:"medium" yaml:"medium"`
+	Long        string `json:"long" yaml:"long"`
+	HTTPClient  string `json:"http_client" yaml:"http_client"`
+	ServerRead  string `json:"server_read" yaml:"server_read"`
+	ServerWrite string `json:"server_write" yaml:"server_write"`
+}
+
+type fileAdaptive struct {
+	Enabled    bool    `json:"enabled" yaml:"enabled"`
+	Percentile float64 `json:"percentile" yaml:"percentile"`
+	Margin     float64 `json:"margin" yaml:"margin"`
+	MinSamples int     `json:"min_samples" yaml:"min_samples"`
+	Min        string  `json:"min" yaml:"min"`
+	Max        string  `json:"max" yaml:"max"`
+}
+
+type fileTools struct {
+	Enabled    bool   `json:"enabled" yaml:"enabled"`
+	MaxSteps   int    `json:"max_steps" yaml:"max_steps"`
+	Timeout    string `json:"timeout" yaml:"timeout"`
+	SandboxDir string `json:"sandbox_dir" yaml:"sandbox_dir"`
+}
+
+type fileDocuments struct {
+	Enabled        bool    `json:"enabled" yaml:"enabled"`
+	Dir            string  `json:"dir" yaml:"dir"`
+	EmbeddingURL   string  `json:"embedding_url" yaml:"embedding_url"`
+	EmbeddingModel string  `json:"embedding_model" yaml:"embedding_model"`
+	Timeout        string  `json:"timeout" yaml:"timeout"`
+	ChunkSize      int     `json:"chunk_size" yaml:"chunk_size"`
+	ChunkOverlap   int     `json:"chunk_overlap" yaml:"chunk_overlap"`
+	TopK           int     `json:"top_k" yaml:"top_k"`
+	MinScore       float64 `json:"min_score" yaml:"min_score"`
+	MaxUploadBytes int64   `json:"max_upload_bytes" yaml:"max_upload_bytes"`
+}
+
+type fileMemory struct {
+	IdleTTL         string `json:"idle_ttl" yaml:"idle_ttl"`
+	JanitorInterval string `json:"janitor_interval" yaml:"janitor_interval"`
+	ArchiveDir      string `json:"archive_dir" yaml:"archive_dir"`
+	MaxHistoryBytes int    `json:"max_history_bytes" yaml:"max_history_bytes"`
+	MaxFactBytes    int    `json:"max_fact_bytes" yaml:"max_fact_bytes"`
+}
+
+type fileFallback struct {
+	Models           []string           `json:"models" yaml:"models"`
+	Endpoints        []FallbackEndpoint `json:"endpoints" yaml:"endpoints"`
+	DegradedResponse string             `json:"degraded_response" yaml:"degraded_response"`
+	Retries          int                `json:"retries" yaml:"retries"`
+	RetryBackoff     string             `json:"retry_backoff" yaml:"retry_backoff"`
+	MaxBackoff       string             `json:"max_backoff" yaml:"max_backoff"`
+	FailureThreshold int                `json:"failure_threshold" yaml:"failure_threshold"`
+	Cooldown         string             `json:"cooldown" yaml:"cooldown"`
+	PrimaryShare     float64            `json:"primary_share" yaml:"primary_share"`
+}
+
+type fileReminders struct {
+	Enabled        bool   `json:"enabled" yaml:"enabled"`
+	File           string `json:"file" yaml:"file"`
+	Interval       string `json:"interval" yaml:"interval"`
+	Timezone       string `json:"timezone" yaml:"timezone"`
+	WebhookURL     string `json:"webhook_url" yaml:"webhook_url"`
+	WebhookSecret  string `json:"webhook_secret" yaml:"webhook_secret"`
+	WebhookTimeout string `json:"webhook_timeout" yaml:"webhook_timeout"`
+	MaxPerUser     int    `json:"max_per_user" yaml:"max_per_user"`
+}
+
+// fileTier leaves out fields the file does not set, so a partial tier keeps
+// the rest of its current values.
+type fileTier struct {
+	MaxHistoryItems *int `json:"max_history_items" yaml:"max_history_items"`
+	MaxContextSize  *int `json:"max_context_size" yaml:"max_context_size"`
+}
+
+type fileConfig struct {
+	ListenAddr         string              `json:"listen_addr" yaml:"listen_addr"`
+	OllamaURL          string              `json:"ollama_url" yaml:"ollama_url"`
+	Model              string              `json:"model" yaml:"model"`
+	MaxHistory         int                 `json:"max_history" yaml:"max_history"`
+	Timeouts           fileTimeouts        `json:"timeouts" yaml:"timeouts"`
+	ComplexityKeywords []string            `json:"complexity_keywords" yaml:"complexity_keywords"`
+	Tiers              map[string]fileTier `json:"tiers" yaml:"tiers"`
+	Adaptive           fileAdaptive        `json:"adaptive_timeouts" yaml:"adaptive_timeouts"`
+	Tools              fileTools           `json:"tools" yaml:"tools"`
+	Documents          fileDocuments       `json:"documents" yaml:"documents"`
+	Memory             fileMemory          `json:"memory" yaml:"memory"`
+	Fallback           fileFallback        `json:"fallback" yaml:"fallback"`
+	Reminders          fileReminders       `json:"reminders" yaml:"reminders"`
+}
+
+var tierNames = []string{"short", "medium", "long", "default"}
+
+func defaultConfig() *Config {
+	return &Config{
+		ListenAddr: ":8080",
+		OllamaURL:  "http://localhost:11434/api/generate",
+		Model:      "llama3.2:1b",
+		MaxHistory: 50,
+		Timeouts:   defaultTimeouts,
+		ComplexityKeywords: []string{
+			"analyze", "explain in detail", "write a story", "create a plan",
+			"summarize", "compare", "research", "detailed analysis",
+			"step by step", "comprehensive", "thorough", "elaborate", "create",
+		},
+		Tiers: map[string]TierConfig{
+			"short":   {MaxHistoryItems: 2, MaxContextSize: 2000},
+			"medium":  {MaxHistoryItems: 5, MaxContextSize: 6000},
+			"long":    {MaxHistoryItems: 10, MaxContextSize: 15000},
+			"default": {MaxHistoryItems: 3, MaxContextSize: 4000},
+		},
+		Adaptive: AdaptiveConfig{
+			Enabled:    true,
+			Percentile: 0.95,
+			Margin:     0.5,
+			MinSamples: 5,
+			Min:        10 * time.Second,
+			Max:        10 * time.Minute,
+		},
+		Tools: ToolsConfig{
+			Enabled:    true,
+			MaxSteps:   4,
+			Timeout:    5 * time.Second,
+			SandboxDir: "./documents",
+		},
+		Documents: DocumentsConfig{
+			Enabled:        true,
+			Dir:            "./workspaces",
+			EmbeddingURL:   "http://localhost:11434/api/embed",
+			EmbeddingModel: "nomic-embed-text",
+			Timeout:        time.Minute,
+			ChunkSize:      1000,
+			ChunkOverlap:   150,
+			TopK:           4,
+			MinScore:       0.3,
+			MaxUploadBytes: 10 * 1024 * 1024,
+		},
+		Memory: MemoryConfig{
+			IdleTTL:         24 * time.Hour,
+			JanitorInterval: 5 * time.Minute,
+			ArchiveDir:      "./archive",
+			MaxHistoryBytes: 512 * 1024,
+			MaxFactBytes:    16 * 1024,
+		},
+		Fallback: FallbackConfig{
+			DegradedResponse: "The assistant is temporarily unavailable. Please try again in a few minutes.",
+			Retries:          2,
+			RetryBackoff:     250 * time.Millisecond,
+			MaxBackoff:       2 * time.Second,
+			FailureThreshold: 5,
+			Cooldown:         30 * time.Second,
+			PrimaryShare:     0.6,
+		},
+		Reminders: RemindersConfig{
+			Enabled:        true,
+			File:           "./reminders.json",
+			Interval:       time.Second,
+			WebhookTimeout: 10 * time.Second,
+			MaxPerUser:     100,
+		},
+	}
+}
+
+func (c *Config) tier(timeoutType string) TierConfig {
+	if t, ok := c.Tiers[timeoutType]; ok {
+		return t
+	}
+	return c.Tiers["default"]
+}
+
+var activeConfig atomic.Pointer[Config]
+
+func currentConfig() *Config {
+	if cfg := activeConfig.Load(); cfg != nil {
+		return cfg
+	}
+	return defaultConfig()
+}
+
+// loadConfig builds the configuration from defaults, then the optional
+// config file at path, then environment variables, and validates the result.
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		if err := applyConfigFile(cfg, path); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+func applyConfigFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// Start from the current values so that omitted keys keep their defaults
+	fc := fileConfig{
+		ListenAddr: cfg.ListenAddr,
+		OllamaURL:  cfg.OllamaURL,
+		Model:      cfg.Model,
+		MaxHistory: cfg.MaxHistory,
+		Timeouts: fileTimeouts{
+			Short:       cfg.Timeouts.ShortRequest.String(),
+			Medium:      cfg.Timeouts.MediumRequest.String(),
+			Long:        cfg.Timeouts.LongRequest.String(),
+			HTTPClient:  cfg.Timeouts.HTTPClient.String(),
+			ServerRead:  cfg.Timeouts.ServerRead.String(),
+			ServerWrite: cfg.Timeouts.ServerWrite.String(),
+		},
+		ComplexityKeywords: cfg.ComplexityKeywords,
+		Adaptive: fileAdaptive{
+			Enabled:    cfg.Adaptive.Enabled,
+			Percentile: cfg.Adaptive.Percentile,
+			Margin:     cfg.Adaptive.Margin,
+			MinSamples: cfg.Adaptive.MinSamples,
+			Min:        cfg.Adaptive.Min.String(),
+			Max:        cfg.Adaptive.Max.String(),
+		},
+		Tools: fileTools{
+			Enabled:    cfg.Tools.Enabled,
+			MaxSteps:   cfg.Tools.MaxSteps,
+			Timeout:    cfg.Tools.Timeout.String(),
+			SandboxDir: cfg.Tools.SandboxDir,
+		},
+		Documents: fileDocuments{
+			Enabled:        cfg.Documents.Enabled,
+			Dir:            cfg.Documents.Dir,
+			EmbeddingURL:   cfg.Documents.EmbeddingURL,
+			EmbeddingModel: cfg.Documents.EmbeddingModel,
+			Timeout:        cfg.Documents.Timeout.String(),
+			ChunkSize:      cfg.Documents.ChunkSize,
+			ChunkOverlap:   cfg.Documents.ChunkOverlap,
+			TopK:           cfg.Documents.TopK,
+			MinScore:       cfg.Documents.MinScore,
+			MaxUploadBytes: cfg.Documents.MaxUploadBytes,
+		},
+		Memory: fileMemory{
+			IdleTTL:         cfg.Memory.IdleTTL.String(),
+			JanitorInterval: cfg.Memory.JanitorInterval.String(),
+			ArchiveDir:      cfg.Memory.ArchiveDir,
+			MaxHistoryBytes: cfg.Memory.MaxHistoryBytes,
+			MaxFactBytes:    cfg.Memory.MaxFactBytes,
+		},
+		Fallback: fileFallback{
+			Models:           cfg.Fallback.Models,
+			Endpoints:        cfg.Fallback.Endpoints,
+			DegradedResponse: cfg.Fallback.DegradedResponse,
+			Retries:          cfg.Fallback.Retries,
+			RetryBackoff:     cfg.Fallback.RetryBackoff.String(),
+			MaxBackoff:       cfg.Fallback.MaxBackoff.String(),
+			FailureThreshold: cfg.Fallback.FailureThreshold,
+			Cooldown:         cfg.Fallback.Cooldown.String(),
+			PrimaryShare:     cfg.Fallback.PrimaryShare,
+		},
+		Reminders: fileReminders{
+			Enabled:        cfg.Reminders.Enabled,
+			File:           cfg.Reminders.File,
+			Interval:       cfg.Reminders.Interval.String(),
+			Timezone:       cfg.Reminders.Timezone,
+			WebhookURL:     cfg.Reminders.WebhookURL,
+			WebhookSecret:  cfg.Reminders.WebhookSecret,
+			WebhookTimeout: cfg.Reminders.WebhookTimeout.String(),
+			MaxPerUser:     cfg.Reminders.MaxPerUser,
+		},
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&fc)
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&fc)
+	default:
+		return fmt.Errorf("unsupported config file extension %q (use .json, .yaml or .yml)", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	cfg.ListenAddr = fc.ListenAddr
+	cfg.OllamaURL = fc.OllamaURL
+	cfg.Model = fc.Model
+	cfg.MaxHistory = fc.MaxHistory
+	cfg.ComplexityKeywords = make([]string, len(fc.ComplexityKeywords))
+	for i, keyword := range fc.ComplexityKeywords {
+		cfg.ComplexityKeywords[i] = strings.ToLower(keyword)
+	}
+	cfg.Tiers = mergeTiers(cfg.Tiers, fc.Tiers)
+	cfg.Adaptive.Enabled = fc.Adaptive.Enabled
+	cfg.Adaptive.Percentile = fc.Adaptive.Percentile
+	cfg.Adaptive.Margin = fc.Adaptive.Margin
+	cfg.Adaptive.MinSamples = fc.Adaptive.MinSamples
+	cfg.Tools.Enabled = fc.Tools.Enabled
+	cfg.Tools.MaxSteps = fc.Tools.MaxSteps
+	cfg.Tools.SandboxDir = fc.Tools.SandboxDir
+	cfg.Documents.Enabled = fc.Documents.Enabled
+	cfg.Documents.Dir = fc.Documents.Dir
+	cfg.Documents.EmbeddingURL = fc.Documents.EmbeddingURL
+	cfg.Documents.EmbeddingModel = fc.Documents.EmbeddingModel
+	cfg.Documents.ChunkSize = fc.Documents.ChunkSize
+	cfg.Documents.ChunkOverlap = fc.Documents.ChunkOverlap
+	cfg.Documents.TopK = fc.Documents.TopK
+	cfg.Documents.MinScore = fc.Documents.MinScore
+	cfg.Documents.MaxUploadBytes = fc.Documents.MaxUploadBytes
+	cfg.Memory.ArchiveDir = fc.Memory.ArchiveDir
+	cfg.Memory.MaxHistoryBytes = fc.Memory.MaxHistoryBytes
+	cfg.Memory.MaxFactBytes = fc.Memory.MaxFactBytes
+	cfg.Fallback.Models = fc.Fallback.Models
+	cfg.Fallback.Endpoints = fc.Fallback.Endpoints
+	cfg.Fallback.DegradedResponse = fc.Fallback.DegradedResponse
+	cfg.Fallback.Retries = fc.Fallback.Retries
+	cfg.Fallback.FailureThreshold = fc.Fallback.FailureThreshold
+	cfg.Fallback.PrimaryShare = fc.Fallback.PrimaryShare
+	cfg.Reminders.Enabled = fc.Reminders.Enabled
+	cfg.Reminders.File = fc.Reminders.File
+	cfg.Reminders.Timezone = fc.Reminders.Timezone
+	cfg.Reminders.WebhookURL = fc.Reminders.WebhookURL
+	cfg.Reminders.WebhookSecret = fc.Reminders.WebhookSecret
+	cfg.Reminders.MaxPerUser = fc.Reminders.MaxPerUser
+
+	durations := []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"timeouts.short", fc.Timeouts.Short, &cfg.Timeouts.ShortRequest},
+		{"timeouts.medium", fc.Timeouts.Medium, &cfg.Timeouts.MediumRequest},
+		{"timeouts.long", fc.Timeouts.Long, &cfg.Timeouts.LongRequest},
+		{"timeouts.http_client", fc.Timeouts.HTTPClient, &cfg.Timeouts.HTTPClient},
+		{"timeouts.server_read", fc.Timeouts.ServerRead, &cfg.Timeouts.ServerRead},
+		{"timeouts.server_write", fc.Timeouts.ServerWrite, &cfg.Timeouts.ServerWrite},
+		{"adaptive_timeouts.min", fc.Adaptive.Min, &cfg.Adaptive.Min},
+		{"adaptive_timeouts.max", fc.Adaptive.Max, &cfg.Adaptive.Max},
+		{"tools.timeout", fc.Tools.Timeout, &cfg.Tools.Timeout},
+		{"documents.timeout", fc.Documents.Timeout, &cfg.Documents.Timeout},
+		{"memory.idle_ttl", fc.Memory.IdleTTL, &cfg.Memory.IdleTTL},
+		{"memory.janitor_interval", fc.Memory.JanitorInterval, &cfg.Memory.JanitorInterval},
+		{"fallback.retry_backoff", fc.Fallback.RetryBackoff, &cfg.Fallback.RetryBackoff},
+		{"fallback.max_backoff", fc.Fallback.MaxBackoff, &cfg.Fallback.MaxBackoff},
+		{"fallback.cooldown", fc.Fallback.Cooldown, &cfg.Fallback.Cooldown},
+		{"reminders.interval", fc.Reminders.Interval, &cfg.Reminders.Interval},
+		{"reminders.webhook_timeout", fc.Reminders.WebhookTimeout, &cfg.Reminders.WebhookTimeout},
+	}
+	for _, d := range durations {
+		parsed, err := parseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", d.name, err)
+		}
+		*d.dest = parsed
+	}
+
+	return nil
+}
+
+// applyEnv overrides configuration values from environment variables.
+func applyEnv(cfg *Config) error {
+	durations := []struct {
+		env  string
+		dest *time.Duration
+	}{
+		{"SHORT_TIMEOUT", &cfg.Timeouts.ShortRequest},
+		{"MEDIUM_TIMEOUT", &cfg.Timeouts.MediumRequest},
+		{"LONG_TIMEOUT", &cfg.Timeouts.LongRequest},
+		{"HTTP_CLIENT_TIMEOUT", &cfg.Timeouts.HTTPClient},
+		{"SERVER_READ_TIMEOUT", &cfg.Timeouts.ServerRead},
+		{"SERVER_WRITE_TIMEOUT", &cfg.Timeouts.ServerWrite},
+		{"IDLE_TTL", &cfg.Memory.IdleTTL},
+	}
+	for _, d := range durations {
+		value, err := getEnvDuration(d.env, *d.dest)
+		if err != nil {
+			return err
+		}
+		*d.dest = value
+	}
+
+	if v := os.Getenv("LISTEN_ADDR"); v != "" {
+		cfg.ListenAddr = v
+	}
+	if v := os.Getenv("OLLAMA_URL"); v != "" {
+		cfg.OllamaURL = v
+	}
+	if v := os.Getenv("MODEL_NAME"); v != "" {
+		cfg.Model = v
+	}
+	if v := os.Getenv("MAX_HISTORY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("MAX_HISTORY: %w", err)
+		}
+		cfg.MaxHistory = n
+	}
+	if v := os.Getenv("ADAPTIVE_TIMEOUTS"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("ADAPTIVE_TIMEOUTS: %w", err)
+		}
+		cfg.Adaptive.Enabled = enabled
+	}
+	if v := os.Getenv("TOOLS_ENABLED"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("TOOLS_ENABLED: %w", err)
+		}
+		cfg.Tools.Enabled = enabled
+	}
+	if v := os.Getenv("TOOL_SANDBOX_DIR"); v != "" {
+		cfg.Tools.SandboxDir = v
+	}
+	if v := os.Getenv("DOCUMENTS_ENABLED"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("DOCUMENTS_ENABLED: %w", err)
+		}
+		cfg.Documents.Enabled = enabled
+	}
+	if v := os.Getenv("DOCUMENTS_DIR"); v != "" {
+		cfg.Documents.Dir = v
+	}
+	if v := os.Getenv("EMBEDDING_URL"); v != "" {
+		cfg.Documents.EmbeddingURL = v
+	}
+	if v := os.Getenv("EMBEDDING_MODEL"); v != "" {
+		cfg.Documents.EmbeddingModel = v
+	}
+	if v, ok := os.LookupEnv("ARCHIVE_DIR"); ok {
+		cfg.Memory.ArchiveDir = v
+	}
+	if v := os.Getenv("FALLBACK_MODELS"); v != "" {
+		cfg.Fallback.Models = splitList(v)
+	}
+	if v := os.Getenv("FALLBACK_URL"); v != "" {
+		cfg.Fallback.Endpoints = []FallbackEndpoint{{URL: v, Model: os.Getenv("FALLBACK_URL_MODEL")}}
+	}
+	if v := os.Getenv("REMINDERS_ENABLED"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("REMINDERS_ENABLED: %w", err)
+		}
+		cfg.Reminders.Enabled = enabled
+	}
+	if v := os.Getenv("REMINDERS_FILE"); v != "" {
+		cfg.Reminders.File = v
+	}
+	if v := os.Getenv("REMINDER_TIMEZONE"); v != "" {
+		cfg.Reminders.Timezone = v
+	}
+	if v, ok := os.LookupEnv("REMINDER_WEBHOOK_URL"); ok {
+		cfg.Reminders.WebhookURL = v
+	}
+	if v := os.Getenv("REMINDER_WEBHOOK_SECRET"); v != "" {
+		cfg.Reminders.WebhookSecret = v
+	}
+	if v := os.Getenv("COMPLEXITY_KEYWORDS"); v != "" {
+		cfg.ComplexityKeywords = splitList(strings.ToLower(v))
+	}
+	return nil
+}
+
+// mergeTiers applies the tiers set in a config file over the current ones.
+// Tiers the file does not name are kept unchanged.
+func mergeTiers(current map[string]TierConfig, file map[string]fileTier) map[string]TierConfig {
+	merged := make(map[string]TierConfig, len(current)+len(file))
+	for name, tier := range current {
+		merged[name] = tier
+	}
+	for name, ft := range file {
+		tier := merged[name]
+		if ft.MaxHistoryItems != nil {
+			tier.MaxHistoryItems = *ft.MaxHistoryItems
+		}
+		if ft.MaxContextSize != nil {
+			tier.MaxContextSize = *ft.MaxContextSize
+		}
+		merged[name] = tier
+	}
+	return merged
+}
+
+// splitList splits a comma-separated environment value, dropping blanks.
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseDuration accepts Go duration strings ("90s", "2m") as well as a
+// plain number of seconds.
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func (c *Config) validate() error {
+	var errs []error
+
+	if c.ListenAddr == "" {
+		errs = append(errs, errors.New("listen_addr must not be empty"))
+	}
+	if u, err := url.Parse(c.OllamaURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("ollama_url %q must be an http(s) URL", c.OllamaURL))
+	}
+	if strings.TrimSpace(c.Model) == "" {
+		errs = append(errs, errors.New("model must not be empty"))
+	}
+	if c.MaxHistory <= 0 {
+		errs = append(errs, fmt.Errorf("max_history must be positive, got %d", c.MaxHistory))
+	}
+
+	t := c.Timeouts
+	for name, d := range map[string]time.Duration{
+		"short": t.ShortRequest, "medium": t.MediumRequest, "long": t.LongRequest,
+		"http_client": t.HTTPClient, "server_read": t.ServerRead, "server_write": t.ServerWrite,
+	} {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("timeouts.%s must be positive, got %s", name, d))
+		}
+	}
+	if t.ShortRequest > t.MediumRequest || t.MediumRequest > t.LongRequest {
+		errs = append(errs, fmt.Errorf("timeouts must satisfy short <= medium <= long, got %s/%s/%s",
+			t.ShortRequest, t.MediumRequest, t.LongRequest))
+	}
+	if t.HTTPClient < t.LongRequest {
+		errs = append(errs, fmt.Errorf("timeouts.http_client (%s) must be at least timeouts.long (%s)", t.HTTPClient, t.LongRequest))
+	}
+	if t.ServerWrite < t.LongRequest {
+		errs = append(errs, fmt.Errorf("timeouts.server_write (%s) must be at least timeouts.long (%s)", t.ServerWrite, t.LongRequest))
+	}
+
+	for _, name := range tierNames {
+		tier, ok := c.Tiers[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("tiers.%s is missing", name))
+			continue
+		}
+		if tier.MaxHistoryItems < 0 || tier.MaxContextSize <= 0 {
+			errs = append(errs, fmt.Errorf("tiers.%s must have max_history_items >= 0 and max_context_size > 0", name))
+		}
+	}
+	for name := range c.Tiers {
+		if !contains(tierNames, name) {
+			errs = append(errs, fmt.Errorf("unknown tier %q (expected one of %s)", name, strings.Join(tierNames, ", ")))
+		}
+	}
+
+	a := c.Adaptive
+	if a.Percentile <= 0 || a.Percentile > 1 {
+		errs = append(errs, fmt.Errorf("adaptive_timeouts.percentile must be in (0, 1], got %g", a.Percentile))
+	}
+	if a.Margin < 0 {
+		errs = append(errs, fmt.Errorf("adaptive_timeouts.margin must not be negative, got %g", a.Margin))
+	}
+	if a.MinSamples < 1 {
+		errs = append(errs, fmt.Errorf("adaptive_timeouts.min_samples must be at least 1, got %d", a.MinSamples))
+	}
+	if a.Min <= 0 || a.Max < a.Min {
+		errs = append(errs, fmt.Errorf("adaptive_timeouts must satisfy 0 < min <= max, got %s/%s", a.Min, a.Max))
+	}
+
+	if c.Tools.MaxSteps < 1 || c.Tools.MaxSteps > 10 {
+		errs = append(errs, fmt.Errorf("tools.max_steps must be between 1 and 10, got %d", c.Tools.MaxSteps))
+	}
+	if c.Tools.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("tools.timeout must be positive, got %s", c.Tools.Timeout))
+	}
+	if c.Tools.Enabled && strings.TrimSpace(c.Tools.SandboxDir) == "" {
+		errs = append(errs, errors.New("tools.sandbox_dir must not be empty when tools are enabled"))
+	}
+
+	d := c.Documents
+	if d.Enabled {
+		if strings.TrimSpace(d.Dir) == "" {
+			errs = append(errs, errors.New("documents.dir must not be empty when documents are enabled"))
+		}
+		if u, err := url.Parse(d.EmbeddingURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("documents.embedding_url %q must be an http(s) URL", d.EmbeddingURL))
+		}
+		if strings.TrimSpace(d.EmbeddingModel) == "" {
+			errs = append(errs, errors.New("documents.embedding_model must not be empty"))
+		}
+	}
+	if d.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("documents.timeout must be positive, got %s", d.Timeout))
+	}
+	if d.ChunkSize < 100 || d.ChunkOverlap < 0 || d.ChunkOverlap >= d.ChunkSize/2 {
+		errs = append(errs, fmt.Errorf("documents must satisfy chunk_size >= 100 and 0 <= chunk_overlap < chunk_size/2, got %d/%d", d.ChunkSize, d.ChunkOverlap))
+	}
+	if d.TopK < 1 || d.TopK > 20 {
+		errs = append(errs, fmt.Errorf("documents.top_k must be between 1 and 20, got %d", d.TopK))
+	}
+	if d.MinScore < -1 || d.MinScore > 1 {
+		errs = append(errs, fmt.Errorf("documents.min_score must be between -1 and 1, got %g", d.MinScore))
+	}
+	if d.MaxUploadBytes <= 0 {
+		errs = append(errs, fmt.Errorf("documents.max_upload_bytes must be positive, got %d", d.MaxUploadBytes))
+	}
+
+	f := c.Fallback
+	for i, model := range f.Models {
+		if strings.TrimSpace(model) == "" {
+			errs = append(errs, fmt.Errorf("fallback.models[%d] must not be empty", i))
+		}
+	}
+	for i, ep := range f.Endpoints {
+		if u, err := url.Parse(ep.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("fallback.endpoints[%d].url %q must be an http(s) URL", i, ep.URL))
+		}
+	}
+	if f.Retries < 0 || f.Retries > 5 {
+		errs = append(errs, fmt.Errorf("fallback.retries must be between 0 and 5, got %d", f.Retries))
+	}
+	if f.RetryBackoff <= 0 || f.MaxBackoff < f.RetryBackoff {
+		errs = append(errs, fmt.Errorf("fallback must satisfy 0 < retry_backoff <= max_backoff, got %s/%s", f.RetryBackoff, f.MaxBackoff))
+	}
+	if f.FailureThreshold < 1 {
+		errs = append(errs, fmt.Errorf("fallback.failure_threshold must be at least 1, got %d", f.FailureThreshold))
+	}
+	if f.Cooldown <= 0 {
+		errs = append(errs, fmt.Errorf("fallback.cooldown must be positive, got %s", f.Cooldown))
+	}
+	if f.PrimaryShare <= 0 || f.PrimaryShare > 1 {
+		errs = append(errs, fmt.Errorf("fallback.primary_share must be in (0, 1], got %g", f.PrimaryShare))
+	}
+
+	m := c.Memory
+	if m.IdleTTL < 0 {
+		errs = append(errs, fmt.Errorf("memory.idle_ttl must not be negative, got %s", m.IdleTTL))
+	}
+	if m.JanitorInterval < time.Second {
+		errs = append(errs, fmt.Errorf("memory.janitor_interval must be at least 1s, got %s", m.JanitorInterval))
+	}
+	if m.MaxHistoryBytes <= 0 || m.MaxFactBytes <= 0 {
+		errs = append(errs, fmt.Errorf("memory quotas must be positive, got max_history_bytes=%d max_fact_bytes=%d", m.MaxHistoryBytes, m.MaxFactBytes))
+	}
+
+	rem := c.Reminders
+	if rem.Enabled && strings.TrimSpace(rem.File) == "" {
+		errs = append(errs, errors.New("reminders.file must not be empty when reminders are enabled"))
+	}
+	if rem.Interval < 100*time.Millisecond {
+		errs = append(errs, fmt.Errorf("reminders.interval must be at least 100ms, got %s", rem.Interval))
+	}
+	if rem.Timezone != "" {
+		if _, err := time.LoadLocation(rem.Timezone); err != nil {
+			errs = append(errs, fmt.Errorf("reminders.timezone %q is not a known time zone", rem.Timezone))
+		}
+	}
+	if rem.WebhookURL != "" {
+		if u, err := url.Parse(rem.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("reminders.webhook_url %q must be an http(s) URL", rem.WebhookURL))
+		}
+	}
+	if rem.WebhookTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("reminders.webhook_timeout must be positive, got %s", rem.WebhookTimeout))
+	}
+	if rem.MaxPerUser < 1 {
+		errs = append(errs, fmt.Errorf("reminders.max_per_user must be at least 1, got %d", rem.MaxPerUser))
+	}
+
+	return errors.Join(errs...)
+}
+
+// durationLabel renders a duration compactly for timeout labels, e.g.
+// 30s, 2m, 1m30s, 1h.
+func durationLabel(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// applyConfig makes cfg the active configuration.
+func applyConfig(cfg *Config) {
+	activeConfig.Store(cfg)
+	memoryStore.applyConfig(cfg.MaxHistory, cfg.Timeouts)
+}
+
+// watchConfigReload reloads the configuration on SIGHUP. An invalid
+// configuration is rejected and the current one stays active.
+func watchConfigReload(path string) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	for range sighup {
+		cfg, err := loadConfig(path)
+		if err != nil {
+			logger.Error("config reload failed, keeping current configuration", "error", err.Error())
+			continue
+		}
+
+		old := currentConfig()
+		if cfg.ListenAddr != old.ListenAddr || cfg.Timeouts.ServerRead != old.Timeouts.ServerRead || cfg.Timeouts.ServerWrite != old.Timeouts.ServerWrite {
+			logger.Warn("listen_addr and server timeouts only take effect after a restart")
+		}
+
+		applyConfig(cfg)
+		logger.Info("configuration reloaded", "path", path, "model", cfg.Model, "max_history", cfg.MaxHistory)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFileMergesTiersAndLowercasesKeywords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := "complexity_keywords: [Analyze, \"Step By Step\"]\ntiers:\n  long:\n    max_context_size: 20000\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := defaultConfig()
+	if err := applyConfigFile(cfg, path); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := cfg.ComplexityKeywords; len(got) != 2 || got[0] != "analyze" || got[1] != "step by step" {
+		t.Errorf("keywords %q", got)
+	}
+	if long := cfg.Tiers["long"]; long.MaxContextSize != 20000 || long.MaxHistoryItems != 10 {
+		t.Errorf("long tier %+v, want the default history with the new context size", long)
+	}
+	if short := cfg.Tiers["short"]; short != defaultConfig().Tiers["short"] {
+		t.Errorf("short tier %+v changed", short)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("merged config invalid: %v", err)
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	maxImportBytes     = 5 * 1024 * 1024
+	maxImportResponse  = 100000
+	maxImportErrors    = 20
+	markdownUserMark   = "**User:**"
+	markdownAnswerMark = "**Assistant:**"
+)
+
+// conversationExport is the JSON export format. Import also accepts a bare
+// array of conversations.
+type conversationExport struct {
+	User          string         `json:"user"`
+	SessionID     string         `json:"session_id,omitempty"`
+	ExportedAt    time.Time      `json:"exported_at"`
+	Conversations []Conversation `json:"conversations"`
+}
+
+// chatMessage and chatRecord are the Ollama/OpenAI chat format used for
+// JSONL: one record per line, each holding a user/assistant exchange.
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRecord struct {
+	ID        string        `json:"id,omitempty"`
+	SessionID string        `json:"session_id,omitempty"`
+	Timestamp *time.Time    `json:"timestamp,omitempty"`
+	Messages  []chatMessage `json:"messages"`
+}
+
+type importResult struct {
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"`
+	Trimmed    int `json:"trimmed"` // dropped by max_history or the history quota
+}
+
+func conversationHash(prompt, response string) string {
+	sum := sha256.Sum256([]byte(prompt + "\x00" + response))
+	return hex.EncodeToString(sum[:])
+}
+
+// conversationsForExport returns a copy of the user's history, limited to
+// one session when sessionID is set.
+func (ms *MemoryStore) conversationsForExport(username, sessionID string) []Conversation {
+	ms.RLock()
+	defer ms.RUnlock()
+
+	user := ms.users[username]
+	if user == nil {
+		return nil
+	}
+	result := make([]Conversation, 0, len(user.Conversations))
+	for _, conv := range user.Conversations {
+		if sessionID == "" || conv.SessionID == sessionID {
+			result = append(result, conv)
+		}
+	}
+	return result
+}
+
+// importConversations merges imported turns into the user's history in
+// timestamp order so buildContext sees them like any other turn. Turns
+// whose prompt and response match an existing turn are skipped.
+func (ms *MemoryStore) importConversations(username string, imported []Conversation) importResult {
+	ms.Lock()
+	defer ms.Unlock()
+
+	var result importResult
+	user := ms.users[username]
+	if user == nil {
+		return result
+	}
+
+	seen := make(map[string]bool, len(user.Conversations))
+	ids := make(map[string]bool, len(user.Conversations))
+	for _, conv := range user.Conversations {
+		seen[conversationHash(conv.Prompt, conv.Response)] = true
+		ids[conv.ID] = true
+	}
+
+	added := make(map[string]bool)
+	for _, conv := range imported {
+		hash := conversationHash(conv.Prompt, conv.Response)
+		if seen[hash] {
+			result.Duplicates++
+			continue
+		}
+		seen[hash] = true
+
+		conv.User = username
+		if conv.ID == "" || ids[conv.ID] {
+			conv.ID = fmt.Sprintf("%s_%d", username, conv.Timestamp.UnixNano())
+			for n := 1; ids[conv.ID]; n++ {
+				conv.ID = fmt.Sprintf("%s_%d_%d", username, conv.Timestamp.UnixNano(), n)
+			}
+		}
+		ids[conv.ID] = true
+		added[conv.ID] = true
+
+		user.Conversations = append(user.Conversations, conv)
+		ms.extractPersonalInfo(user, conv.Prompt, conv.Response)
+	}
+
+	sort.SliceStable(user.Conversations, func(i, j int) bool {
+		return user.Conversations[i].Timestamp.Before(user.Conversations[j].Timestamp)
+	})
+	if len(user.Conversations) > ms.maxHistory {
+		user.Conversations = user.Conversations[len(user.Conversations)-ms.maxHistory:]
+	}
+	ms.enforceQuota(user)
+
+	for _, conv := range user.Conversations {
+		if added[conv.ID] {
+			result.Imported++
+		}
+	}
+	result.Trimmed = len(added) - result.Imported
+	return result
+}
+
+// writeMarkdownExport writes one section per turn. Prompts and responses
+// are fenced, so headings and marks inside them are not read back as
+// section boundaries.
+func writeMarkdownExport(w io.Writer, username string, conversations []Conversation) {
+	fmt.Fprintf(w, "# Conversations: %s\n\n", username)
+	for _, conv := range conversations {
+		fmt.Fprintf(w, "## %s\n", conv.Timestamp.UTC().Format(time.RFC3339Nano))
+		fmt.Fprintf(w, "<!-- id: %s session: %s -->\n\n", commentValue(conv.ID), commentValue(conv.SessionID))
+		fence := markdownFence(conv.Prompt)
+		fmt.Fprintf(w, "%s\n\n%s\n%s\n%s\n\n", markdownUserMark, fence, conv.Prompt, fence)
+		fence = markdownFence(conv.Response)
+		fmt.Fprintf(w, "%s\n\n%s\n%s\n%s\n\n---\n\n", markdownAnswerMark, fence, conv.Response, fence)
+	}
+}
+
+// commentValue quotes an id for the section comment. encoding/json escapes
+// '<' and '>', so the value can neither contain spaces nor end the comment.
+func commentValue(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// commentField matches "id: value" in a section comment. Values are quoted
+// by commentValue; bare ones come from older exports.
+var commentField = regexp.MustCompile(`(id|session):\s*("(?:[^"\\]|\\.)*"|[^\s"]*)`)
+
+// markdownFence returns a backtick fence longer than any backtick run in
+// text, so the text cannot close it.
+func markdownFence(text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+// isMarkdownFence reports whether line opens a backtick fence.
+func isMarkdownFence(line string) bool {
+	return len(line) >= 3 && strings.Trim(line, "`") == ""
+}
+
+func writeJSONLExport(w io.Writer, conversations []Conversation) {
+	enc := json.NewEncoder(w)
+	for _, conv := range conversations {
+		ts := conv.Timestamp
+		enc.Encode(chatRecord{
+			ID:        conv.ID,
+			SessionID: conv.SessionID,
+			Timestamp: &ts,
+			Messages: []chatMessage{
+				{Role: "user", Content: conv.Prompt},
+				{Role: "assistant", Content: conv.Response},
+			},
+		})
+	}
+}
+
+// parseJSONImport accepts either the export object or a bare array.
+func parseJSONImport(data []byte) ([]Conversation, error) {
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		var conversations []Conversation
+		if err := json.Unmarshal(trimmed, &conversations); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return conversations, nil
+	}
+	var export conversationExport
+	if err := json.Unmarshal(trimmed, &export); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return export.Conversations, nil
+}
+
+// parseJSONLImport reads one chat record per line. System and tool
+// messages are skipped; every user message must be followed by an
+// assistant message.
+func parseJSONLImport(data []byte) ([]Conversation, []error) {
+	var conversations []Conversation
+	var errs []error
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportBytes)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var record chatRecord
+		if err := json.Unmarshal([]byte(text), &record); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: invalid JSON: %w", line, err))
+			continue
+		}
+
+		var pending *chatMessage
+		var turns []Conversation
+		for i := range record.Messages {
+			msg := record.Messages[i]
+			switch msg.Role {
+			case "system", "tool":
+			case "user":
+				if pending != nil {
+					errs = append(errs, fmt.Errorf("line %d: user message without an assistant reply", line))
+				}
+				pending = &msg
+			case "assistant":
+				if pending == nil {
+					errs = append(errs, fmt.Errorf("line %d: assistant message without a user message", line))
+					continue
+				}
+				conv := Conversation{SessionID: record.SessionID, Prompt: pending.Content, Response: msg.Content}
+				if record.Timestamp != nil {
+					conv.Timestamp = *record.Timestamp
+				}
+				turns = append(turns, conv)
+				pending = nil
+			default:
+				errs = append(errs, fmt.Errorf("line %d: unknown role %q", line, msg.Role))
+			}
+		}
+		if pending != nil {
+			errs = append(errs, fmt.Errorf("line %d: user message without an assistant reply", line))
+		}
+		// The record ID only identifies a record holding a single exchange
+		if len(turns) == 1 {
+			turns[0].ID = record.ID
+		}
+		conversations = append(conversations, turns...)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return conversations, errs
+}
+
+// parseMarkdownImport reads the layout written by writeMarkdownExport.
+// Sections start at "## <timestamp>" lines outside fences. Unfenced bodies,
+// as written by older exports, run up to the next mark, "---" or heading.
+func parseMarkdownImport(data []byte) ([]Conversation, []error) {
+	var conversations []Conversation
+	var errs []error
+
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	pos := 0
+	skipBlank := func() {
+		for pos < len(lines) && strings.TrimSpace(lines[pos]) == "" {
+			pos++
+		}
+	}
+	// nextSection moves to the next heading, stepping over fenced blocks
+	nextSection := func() {
+		for pos < len(lines) && !strings.HasPrefix(lines[pos], "## ") {
+			if fence := strings.TrimSpace(lines[pos]); isMarkdownFence(fence) {
+				for pos++; pos < len(lines) && strings.TrimSpace(lines[pos]) != fence; pos++ {
+				}
+			}
+			pos++
+		}
+	}
+	// body reads a fenced block, or unfenced lines up to one of the ends
+	body := func(ends ...string) string {
+		skipBlank()
+		if pos < len(lines) && isMarkdownFence(strings.TrimSpace(lines[pos])) {
+			fence := strings.TrimSpace(lines[pos])
+			start := pos + 1
+			for pos = start; pos < len(lines) && strings.TrimSpace(lines[pos]) != fence; pos++ {
+			}
+			text := strings.Join(lines[start:min(pos, len(lines))], "\n")
+			pos++
+			return strings.TrimSpace(text)
+		}
+		start := pos
+		for ; pos < len(lines); pos++ {
+			line := strings.TrimSpace(lines[pos])
+			if strings.HasPrefix(lines[pos], "## ") || contains(ends, line) {
+				break
+			}
+		}
+		return strings.TrimSpace(strings.Join(lines[start:pos], "\n"))
+	}
+
+	nextSection()
+	for n := 1; pos < len(lines); n++ {
+		heading := strings.TrimPrefix(lines[pos], "## ")
+		pos++
+
+		var conv Conversation
+		ts, err := time.Parse(time.RFC3339, strings.TrimSpace(heading))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("section %d: heading must be an RFC 3339 timestamp, got %q", n, heading))
+			nextSection()
+			continue
+		}
+		conv.Timestamp = ts
+
+		skipBlank()
+		if pos < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[pos]), "<!--") {
+			comment, _, _ := strings.Cut(strings.TrimSpace(lines[pos]), "-->")
+			for _, m := range commentField.FindAllStringSubmatch(comment, -1) {
+				value := m[2]
+				if strings.HasPrefix(value, `"`) && json.Unmarshal([]byte(value), &value) != nil {
+					errs = append(errs, fmt.Errorf("section %d: invalid %s %s", n, m[1], m[2]))
+					continue
+				}
+				if m[1] == "id" {
+					conv.ID = value
+				} else {
+					conv.SessionID = value
+				}
+			}
+			pos++
+		}
+
+		skipBlank()
+		if pos >= len(lines) || strings.TrimSpace(lines[pos]) != markdownUserMark {
+			errs = append(errs, fmt.Errorf("section %d: expected %s", n, markdownUserMark))
+			nextSection()
+			continue
+		}
+		pos++
+		conv.Prompt = body(markdownAnswerMark)
+
+		skipBlank()
+		if pos >= len(lines) || strings.TrimSpace(lines[pos]) != markdownAnswerMark {
+			errs = append(errs, fmt.Errorf("section %d: expected %s", n, markdownAnswerMark))
+			nextSection()
+			continue
+		}
+		pos++
+		conv.Response = body("---")
+
+		conversations = append(conversations, conv)
+		nextSection()
+	}
+	if len(conversations) == 0 && len(errs) == 0 {
+		errs = append(errs, errors.New("no conversations found (sections start with \"## <timestamp>\")"))
+	}
+	return conversations, errs
+}
+
+// validateImport checks every turn and fills in missing timestamps.
+func validateImport(conversations []Conversation, now time.Time) []error {
+	var errs []error
+	for i := range conversations {
+		conv := &conversations[i]
+		conv.Prompt = strings.TrimSpace(conv.Prompt)
+		conv.Response = strings.TrimSpace(conv.Response)
+
+		switch {
+		case conv.Prompt == "":
+			errs = append(errs, fmt.Errorf("turn %d: prompt is empty", i+1))
+		case conv.Response == "":
+			errs = append(errs, fmt.Errorf("turn %d: response is empty", i+1))
+		case len(conv.Prompt) > 10000:
+			errs = append(errs, fmt.Errorf("turn %d: prompt too long (max 10000 characters)", i+1))
+		case len(conv.Response) > maxImportResponse:
+			errs = append(errs, fmt.Errorf("turn %d: response too long (max %d characters)", i+1, maxImportResponse))
+		case conv.Timestamp.After(now.Add(time.Minute)):
+			errs = append(errs, fmt.Errorf("turn %d: timestamp %s is in the future", i+1, conv.Timestamp.Format(time.RFC3339)))
+		}
+		if conv.Timestamp.IsZero() {
+			conv.Timestamp = now
+		}
+	}
+	return errs
+}
+
+// handleConversationExport downloads the caller's history, or one
+// session's, as ?format=json (default), markdown or jsonl.
+func handleConversationExport(w http.ResponseWriter, r *http.Request) {
+	username, ok := checkUserAccess(w, r, "conversations_export", r.URL.Query().Get("user"))
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		writeJSONError(w, http.StatusMethodNotAllowed, "Only GET method allowed")
+		return
+	}
+
+	sessionID := r.URL.Query().Get("session_id")
+	conversations := memoryStore.conversationsForExport(username, sessionID)
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="conversations.json"`)
+		json.NewEncoder(w).Encode(conversationExport{
+			User:          username,
+			SessionID:     sessionID,
+			ExportedAt:    time.Now(),
+			Conversations: conversations,
+		})
+	case "markdown", "md":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="conversations.md"`)
+		writeMarkdownExport(w, username, conversations)
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="conversations.jsonl"`)
+		writeJSONLExport(w, conversations)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("unknown format %q (use json, markdown or jsonl)", format))
+	}
+}
+
+// handleConversationImport merges an export in ?format=json (default),
+// markdown or jsonl into the caller's history. Nothing is imported unless
+// every turn is valid. session_id, when given, overrides the session of
+// every imported turn.
+func handleConversationImport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, ok := checkUserAccess(w, r, "conversations_import", r.URL.Query().Get("user"))
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Only POST method allowed")
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxImportBytes+1))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	if len(data) > maxImportBytes {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Import too large (max %d bytes)", maxImportBytes))
+		return
+	}
+
+	var conversations []Conversation
+	var errs []error
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		conversations, err = parseJSONImport(data)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case "markdown", "md":
+		conversations, errs = parseMarkdownImport(data)
+	case "jsonl":
+		conversations, errs = parseJSONLImport(data)
+	default:
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("unknown format %q (use json, markdown or jsonl)", format))
+		return
+	}
+	errs = append(errs, validateImport(conversations, time.Now())...)
+
+	if len(errs) > 0 {
+		messages := make([]string, 0, maxImportErrors)
+		for _, e := range errs {
+			if len(messages) == maxImportErrors {
+				messages = append(messages, fmt.Sprintf("... and %d more", len(errs)-maxImportErrors))
+				break
+			}
+			messages = append(messages, e.Error())
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "Import rejected", "details": messages})
+		return
+	}
+
+	if sessionID := r.URL.Query().Get("session_id"); sessionID != "" {
+		for i := range conversations {
+			conversations[i].SessionID = sessionID
+		}
+	}
+
+	unpin := memoryStore.pinUser(username)
+	result := memoryStore.importConversations(username, conversations)
+	unpin()
+	loggerFromContext(r.Context()).Info("conversations imported", "user", username,
+		"imported", result.Imported, "duplicates", result.Duplicates, "trimmed", result.Trimmed)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMarkdownRoundTrip(t *testing.T) {
+	ts := time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC)
+	conversations := []Conversation{
+		{ID: "alice_1", SessionID: "s1", Timestamp: ts, Prompt: "How do I write a README?", Response: "## Install\n\nRun `make`.\n\n**User:** notes\n\n---\n\n## Usage\n\n```sh\n./app\n```"},
+		{ID: "alice_2", Timestamp: ts.Add(time.Minute), Prompt: "Show a fence:\n````\nx\n````", Response: "Plain answer."},
+		{ID: `imported id: "x" -->`, SessionID: "my session: 2", Timestamp: ts.Add(2 * time.Minute), Prompt: "q", Response: "a"},
+	}
+
+	var buf bytes.Buffer
+	writeMarkdownExport(&buf, "alice", conversations)
+
+	got, errs := parseMarkdownImport(buf.Bytes())
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	if len(got) != len(conversations) {
+		t.Fatalf("got %d conversations, want %d", len(got), len(conversations))
+	}
+	for i, want := range conversations {
+		c := got[i]
+		if c.ID != want.ID || c.SessionID != want.SessionID || !c.Timestamp.Equal(want.Timestamp) {
+			t.Errorf("turn %d: got id %q session %q time %v", i, c.ID, c.SessionID, c.Timestamp)
+		}
+		if c.Prompt != want.Prompt {
+			t.Errorf("turn %d: prompt %q, want %q", i, c.Prompt, want.Prompt)
+		}
+		if c.Response != want.Response {
+			t.Errorf("turn %d: response %q, want %q", i, c.Response, want.Response)
+		}
+	}
+}
+
+func TestMarkdownImportUnfenced(t *testing.T) {
+	data := "# Conversations: bob\n\n## 2025-03-01T12:30:00Z\n<!-- id: bob_1 session:  -->\n\n" +
+		"**User:**\n\nHello\n\n**Assistant:**\n\nHi there\n\n---\n\n"
+
+	got, errs := parseMarkdownImport([]byte(data))
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	if len(got) != 1 || got[0].ID != "bob_1" || got[0].Prompt != "Hello" || got[0].Response != "Hi there" {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestMarkdownImportErrors(t *testing.T) {
+	if _, errs := parseMarkdownImport([]byte("just text")); len(errs) == 0 {
+		t.Error("text without sections parsed without errors")
+	}
+	if _, errs := parseMarkdownImport([]byte("## yesterday\n\n**User:**\n\nx\n\n**Assistant:**\n\ny\n")); len(errs) == 0 {
+		t.Error("invalid timestamp parsed without errors")
+	}
+}
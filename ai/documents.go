@@ -0,0 +1,748 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+const embedBatchSize = 16
+
+// DocumentsConfig controls the per-user document workspace used for
+// retrieval-augmented answers.
+type DocumentsConfig struct {
+	Enabled        bool
+	Dir            string // root directory for all workspaces
+	EmbeddingURL   string // Ollama /api/embed endpoint
+	EmbeddingModel string
+	Timeout        time.Duration // per embedding request
+	ChunkSize      int           // bytes per chunk
+	ChunkOverlap   int           // bytes shared by neighbouring chunks
+	TopK           int           // chunks retrieved per prompt
+	MinScore       float64       // cosine similarity below this is ignored
+	MaxUploadBytes int64
+}
+
+type Document struct {
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	Format         string    `json:"format"` // "text", "markdown", "pdf"
+	Size           int64     `json:"size"`
+	Chunks         int       `json:"chunks"`
+	EmbeddingModel string    `json:"embedding_model"`
+	UploadedAt     time.Time `json:"uploaded_at"`
+	IndexedAt      time.Time `json:"indexed_at"`
+	Stale          bool      `json:"stale,omitempty"` // indexed with a different embedding model
+}
+
+type documentChunk struct {
+	DocumentID string    `json:"document_id"`
+	Index      int       `json:"index"`
+	Offset     int       `json:"offset"` // byte offset in the extracted text
+	Text       string    `json:"text"`
+	Vector     []float32 `json:"vector"`
+}
+
+// Citation is a retrieved chunk returned alongside an answer.
+type Citation struct {
+	DocumentID string  `json:"document_id"`
+	Document   string  `json:"document"`
+	Chunk      int     `json:"chunk"`
+	Offset     int     `json:"offset"`
+	Score      float64 `json:"score"`
+	Text       string  `json:"text"`
+}
+
+// workspace is one user's index, stored as index.json next to the
+// original uploads so documents can be re-indexed later.
+type workspace struct {
+	Documents []*Document     `json:"documents"`
+	Chunks    []documentChunk `json:"chunks"`
+
+	lastUsed time.Time
+	inUse    int // requests using the workspace without holding the lock
+}
+
+// bytes approximates the memory held by the workspace: chunk text and
+// vectors plus document names.
+func (ws *workspace) bytes() int {
+	n := 0
+	for _, doc := range ws.Documents {
+		n += len(doc.ID) + len(doc.Name)
+	}
+	for _, c := range ws.Chunks {
+		n += len(c.Text) + 4*len(c.Vector)
+	}
+	return n
+}
+
+// DocumentStore caches workspaces read from disk. Every change is saved
+// to index.json, so idle workspaces can be dropped and read again later.
+type DocumentStore struct {
+	sync.Mutex
+	workspaces map[string]*workspace // keyed by workspace directory
+	evicted    int
+}
+
+func NewDocumentStore() *DocumentStore {
+	return &DocumentStore{workspaces: make(map[string]*workspace)}
+}
+
+var documentStore = NewDocumentStore()
+
+// workspaceDir hashes the username so it can never escape the root.
+func workspaceDir(root, username string) string {
+	return filepath.Join(root, hashUsername(username))
+}
+
+// load returns the cached workspace for dir, reading it from disk the
+// first time. Callers must hold the lock.
+func (ds *DocumentStore) load(dir string) (*workspace, error) {
+	if ws, ok := ds.workspaces[dir]; ok {
+		ws.lastUsed = time.Now()
+		return ws, nil
+	}
+
+	ws := &workspace{}
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	default:
+		if err := json.Unmarshal(data, ws); err != nil {
+			return nil, fmt.Errorf("failed to parse index: %w", err)
+		}
+	}
+	ws.lastUsed = time.Now()
+	ds.workspaces[dir] = ws
+	return ws, nil
+}
+
+// done releases a workspace taken with inUse++. It takes the lock.
+func (ds *DocumentStore) done(ws *workspace) {
+	ds.Lock()
+	ws.inUse--
+	ds.Unlock()
+}
+
+// evictIdle drops cached workspaces not used since cutoff. Workspaces in
+// use by a reindex or retrieval stay until it finishes.
+func (ds *DocumentStore) evictIdle(cutoff time.Time) int {
+	ds.Lock()
+	defer ds.Unlock()
+
+	evicted := 0
+	for dir, ws := range ds.workspaces {
+		if ws.inUse == 0 && ws.lastUsed.Before(cutoff) {
+			delete(ds.workspaces, dir)
+			evicted++
+		}
+	}
+	ds.evicted += evicted
+	return evicted
+}
+
+type documentUsage struct {
+	Workspaces int            `json:"workspaces"`
+	Bytes      int            `json:"bytes"`
+	Evicted    int            `json:"evicted_total"`
+	byDir      map[string]int // workspace directory -> bytes
+}
+
+func (ds *DocumentStore) usage() documentUsage {
+	ds.Lock()
+	defer ds.Unlock()
+
+	result := documentUsage{Workspaces: len(ds.workspaces), Evicted: ds.evicted, byDir: make(map[string]int, len(ds.workspaces))}
+	for dir, ws := range ds.workspaces {
+		n := ws.bytes()
+		result.byDir[dir] = n
+		result.Bytes += n
+	}
+	return result
+}
+
+// save writes the index atomically. Callers must hold the lock.
+func (ds *DocumentStore) save(dir string, ws *workspace) error {
+	data, err := json.Marshal(ws)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index: %w", err)
+	}
+	tmp := filepath.Join(dir, "index.json.tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(dir, "index.json"))
+}
+
+func (ds *DocumentStore) list(username string, cfg *Config) ([]Document, error) {
+	ds.Lock()
+	defer ds.Unlock()
+
+	ws, err := ds.load(workspaceDir(cfg.Documents.Dir, username))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Document, 0, len(ws.Documents))
+	for _, doc := range ws.Documents {
+		d := *doc
+		d.Stale = d.EmbeddingModel != cfg.Documents.EmbeddingModel
+		result = append(result, d)
+	}
+	return result, nil
+}
+
+// add extracts, chunks and embeds an upload, then stores the original and
+// its chunks in the user's workspace.
+func (ds *DocumentStore) add(ctx context.Context, username, name string, data []byte, cfg *Config) (*Document, error) {
+	format, err := documentFormat(name, data)
+	if err != nil {
+		return nil, err
+	}
+	doc := &Document{
+		ID:         "doc_" + randomHex(8),
+		Name:       name,
+		Format:     format,
+		Size:       int64(len(data)),
+		UploadedAt: time.Now(),
+	}
+
+	chunks, err := indexDocument(ctx, doc, data, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ds.Lock()
+	defer ds.Unlock()
+
+	dir := workspaceDir(cfg.Documents.Dir, username)
+	ws, err := ds.load(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "files"), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "files", doc.ID), data, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to store document: %w", err)
+	}
+
+	ws.Documents = append(ws.Documents, doc)
+	ws.Chunks = append(ws.Chunks, chunks...)
+	if err := ds.save(dir, ws); err != nil {
+		return nil, err
+	}
+	result := *doc
+	return &result, nil
+}
+
+func (ds *DocumentStore) remove(username, id string, cfg *Config) error {
+	ds.Lock()
+	defer ds.Unlock()
+
+	dir := workspaceDir(cfg.Documents.Dir, username)
+	ws, err := ds.load(dir)
+	if err != nil {
+		return err
+	}
+
+	idx := ws.find(id)
+	if idx < 0 {
+		return fmt.Errorf("unknown document %q", id)
+	}
+	ws.Documents = append(ws.Documents[:idx], ws.Documents[idx+1:]...)
+	ws.replaceChunks(id, nil)
+
+	if err := os.Remove(filepath.Join(dir, "files", id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete document: %w", err)
+	}
+	return ds.save(dir, ws)
+}
+
+// reindex re-chunks and re-embeds the given documents from their stored
+// originals, e.g. after changing the chunk size or embedding model. An
+// empty id re-indexes every document in the workspace.
+func (ds *DocumentStore) reindex(ctx context.Context, username, id string, cfg *Config) ([]Document, error) {
+	dir := workspaceDir(cfg.Documents.Dir, username)
+
+	ds.Lock()
+	ws, err := ds.load(dir)
+	var targets []Document
+	if err == nil {
+		ws.inUse++
+		defer ds.done(ws)
+		for _, doc := range ws.Documents {
+			if id == "" || doc.ID == id {
+				targets = append(targets, *doc)
+			}
+		}
+	}
+	ds.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	if id != "" && len(targets) == 0 {
+		return nil, fmt.Errorf("unknown document %q", id)
+	}
+
+	for i := range targets {
+		doc := &targets[i]
+		data, err := os.ReadFile(filepath.Join(dir, "files", doc.ID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", doc.Name, err)
+		}
+		chunks, err := indexDocument(ctx, doc, data, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", doc.Name, err)
+		}
+
+		ds.Lock()
+		// The document may have been deleted while it was being embedded
+		if idx := ws.find(doc.ID); idx >= 0 {
+			*ws.Documents[idx] = *doc
+			ws.replaceChunks(doc.ID, chunks)
+			err = ds.save(dir, ws)
+		}
+		ds.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
+}
+
+// retrieve returns the chunks most similar to query. Chunks embedded with
+// a different model than the configured one are skipped until re-indexed.
+func (ds *DocumentStore) retrieve(ctx context.Context, username, query string, cfg *Config) ([]Citation, error) {
+	ds.Lock()
+	ws, err := ds.load(workspaceDir(cfg.Documents.Dir, username))
+	empty := err == nil && len(ws.Chunks) == 0
+	if err == nil && !empty {
+		ws.inUse++
+	}
+	ds.Unlock()
+	if err != nil || empty {
+		return nil, err
+	}
+	defer ds.done(ws)
+
+	vectors, err := embed(ctx, []string{query}, cfg)
+	if err != nil {
+		return nil, err
+	}
+	queryVector := vectors[0]
+
+	ds.Lock()
+	defer ds.Unlock()
+
+	names := make(map[string]string, len(ws.Documents))
+	for _, doc := range ws.Documents {
+		if doc.EmbeddingModel == cfg.Documents.EmbeddingModel {
+			names[doc.ID] = doc.Name
+		}
+	}
+
+	var results []Citation
+	for _, c := range ws.Chunks {
+		name, ok := names[c.DocumentID]
+		if !ok || len(c.Vector) != len(queryVector) {
+			continue
+		}
+		score := dot(queryVector, c.Vector)
+		if score < cfg.Documents.MinScore {
+			continue
+		}
+		results = append(results, Citation{
+			DocumentID: c.DocumentID,
+			Document:   name,
+			Chunk:      c.Index,
+			Offset:     c.Offset,
+			Score:      math.Round(score*1000) / 1000,
+			Text:       c.Text,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > cfg.Documents.TopK {
+		results = results[:cfg.Documents.TopK]
+	}
+	return results, nil
+}
+
+func (ws *workspace) find(id string) int {
+	for i, doc := range ws.Documents {
+		if doc.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (ws *workspace) replaceChunks(id string, chunks []documentChunk) {
+	kept := ws.Chunks[:0]
+	for _, c := range ws.Chunks {
+		if c.DocumentID != id {
+			kept = append(kept, c)
+		}
+	}
+	ws.Chunks = append(kept, chunks...)
+}
+
+// indexDocument extracts the text of data, splits it into chunks and embeds
+// them, updating doc's chunk count and indexing metadata.
+func indexDocument(ctx context.Context, doc *Document, data []byte, cfg *Config) ([]documentChunk, error) {
+	text, err := extractText(doc.Format, data)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, errors.New("document contains no text")
+	}
+
+	pieces := chunkText(text, cfg.Documents.ChunkSize, cfg.Documents.ChunkOverlap)
+	chunks := make([]documentChunk, len(pieces))
+	for start := 0; start < len(pieces); start += embedBatchSize {
+		end := min(start+embedBatchSize, len(pieces))
+		batch := make([]string, 0, end-start)
+		for _, p := range pieces[start:end] {
+			batch = append(batch, p.text)
+		}
+		vectors, err := embed(ctx, batch, cfg)
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range vectors {
+			p := pieces[start+i]
+			chunks[start+i] = documentChunk{DocumentID: doc.ID, Index: start + i, Offset: p.offset, Text: p.text, Vector: v}
+		}
+	}
+
+	doc.Chunks = len(chunks)
+	doc.EmbeddingModel = cfg.Documents.EmbeddingModel
+	doc.IndexedAt = time.Now()
+	return chunks, nil
+}
+
+func documentFormat(name string, data []byte) (string, error) {
+	if bytes.HasPrefix(data, []byte("%PDF-")) {
+		return "pdf", nil
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".txt", ".text", "":
+		if !utf8.Valid(data) {
+			return "", errors.New("text documents must be UTF-8")
+		}
+		return "text", nil
+	case ".md", ".markdown":
+		if !utf8.Valid(data) {
+			return "", errors.New("markdown documents must be UTF-8")
+		}
+		return "markdown", nil
+	case ".pdf":
+		return "", errors.New("file has a .pdf extension but is not a PDF")
+	default:
+		return "", fmt.Errorf("unsupported document type %q (use .txt, .md or .pdf)", filepath.Ext(name))
+	}
+}
+
+func extractText(format string, data []byte) (string, error) {
+	if format != "pdf" {
+		return string(data), nil
+	}
+	return extractPDFText(data)
+}
+
+// extractPDFText returns the text of every page, separated by blank lines.
+// The PDF library panics on some malformed files, so that is turned into
+// an error.
+func extractPDFText(data []byte) (text string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to parse PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse PDF: %w", err)
+	}
+
+	var sb strings.Builder
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		content, err := page.GetPlainText(nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to read PDF page %d: %w", i, err)
+		}
+		sb.WriteString(strings.TrimSpace(content))
+		sb.WriteString("\n\n")
+	}
+	return sb.String(), nil
+}
+
+type textChunk struct {
+	offset int
+	text   string
+}
+
+// chunkText splits text into pieces of about size bytes, preferring to break
+// at paragraph, sentence and word boundaries, with overlap bytes repeated
+// between neighbours so an answer spanning a boundary is still found.
+func chunkText(text string, size, overlap int) []textChunk {
+	var chunks []textChunk
+	start := 0
+	for start < len(text) {
+		end := len(text)
+		if start+size < len(text) {
+			end = breakPoint(text, start+size/2, start+size)
+		}
+
+		if piece := strings.TrimSpace(text[start:end]); piece != "" {
+			lead := len(text[start:end]) - len(strings.TrimLeft(text[start:end], " \t\r\n"))
+			chunks = append(chunks, textChunk{offset: start + lead, text: piece})
+		}
+		if end == len(text) {
+			break
+		}
+
+		next := end - overlap
+		if next <= start {
+			next = end
+		} else {
+			// Start the overlap on a word boundary
+			if i := strings.IndexAny(text[next:end], " \t\r\n"); i >= 0 {
+				next += i + 1
+			}
+		}
+		start = next
+	}
+	return chunks
+}
+
+// breakPoint finds the best place to cut text in [lo, hi].
+func breakPoint(text string, lo, hi int) int {
+	window := text[lo:hi]
+	for _, sep := range []string{"\n\n", "\n", ". ", " "} {
+		if i := strings.LastIndex(window, sep); i >= 0 {
+			return lo + i + len(sep)
+		}
+	}
+	for hi > lo && !utf8.RuneStart(text[hi]) {
+		hi--
+	}
+	return hi
+}
+
+type embedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embedResponse struct {
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+// embed returns one unit-length vector per input from Ollama's embedding
+// endpoint, so similarity is a plain dot product.
+func embed(ctx context.Context, inputs []string, cfg *Config) ([][]float32, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Documents.Timeout)
+	defer cancel()
+
+	requestBody, err := json.Marshal(embedRequest{Model: cfg.Documents.EmbeddingModel, Input: inputs})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal embedding request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", cfg.Documents.EmbeddingURL, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embedding request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := memoryStore.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embedding request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding API returned status %d", resp.StatusCode)
+	}
+
+	var result embedResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024*1024)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal embedding response: %w", err)
+	}
+	if len(result.Embeddings) != len(inputs) {
+		return nil, fmt.Errorf("embedding API returned %d vectors for %d inputs", len(result.Embeddings), len(inputs))
+	}
+
+	for _, v := range result.Embeddings {
+		var norm float64
+		for _, x := range v {
+			norm += float64(x) * float64(x)
+		}
+		if norm = math.Sqrt(norm); norm > 0 {
+			for i := range v {
+				v[i] = float32(float64(v[i]) / norm)
+			}
+		}
+	}
+	return result.Embeddings, nil
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+// documentsSection renders retrieved chunks for the prompt, numbered so
+// the model can cite them, keeping at most budget bytes of chunk text.
+func documentsSection(citations []Citation, budget int) string {
+	if len(citations) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Relevant Documents (cite as [n] when used):\n")
+	for i, c := range citations {
+		if budget <= 0 {
+			break
+		}
+		text := truncate(c.Text, budget)
+		budget -= len(c.Text)
+		sb.WriteString(fmt.Sprintf("[%d] %s (chunk %d, offset %d):\n%s\n\n", i+1, c.Document, c.Chunk, c.Offset, text))
+	}
+	return sb.String()
+}
+
+// handleDocuments lists (GET), uploads (POST, multipart field "file") and
+// deletes (DELETE ?id=) documents in the caller's workspace.
+func handleDocuments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, ok := checkUserAccess(w, r, "documents", r.URL.Query().Get("user"))
+	if !ok {
+		return
+	}
+	cfg := currentConfig()
+	if !cfg.Documents.Enabled {
+		writeJSONError(w, http.StatusNotFound, "Documents are disabled")
+		return
+	}
+	reqLog := loggerFromContext(r.Context()).With("user", username)
+
+	switch r.Method {
+	case http.MethodGet:
+		docs, err := documentStore.list(username, cfg)
+		if err != nil {
+			reqLog.Error("listing documents failed", "error", err.Error())
+			writeJSONError(w, http.StatusInternalServerError, "Failed to read document index")
+			return
+		}
+		json.NewEncoder(w).Encode(docs)
+
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, cfg.Documents.MaxUploadBytes+1024*1024)
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Expected a multipart upload with a \"file\" field")
+			return
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(io.LimitReader(file, cfg.Documents.MaxUploadBytes+1))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Failed to read upload")
+			return
+		}
+		if int64(len(data)) > cfg.Documents.MaxUploadBytes {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Document too large (max %d bytes)", cfg.Documents.MaxUploadBytes))
+			return
+		}
+
+		doc, err := documentStore.add(r.Context(), username, filepath.Base(header.Filename), data, cfg)
+		if err != nil {
+			reqLog.Warn("document upload failed", "name", header.Filename, "error", err.Error())
+			writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		reqLog.Info("document indexed", "document_id", doc.ID, "name", doc.Name, "chunks", doc.Chunks)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(doc)
+
+	case http.MethodDelete:
+		if err := documentStore.remove(username, r.URL.Query().Get("id"), cfg); err != nil {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Only GET, POST and DELETE methods allowed")
+	}
+}
+
+// handleDocumentsReindex re-indexes one document (?id=) or all of them.
+func handleDocumentsReindex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, ok := checkUserAccess(w, r, "documents", r.URL.Query().Get("user"))
+	if !ok {
+		return
+	}
+	cfg := currentConfig()
+	if !cfg.Documents.Enabled {
+		writeJSONError(w, http.StatusNotFound, "Documents are disabled")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Only POST method allowed")
+		return
+	}
+
+	docs, err := documentStore.reindex(r.Context(), username, r.URL.Query().Get("id"), cfg)
+	if err != nil {
+		loggerFromContext(r.Context()).Warn("reindex failed", "user", username, "error", err.Error())
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(docs)
+}
+
+// documentsForPrompt retrieves the chunks relevant to a prompt. Retrieval
+// failures are logged and the prompt is answered without documents.
+func documentsForPrompt(ctx context.Context, reqLog *slog.Logger, userInput UserPrompt, cfg *Config) []Citation {
+	if !cfg.Documents.Enabled || (userInput.UseDocuments != nil && !*userInput.UseDocuments) {
+		return nil
+	}
+	citations, err := documentStore.retrieve(ctx, userInput.User, userInput.Prompt, cfg)
+	if err != nil {
+		reqLog.Warn("document retrieval failed", "error", err.Error())
+		return nil
+	}
+	return citations
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FallbackEndpoint is a secondary Ollama server. An empty Model uses the
+// persona's model.
+type FallbackEndpoint struct {
+	URL   string `json:"url" yaml:"url"`
+	Model string `json:"model" yaml:"model"`
+}
+
+// FallbackConfig controls the model client: when the preferred model
+// fails, the smaller Models are tried on the primary endpoint, then the
+// Endpoints, then DegradedResponse is returned.
+type FallbackConfig struct {
+	Models           []string
+	Endpoints        []FallbackEndpoint
+	DegradedResponse string // empty disables the canned answer
+	Retries          int    // extra attempts per target for retryable errors
+	RetryBackoff     time.Duration
+	MaxBackoff       time.Duration
+	FailureThreshold int           // consecutive failures that open a breaker
+	Cooldown         time.Duration // how long an open breaker rejects calls
+	PrimaryShare     float64       // share of the remaining deadline a target may use when others follow
+}
+
+type modelTarget struct {
+	URL   string
+	Model string
+}
+
+func (t modelTarget) key() string {
+	return t.Model + "@" + t.URL
+}
+
+// ModelAnswer reports which model produced a response.
+type ModelAnswer struct {
+	Text     string
+	Model    string
+	Fallback bool // answered by something other than the preferred model
+	Degraded bool // canned response, no model answered
+}
+
+// partialStreamError marks a stream that failed after tokens were sent to
+// the client; such a request is not retried or moved to another model.
+type partialStreamError struct {
+	err error
+}
+
+func (e *partialStreamError) Error() string { return e.err.Error() }
+func (e *partialStreamError) Unwrap() error { return e.err }
+
+type circuitBreaker struct {
+	target    modelTarget
+	failures  int // consecutive
+	openUntil time.Time
+	probing   bool // a half-open trial call is in flight
+
+	successes     int
+	totalFailures int
+	lastError     string
+	lastFailure   time.Time
+}
+
+type ModelClient struct {
+	sync.Mutex
+	breakers map[string]*circuitBreaker // keyed by modelTarget.key()
+}
+
+func NewModelClient() *ModelClient {
+	return &ModelClient{breakers: make(map[string]*circuitBreaker)}
+}
+
+var modelClient = NewModelClient()
+
+func (mc *ModelClient) breaker(t modelTarget) *circuitBreaker {
+	b, ok := mc.breakers[t.key()]
+	if !ok {
+		b = &circuitBreaker{target: t}
+		mc.breakers[t.key()] = b
+	}
+	return b
+}
+
+// allow reports whether a call to the target may go ahead. After the
+// cooldown an open breaker lets a single probe through; its result decides
+// whether the breaker closes again.
+func (mc *ModelClient) allow(t modelTarget, cfg FallbackConfig) bool {
+	mc.Lock()
+	defer mc.Unlock()
+
+	b := mc.breaker(t)
+	if b.failures < cfg.FailureThreshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (mc *ModelClient) report(t modelTarget, err error, cfg FallbackConfig) {
+	mc.Lock()
+	defer mc.Unlock()
+
+	b := mc.breaker(t)
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		b.successes++
+		return
+	}
+
+	b.failures++
+	b.totalFailures++
+	b.lastError = err.Error()
+	b.lastFailure = time.Now()
+	if b.failures >= cfg.FailureThreshold {
+		b.openUntil = time.Now().Add(cfg.Cooldown)
+	}
+}
+
+// release ends a call whose outcome says nothing about the target, such
+// as one the client cancelled, so an open breaker can be probed again
+func (mc *ModelClient) release(t modelTarget) {
+	mc.Lock()
+	defer mc.Unlock()
+
+	mc.breaker(t).probing = false
+}
+
+type breakerStatus struct {
+	Model         string     `json:"model"`
+	URL           string     `json:"url"`
+	State         string     `json:"state"` // "closed", "open", "half_open"
+	Failures      int        `json:"consecutive_failures"`
+	Successes     int        `json:"successes"`
+	TotalFailures int        `json:"total_failures"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastFailure   *time.Time `json:"last_failure,omitempty"`
+}
+
+func (mc *ModelClient) status() []breakerStatus {
+	cfg := currentConfig().Fallback
+
+	mc.Lock()
+	defer mc.Unlock()
+
+	result := make([]breakerStatus, 0, len(mc.breakers))
+	for _, b := range mc.breakers {
+		s := breakerStatus{
+			Model:         b.target.Model,
+			URL:           b.target.URL,
+			State:         "closed",
+			Failures:      b.failures,
+			Successes:     b.successes,
+			TotalFailures: b.totalFailures,
+			LastError:     b.lastError,
+		}
+		if b.failures >= cfg.FailureThreshold {
+			s.State = "open"
+			if !time.Now().Before(b.openUntil) {
+				s.State = "half_open"
+			}
+		}
+		if !b.lastFailure.IsZero() {
+			last := b.lastFailure
+			s.LastFailure = &last
+		}
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Model < result[j].Model })
+	return result
+}
+
+// fallbackChain lists the targets to try in order: the persona's model on
+// the primary endpoint, the smaller fallback models, then the secondary
+// endpoints.
+func fallbackChain(cfg *Config, persona Persona) []modelTarget {
+	preferred := persona.modelName(cfg)
+	chain := []modelTarget{{URL: cfg.OllamaURL, Model: preferred}}
+
+	seen := map[string]bool{chain[0].key(): true}
+	add := func(t modelTarget) {
+		if !seen[t.key()] {
+			seen[t.key()] = true
+			chain = append(chain, t)
+		}
+	}
+	for _, model := range cfg.Fallback.Models {
+		add(modelTarget{URL: cfg.OllamaURL, Model: model})
+	}
+	for _, ep := range cfg.Fallback.Endpoints {
+		model := ep.Model
+		if model == "" {
+			model = preferred
+		}
+		add(modelTarget{URL: ep.URL, Model: model})
+	}
+	return chain
+}
+
+// retryable reports whether a failed call can safely be repeated against
+// the same target: connection errors and overload or gateway statuses.
+// Timeouts are not retried; a slow model would only be slow again.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var partial *partialStreamError
+	if errors.As(err, &partial) {
+		return false
+	}
+	var status *ollamaStatusError
+	if errors.As(err, &status) {
+		switch status.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// backoff returns the wait before retry n (0-based): exponential growth
+// capped at MaxBackoff, with the upper half randomised so that clients
+// retrying together spread out.
+func backoff(n int, cfg FallbackConfig) time.Duration {
+	d := cfg.RetryBackoff << n
+	if d <= 0 || d > cfg.MaxBackoff {
+		d = cfg.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// query runs call against each target in the fallback chain until one
+// succeeds, skipping targets whose breaker is open. Targets that have
+// others after them only get PrimaryShare of the remaining deadline, so a
+// hung model leaves time for the fallbacks.
+func (mc *ModelClient) query(ctx context.Context, persona Persona, call func(context.Context, modelTarget) (string, error)) (ModelAnswer, error) {
+	cfg := currentConfig()
+	fb := cfg.Fallback
+	reqLog := loggerFromContext(ctx)
+
+	chain := fallbackChain(cfg, persona)
+	var lastErr error
+
+	for i, target := range chain {
+		if !mc.allow(target, fb) {
+			reqLog.Warn("skipping model, circuit open", "model", target.Model, "url", target.URL)
+			continue
+		}
+
+		targetCtx, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok && i < len(chain)-1 {
+			share := time.Duration(float64(time.Until(deadline)) * fb.PrimaryShare)
+			targetCtx, cancel = context.WithTimeout(ctx, share)
+		}
+
+		var text string
+		var err error
+		for attempt := 0; ; attempt++ {
+			text, err = call(targetCtx, target)
+			if err == nil || attempt >= fb.Retries || !retryable(targetCtx, err) {
+				break
+			}
+			wait := backoff(attempt, fb)
+			reqLog.Warn("model call failed, retrying", "model", target.Model, "attempt", attempt+1, "backoff_ms", wait.Milliseconds(), "error", err.Error())
+			select {
+			case <-time.After(wait):
+			case <-targetCtx.Done():
+			}
+		}
+		cancel()
+
+		// A cancelled request says nothing about the model's health
+		if ctx.Err() == context.Canceled {
+			mc.release(target)
+			return ModelAnswer{Text: text, Model: target.Model}, ctx.Err()
+		}
+		mc.report(target, err, fb)
+
+		if err == nil {
+			if i > 0 {
+				reqLog.Info("answered by fallback model", "model", target.Model, "url", target.URL)
+			}
+			return ModelAnswer{Text: text, Model: target.Model, Fallback: i > 0}, nil
+		}
+
+		lastErr = err
+		var partial *partialStreamError
+		if errors.As(err, &partial) || ctx.Err() != nil {
+			return ModelAnswer{Text: text, Model: target.Model}, err
+		}
+		reqLog.Warn("model call failed", "model", target.Model, "url", target.URL, "error", err.Error())
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("all models are unavailable (circuits open)")
+	}
+	if fb.DegradedResponse == "" || ctx.Err() != nil {
+		return ModelAnswer{}, lastErr
+	}
+	reqLog.Error("all models failed, returning degraded response", "error", lastErr.Error())
+	return ModelAnswer{Text: fb.DegradedResponse, Model: "degraded", Fallback: true, Degraded: true}, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	mc := NewModelClient()
+	cfg := FallbackConfig{FailureThreshold: 2, Cooldown: time.Hour}
+	target := modelTarget{URL: "http://a", Model: "m"}
+
+	for i := 0; i < 2; i++ {
+		if !mc.allow(target, cfg) {
+			t.Fatalf("call %d rejected before the threshold", i)
+		}
+		mc.report(target, errors.New("boom"), cfg)
+	}
+	if mc.allow(target, cfg) {
+		t.Fatal("open breaker allowed a call during the cooldown")
+	}
+}
+
+func TestBreakerHalfOpenProbe(t *testing.T) {
+	mc := NewModelClient()
+	cfg := FallbackConfig{FailureThreshold: 1, Cooldown: time.Millisecond}
+	target := modelTarget{URL: "http://a", Model: "m"}
+
+	mc.report(target, errors.New("boom"), cfg)
+	time.Sleep(2 * time.Millisecond)
+
+	if !mc.allow(target, cfg) {
+		t.Fatal("no probe allowed after the cooldown")
+	}
+	if mc.allow(target, cfg) {
+		t.Fatal("second probe allowed while the first is in flight")
+	}
+
+	mc.report(target, nil, cfg)
+	if !mc.allow(target, cfg) || !mc.allow(target, cfg) {
+		t.Fatal("breaker did not close after a successful probe")
+	}
+}
+
+func TestBreakerReleaseClearsProbe(t *testing.T) {
+	mc := NewModelClient()
+	cfg := FallbackConfig{FailureThreshold: 1, Cooldown: time.Millisecond}
+	target := modelTarget{URL: "http://a", Model: "m"}
+
+	mc.report(target, errors.New("boom"), cfg)
+	time.Sleep(2 * time.Millisecond)
+
+	if !mc.allow(target, cfg) {
+		t.Fatal("no probe allowed after the cooldown")
+	}
+	mc.release(target)
+
+	if !mc.allow(target, cfg) {
+		t.Fatal("released probe left the breaker stuck")
+	}
+	b := mc.breakers[target.key()]
+	if b.failures != 1 || b.totalFailures != 1 {
+		t.Fatalf("release counted as a result: failures=%d total=%d", b.failures, b.totalFailures)
+	}
+}
+
+func TestBackoffCapped(t *testing.T) {
+	cfg := FallbackConfig{RetryBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for n := 0; n < 70; n++ {
+		if d := backoff(n, cfg); d < 0 || d > cfg.MaxBackoff {
+			t.Fatalf("backoff(%d) = %v, want within [0, %v]", n, d, cfg.MaxBackoff)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Feedback is a user's rating of one answer.
+type Feedback struct {
+	Rating    string    `json:"rating"` // "up" or "down"
+	Comment   string    `json:"comment,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	counted bool // included in feedbackStats; ratings that were imported or restored are not
+}
+
+// downvoted reports whether the user marked the answer as wrong. Such turns
+// stay in the history but are left out of the model's context.
+func (c Conversation) downvoted() bool {
+	return c.Feedback != nil && c.Feedback.Rating == "down"
+}
+
+type feedbackKey struct {
+	model string
+	tier  string
+}
+
+type feedbackCounts struct {
+	Up       int `json:"up"`
+	Down     int `json:"down"`
+	Comments int `json:"comments"`
+}
+
+func (c *feedbackCounts) add(f *Feedback, delta int) {
+	if f == nil {
+		return
+	}
+	if f.Rating == "up" {
+		c.Up += delta
+	} else {
+		c.Down += delta
+	}
+	if f.Comment != "" {
+		c.Comments += delta
+	}
+}
+
+// FeedbackStats aggregates ratings by the model that answered and the
+// prompt tier. Counters survive conversations being trimmed or evicted.
+type FeedbackStats struct {
+	sync.Mutex
+	counts         map[feedbackKey]*feedbackCounts
+	factsRemoved   int
+	factsCorrected int
+}
+
+func NewFeedbackStats() *FeedbackStats {
+	return &FeedbackStats{counts: make(map[feedbackKey]*feedbackCounts)}
+}
+
+var feedbackStats = NewFeedbackStats()
+
+// record replaces a previous rating (nil for none) with the new one (nil
+// when the rating was cleared). Only a previous rating that was itself
+// recorded is taken back out, so the counts never go negative.
+func (fs *FeedbackStats) record(model, tier string, previous, current *Feedback) {
+	if model == "" {
+		model = "unknown"
+	}
+	if tier == "" {
+		tier = "unknown"
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	key := feedbackKey{model: model, tier: tier}
+	c, ok := fs.counts[key]
+	if !ok {
+		c = &feedbackCounts{}
+		fs.counts[key] = c
+	}
+	if previous != nil && previous.counted {
+		c.add(previous, -1)
+	}
+	if current != nil {
+		c.add(current, 1)
+		current.counted = true
+	}
+}
+
+func (fs *FeedbackStats) recordFact(corrected bool) {
+	fs.Lock()
+	defer fs.Unlock()
+	if corrected {
+		fs.factsCorrected++
+	} else {
+		fs.factsRemoved++
+	}
+}
+
+type feedbackGroup struct {
+	Model string `json:"model,omitempty"`
+	Tier  string `json:"tier,omitempty"`
+	feedbackCounts
+	Total        int     `json:"total"`
+	Satisfaction float64 `json:"satisfaction"` // share of ratings that are "up"
+}
+
+func newFeedbackGroup(model, tier string, c feedbackCounts) feedbackGroup {
+	g := feedbackGroup{Model: model, Tier: tier, feedbackCounts: c, Total: c.Up + c.Down}
+	if g.Total > 0 {
+		g.Satisfaction = float64(c.Up) / float64(g.Total)
+	}
+	return g
+}
+
+type feedbackReport struct {
+	ByModel        []feedbackGroup `json:"by_model"`
+	ByTier         []feedbackGroup `json:"by_tier"`
+	ByModelAndTier []feedbackGroup `json:"by_model_and_tier"`
+	FactsRemoved   int             `json:"facts_removed"`
+	FactsCorrected int             `json:"facts_corrected"`
+}
+
+func (fs *FeedbackStats) report() feedbackReport {
+	fs.Lock()
+	defer fs.Unlock()
+
+	byModel := make(map[string]feedbackCounts)
+	byTier := make(map[string]feedbackCounts)
+	result := feedbackReport{FactsRemoved: fs.factsRemoved, FactsCorrected: fs.factsCorrected}
+
+	for key, c := range fs.counts {
+		result.ByModelAndTier = append(result.ByModelAndTier, newFeedbackGroup(key.model, key.tier, *c))
+		m := byModel[key.model]
+		m.Up, m.Down, m.Comments = m.Up+c.Up, m.Down+c.Down, m.Comments+c.Comments
+		byModel[key.model] = m
+		t := byTier[key.tier]
+		t.Up, t.Down, t.Comments = t.Up+c.Up, t.Down+c.Down, t.Comments+c.Comments
+		byTier[key.tier] = t
+	}
+	for model, c := range byModel {
+		result.ByModel = append(result.ByModel, newFeedbackGroup(model, "", c))
+	}
+	for tier, c := range byTier {
+		result.ByTier = append(result.ByTier, newFeedbackGroup("", tier, c))
+	}
+
+	sort.Slice(result.ByModelAndTier, func(i, j int) bool {
+		a, b := result.ByModelAndTier[i], result.ByModelAndTier[j]
+		if a.Model != b.Model {
+			return a.Model < b.Model
+		}
+		return a.Tier < b.Tier
+	})
+	sort.Slice(result.ByModel, func(i, j int) bool { return result.ByModel[i].Model < result.ByModel[j].Model })
+	sort.Slice(result.ByTier, func(i, j int) bool { return result.ByTier[i].Tier < result.ByTier[j].Tier })
+	return result
+}
+
+// write renders the counters in Prometheus text format.
+func (fs *FeedbackStats) write(sb *strings.Builder) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	keys := make([]feedbackKey, 0, len(fs.counts))
+	for k := range fs.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].model != keys[j].model {
+			return keys[i].model < keys[j].model
+		}
+		return keys[i].tier < keys[j].tier
+	})
+	sb.WriteString("# HELP ai_feedback_ratings Answer ratings given since start, by model, timeout type and rating.\n")
+	sb.WriteString("# TYPE ai_feedback_ratings gauge\n")
+	for _, k := range keys {
+		c := fs.counts[k]
+		fmt.Fprintf(sb, "ai_feedback_ratings{model=%q,timeout_type=%q,rating=\"up\"} %d\n", k.model, k.tier, c.Up)
+		fmt.Fprintf(sb, "ai_feedback_ratings{model=%q,timeout_type=%q,rating=\"down\"} %d\n", k.model, k.tier, c.Down)
+	}
+	sb.WriteString("# HELP ai_fact_corrections_total Remembered facts users flagged as wrong.\n")
+	sb.WriteString("# TYPE ai_fact_corrections_total counter\n")
+	fmt.Fprintf(sb, "ai_fact_corrections_total{action=\"removed\"} %d\n", fs.factsRemoved)
+	fmt.Fprintf(sb, "ai_fact_corrections_total{action=\"corrected\"} %d\n", fs.factsCorrected)
+}
+
+var errConversationNotFound = errors.New("conversation not found")
+
+// rateConversation sets the user's rating on one of their conversations, or
+// clears it when feedback is nil.
+func (ms *MemoryStore) rateConversation(username, conversationID string, feedback *Feedback) (Conversation, error) {
+	ms.Lock()
+	defer ms.Unlock()
+
+	user := ms.users[username]
+	if user == nil {
+		return Conversation{}, errConversationNotFound
+	}
+	for i := range user.Conversations {
+		conv := &user.Conversations[i]
+		if conv.ID != conversationID {
+			continue
+		}
+		feedbackStats.record(conv.Model, conv.Tier, conv.Feedback, feedback)
+		conv.Feedback = feedback
+		return *conv, nil
+	}
+	return Conversation{}, errConversationNotFound
+}
+
+// correctFact removes a remembered fact, or replaces it when a correction
+// is given. Facts are matched case-insensitively.
+func (ms *MemoryStore) correctFact(username, fact, correction string) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	user := ms.users[username]
+	if user == nil {
+		return fmt.Errorf("fact %q not found", fact)
+	}
+	for i, existing := range user.PersonalFacts {
+		if !strings.EqualFold(existing, fact) {
+			continue
+		}
+		if correction == "" || (correction != existing && contains(user.PersonalFacts, correction)) {
+			user.PersonalFacts = append(user.PersonalFacts[:i], user.PersonalFacts[i+1:]...)
+		} else {
+			user.PersonalFacts[i] = correction
+		}
+		feedbackStats.recordFact(correction != "")
+		ms.enforceQuota(user)
+		return nil
+	}
+	return fmt.Errorf("fact %q not found", fact)
+}
+
+func (ms *MemoryStore) facts(username string) []string {
+	ms.RLock()
+	defer ms.RUnlock()
+
+	user := ms.users[username]
+	if user == nil {
+		return []string{}
+	}
+	return append([]string{}, user.PersonalFacts...)
+}
+
+type feedbackRequest struct {
+	ConversationID string `json:"conversation_id"`
+	Rating         string `json:"rating"`
+	Comment        string `json:"comment"`
+
+	// Fact feedback: the fact is wrong. An empty correction removes it.
+	Fact       string `json:"fact"`
+	Correction string `json:"correction"`
+}
+
+// handleFeedback rates an answer or corrects a remembered fact (POST), or
+// clears the rating of an answer (DELETE ?conversation_id=).
+func handleFeedback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, ok := checkUserAccess(w, r, "feedback", r.URL.Query().Get("user"))
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var req feedbackRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		req.Fact = strings.TrimSpace(req.Fact)
+		req.Correction = strings.TrimSpace(req.Correction)
+		req.Comment = strings.TrimSpace(req.Comment)
+
+		switch {
+		case req.Fact != "":
+			if req.ConversationID != "" {
+				writeJSONError(w, http.StatusBadRequest, "Give either conversation_id or fact, not both")
+				return
+			}
+			if err := memoryStore.correctFact(username, req.Fact, req.Correction); err != nil {
+				writeJSONError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"facts": memoryStore.facts(username)})
+
+		case req.ConversationID != "":
+			if req.Rating != "up" && req.Rating != "down" {
+				writeJSONError(w, http.StatusBadRequest, `rating must be "up" or "down"`)
+				return
+			}
+			if len(req.Comment) > 2000 {
+				writeJSONError(w, http.StatusBadRequest, "comment must be at most 2000 characters")
+				return
+			}
+			feedback := &Feedback{Rating: req.Rating, Comment: req.Comment, UpdatedAt: time.Now()}
+			conv, err := memoryStore.rateConversation(username, req.ConversationID, feedback)
+			if err != nil {
+				writeJSONError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			json.NewEncoder(w).Encode(conv)
+
+		default:
+			writeJSONError(w, http.StatusBadRequest, "conversation_id or fact is required")
+		}
+
+	case http.MethodDelete:
+		conv, err := memoryStore.rateConversation(username, r.URL.Query().Get("conversation_id"), nil)
+		if err != nil {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(conv)
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Only POST and DELETE methods allowed")
+	}
+}
+
+// handleFacts lists the facts remembered about the caller, so they can be
+// flagged through /feedback.
+func handleFacts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, ok := checkUserAccess(w, r, "facts", r.URL.Query().Get("user"))
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Only GET method allowed")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"facts": memoryStore.facts(username)})
+}
+
+// handleAdminFeedback reports rating statistics per model and prompt tier.
+func handleAdminFeedback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Only GET method allowed")
+		return
+	}
+	json.NewEncoder(w).Encode(feedbackStats.report())
+}
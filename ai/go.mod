@@ -0,0 +1,9 @@
+module ai
+
+go 1.24.3
+
+require (
+	github.com/gorilla/websocket v1.5.3
+	github.com/ledongthuc/pdf v0.0.0-20260907135840-6c8c28e0e8a0
+	gopkg.in/yaml.v3 v3.0.1
+)
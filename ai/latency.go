@@ -0,0 +1,233 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const maxLatencySamples = 200
+
+// AdaptiveConfig controls deadlines derived from observed model latency.
+type AdaptiveConfig struct {
+	Enabled    bool
+	Percentile float64       // e.g. 0.95
+	Margin     float64       // extra fraction added on top of the percentile
+	MinSamples int           // samples needed before adaptive deadlines are used
+	Min        time.Duration // lower bound for a deadline
+	Max        time.Duration // upper bound for a deadline
+}
+
+type latencySample struct {
+	promptBytes  int
+	contextBytes int
+	duration     time.Duration
+	timedOut     bool // duration is only a lower bound
+}
+
+// LatencyModel keeps a rolling window of model response times so deadlines
+// can follow the speed of the machine actually serving requests.
+type LatencyModel struct {
+	sync.Mutex
+	samples map[string][]latencySample // keyed by model name
+}
+
+func NewLatencyModel() *LatencyModel {
+	return &LatencyModel{samples: make(map[string][]latencySample)}
+}
+
+var latencyModel = NewLatencyModel()
+
+type LatencyEstimate struct {
+	Expected time.Duration `json:"expected"`
+	Deadline time.Duration `json:"deadline"`
+	Samples  int           `json:"samples"`
+}
+
+func (lm *LatencyModel) record(model string, promptBytes, contextBytes int, d time.Duration, timedOut bool) {
+	lm.Lock()
+	defer lm.Unlock()
+
+	samples := append(lm.samples[model], latencySample{
+		promptBytes:  promptBytes,
+		contextBytes: contextBytes,
+		duration:     d,
+		timedOut:     timedOut,
+	})
+	if len(samples) > maxLatencySamples {
+		samples = samples[len(samples)-maxLatencySamples:]
+	}
+	lm.samples[model] = samples
+}
+
+// contextBucket groups requests of similar size so a short greeting is not
+// judged against a 15KB analysis prompt.
+func contextBucket(contextBytes int) int {
+	switch {
+	case contextBytes < 1024:
+		return 0
+	case contextBytes < 4096:
+		return 1
+	case contextBytes < 16384:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// promptBucket groups questions of similar length. The context mostly sets
+// how long the model reads, the question how long it writes: a one-line
+// question over a long history still gets a short answer.
+func promptBucket(promptBytes int) int {
+	switch {
+	case promptBytes < 200:
+		return 0
+	case promptBytes < 1000:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// sortedDurations orders samples by duration. A timed-out sample only says
+// the model took at least that long, so it ranks after every completed one
+// and counts as no shorter than the slowest of them. The second result is
+// the number of completed samples, which lead the slice.
+func sortedDurations(samples []latencySample) ([]time.Duration, int) {
+	completed := make([]time.Duration, 0, len(samples))
+	timedOut := make([]time.Duration, 0)
+	for _, s := range samples {
+		if s.timedOut {
+			timedOut = append(timedOut, s.duration)
+		} else {
+			completed = append(completed, s.duration)
+		}
+	}
+	sort.Slice(completed, func(i, j int) bool { return completed[i] < completed[j] })
+	sort.Slice(timedOut, func(i, j int) bool { return timedOut[i] < timedOut[j] })
+
+	if len(completed) > 0 {
+		slowest := completed[len(completed)-1]
+		for i, d := range timedOut {
+			if d < slowest {
+				timedOut[i] = slowest
+			}
+		}
+	}
+	return append(completed, timedOut...), len(completed)
+}
+
+// estimate predicts how long the model will take for a prompt and context
+// of the given sizes. Samples with the same prompt and context buckets are
+// preferred; if there are too few, those with the same context bucket,
+// then all samples for the model. Timed-out requests push the deadline up
+// but are left out of the expected duration.
+func (lm *LatencyModel) estimate(model string, promptBytes, contextBytes int, cfg AdaptiveConfig) (LatencyEstimate, bool) {
+	ctxBucket, qBucket := contextBucket(contextBytes), promptBucket(promptBytes)
+	matches := []func(latencySample) bool{
+		func(s latencySample) bool {
+			return contextBucket(s.contextBytes) == ctxBucket && promptBucket(s.promptBytes) == qBucket
+		},
+		func(s latencySample) bool { return contextBucket(s.contextBytes) == ctxBucket },
+		func(latencySample) bool { return true },
+	}
+
+	lm.Lock()
+	all := lm.samples[model]
+	var samples []latencySample
+	for _, match := range matches {
+		samples = samples[:0]
+		for _, s := range all {
+			if match(s) {
+				samples = append(samples, s)
+			}
+		}
+		if len(samples) >= cfg.MinSamples {
+			break
+		}
+	}
+	lm.Unlock()
+
+	if len(samples) == 0 || len(samples) < cfg.MinSamples {
+		return LatencyEstimate{}, false
+	}
+
+	durations, completed := sortedDurations(samples)
+	expected := durations
+	if completed > 0 {
+		expected = durations[:completed]
+	}
+
+	deadline := time.Duration(float64(percentile(durations, cfg.Percentile)) * (1 + cfg.Margin))
+	deadline = deadline.Round(time.Second)
+	if deadline < cfg.Min {
+		deadline = cfg.Min
+	}
+	if deadline > cfg.Max {
+		deadline = cfg.Max
+	}
+
+	return LatencyEstimate{
+		Expected: percentile(expected, 0.5),
+		Deadline: deadline,
+		Samples:  len(durations),
+	}, true
+}
+
+type latencyStats struct {
+	Samples  int    `json:"samples"`
+	TimedOut int    `json:"timed_out"`
+	P50      string `json:"p50"`
+	P95      string `json:"p95"`
+}
+
+func (lm *LatencyModel) stats() map[string]latencyStats {
+	lm.Lock()
+	defer lm.Unlock()
+
+	result := make(map[string]latencyStats, len(lm.samples))
+	for model, samples := range lm.samples {
+		durations, completed := sortedDurations(samples)
+		result[model] = latencyStats{
+			Samples:  len(samples),
+			TimedOut: len(samples) - completed,
+			P50:      percentile(durations, 0.5).String(),
+			P95:      percentile(durations, 0.95).String(),
+		}
+	}
+	return result
+}
+
+// chooseTimeout picks the deadline for a request. An explicit timeout_type
+// or custom_timeout always wins and uses the static tiers; otherwise the
+// deadline is learned from recent latencies once enough samples exist.
+func chooseTimeout(userInput UserPrompt, cfg *Config, contextBytes int, model string) (time.Duration, string, *LatencyEstimate) {
+	est, ok := latencyModel.estimate(model, len(userInput.Prompt), contextBytes, cfg.Adaptive)
+	var estimate *LatencyEstimate
+	if ok {
+		estimate = &est
+	}
+
+	explicit := userInput.CustomTimeout > 0 || userInput.TimeoutType != ""
+	if explicit || !cfg.Adaptive.Enabled || estimate == nil {
+		d, label := determineTimeout(userInput, cfg)
+		return d, label, estimate
+	}
+
+	return est.Deadline, "adaptive_" + durationLabel(est.Deadline), estimate
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var testAdaptive = AdaptiveConfig{
+	Enabled:    true,
+	Percentile: 0.95,
+	Margin:     0.5,
+	MinSamples: 3,
+	Min:        5 * time.Second,
+	Max:        5 * time.Minute,
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []time.Duration{1 * time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Second}
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0, 1 * time.Second},
+		{0.25, 1 * time.Second},
+		{0.5, 2 * time.Second},
+		{0.95, 4 * time.Second},
+		{1, 4 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.q); got != tt.want {
+			t.Errorf("percentile(%v) = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+	if got := percentile(nil, 0.5); got != 0 {
+		t.Errorf("percentile of no samples = %v", got)
+	}
+}
+
+func TestEstimateNeedsMinSamples(t *testing.T) {
+	lm := NewLatencyModel()
+	lm.record("llama3", 50, 500, 10*time.Second, false)
+	lm.record("llama3", 50, 500, 10*time.Second, false)
+	if _, ok := lm.estimate("llama3", 50, 500, testAdaptive); ok {
+		t.Fatal("estimate with two samples, want at least three")
+	}
+	lm.record("llama3", 50, 500, 10*time.Second, false)
+	est, ok := lm.estimate("llama3", 50, 500, testAdaptive)
+	if !ok || est.Expected != 10*time.Second || est.Deadline != 15*time.Second || est.Samples != 3 {
+		t.Fatalf("estimate %+v, %v", est, ok)
+	}
+	if _, ok := lm.estimate("mistral", 50, 500, testAdaptive); ok {
+		t.Error("estimate for a model without samples")
+	}
+}
+
+func TestEstimatePrefersMatchingBuckets(t *testing.T) {
+	lm := NewLatencyModel()
+	for i := 0; i < 3; i++ {
+		lm.record("llama3", 50, 500, 4*time.Second, false)    // short question, small context
+		lm.record("llama3", 2000, 500, 40*time.Second, false) // long question, small context
+		lm.record("llama3", 50, 20000, 80*time.Second, false) // short question, large context
+	}
+
+	tests := []struct {
+		name                      string
+		promptBytes, contextBytes int
+		want                      time.Duration
+	}{
+		{"same prompt and context bucket", 50, 500, 4 * time.Second},
+		{"long question", 3000, 600, 40 * time.Second},
+		{"large context", 10, 30000, 80 * time.Second},
+		// No samples for a long question over a large context: fall back
+		// to the context bucket.
+		{"context bucket only", 3000, 30000, 80 * time.Second},
+	}
+	for _, tt := range tests {
+		est, ok := lm.estimate("llama3", tt.promptBytes, tt.contextBytes, testAdaptive)
+		if !ok || est.Expected != tt.want {
+			t.Errorf("%s: estimate %+v, %v, want expected %v", tt.name, est, ok, tt.want)
+		}
+	}
+
+	// Too few samples in any bucket: all samples are used.
+	lm = NewLatencyModel()
+	lm.record("llama3", 50, 500, 4*time.Second, false)
+	lm.record("llama3", 2000, 500, 40*time.Second, false)
+	lm.record("llama3", 50, 20000, 80*time.Second, false)
+	if est, ok := lm.estimate("llama3", 50, 500, testAdaptive); !ok || est.Samples != 3 || est.Expected != 40*time.Second {
+		t.Errorf("fallback to all samples: %+v, %v", est, ok)
+	}
+}
+
+func TestEstimateTimedOutSamples(t *testing.T) {
+	lm := NewLatencyModel()
+	lm.record("llama3", 50, 500, 10*time.Second, false)
+	lm.record("llama3", 50, 500, 20*time.Second, false)
+	// A timeout shorter than the slowest completed request still ranks
+	// last: the request would have taken at least that long.
+	lm.record("llama3", 50, 500, 5*time.Second, true)
+
+	est, ok := lm.estimate("llama3", 50, 500, testAdaptive)
+	if !ok {
+		t.Fatal("no estimate")
+	}
+	if est.Expected != 10*time.Second {
+		t.Errorf("expected %v, want the median of completed requests", est.Expected)
+	}
+	if est.Deadline != 30*time.Second {
+		t.Errorf("deadline %v, want 30s", est.Deadline)
+	}
+
+	lm = NewLatencyModel()
+	for i := 0; i < 3; i++ {
+		lm.record("llama3", 50, 500, 10*time.Minute, true)
+	}
+	if est, ok := lm.estimate("llama3", 50, 500, testAdaptive); !ok || est.Deadline != testAdaptive.Max {
+		t.Errorf("only timeouts: %+v, want the deadline capped at %v", est, testAdaptive.Max)
+	}
+}
+
+func TestChooseTimeout(t *testing.T) {
+	previous := latencyModel
+	latencyModel = NewLatencyModel()
+	t.Cleanup(func() { latencyModel = previous })
+
+	cfg := &Config{Timeouts: defaultTimeouts, Adaptive: testAdaptive}
+	prompt := UserPrompt{Prompt: "hello"}
+
+	d, label, est := chooseTimeout(prompt, cfg, 100, "llama3")
+	if est != nil || strings.HasPrefix(label, "adaptive_") {
+		t.Fatalf("without samples got %v %q %+v, want a static tier", d, label, est)
+	}
+
+	for i := 0; i < 3; i++ {
+		latencyModel.record("llama3", len(prompt.Prompt), 100, 20*time.Second, false)
+	}
+	d, label, est = chooseTimeout(prompt, cfg, 100, "llama3")
+	if d != 30*time.Second || label != "adaptive_30s" || est == nil {
+		t.Errorf("adaptive: got %v %q %+v", d, label, est)
+	}
+
+	long := UserPrompt{Prompt: "hello", TimeoutType: "long"}
+	d, label, est = chooseTimeout(long, cfg, 100, "llama3")
+	if d != defaultTimeouts.LongRequest || !strings.HasPrefix(label, "long_") || est == nil {
+		t.Errorf("explicit timeout_type: got %v %q %+v", d, label, est)
+	}
+
+	custom := UserPrompt{Prompt: "hello", CustomTimeout: 42}
+	if d, label, _ := chooseTimeout(custom, cfg, 100, "llama3"); d != 42*time.Second || label != "custom_42s" {
+		t.Errorf("custom timeout: got %v %q", d, label)
+	}
+
+	cfg.Adaptive.Enabled = false
+	if _, label, _ := chooseTimeout(prompt, cfg, 100, "llama3"); strings.HasPrefix(label, "adaptive_") {
+		t.Errorf("adaptive disabled: got %q", label)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+const requestIDKey contextKey = "request_id"
+
+var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// loggerFromContext returns the base logger tagged with the request ID, if any.
+func loggerFromContext(ctx context.Context) *slog.Logger {
+	if id := requestIDFromContext(ctx); id != "" {
+		return logger.With("request_id", id)
+	}
+	return logger
+}
+
+// withRequestID assigns every request an ID, honouring an incoming
+// X-Request-ID header, and echoes it back in the response headers.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 64 {
+			id = "req_" + randomHex(8)
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -34,20 +36,24 @@ var defaultTimeouts = TimeoutConfig{
 }
 
 type OllamaRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	Stream bool   `json:"stream"`
+	Model   string        `json:"model"`
+	Prompt  string        `json:"prompt"`
+	Stream  bool          `json:"stream"`
+	Options *ModelOptions `json:"options,omitempty"`
 }
 
 type OllamaResponse struct {
 	Response string `json:"response"`
+	Done     bool   `json:"done"`
 }
 
 type UserPrompt struct {
 	Prompt        string `json:"prompt"`
-	User          string `json:"user"`
+	User          string `json:"user,omitempty"`           // Optional, must match the token's user
 	TimeoutType   string `json:"timeout_type,omitempty"`   // "short", "medium", "long"
 	CustomTimeout int    `json:"custom_timeout,omitempty"` // Custom timeout in seconds
+	SessionID     string `json:"session_id,omitempty"`     // Selects a session persona
+	UseDocuments  *bool  `json:"use_documents,omitempty"`  // Defaults to true
 }
 
 type ModelResponse struct {
@@ -55,14 +61,32 @@ type ModelResponse struct {
 	Error          string `json:"error,omitempty"`
 	ProcessingTime string `json:"processing_time,omitempty"`
 	TimeoutUsed    string `json:"timeout_used,omitempty"`
+	RequestID      string `json:"request_id,omitempty"`
+
+	Deadline            string     `json:"deadline,omitempty"`
+	EstimatedCompletion *time.Time `json:"estimated_completion,omitempty"`
+	ToolCalls           []ToolCall `json:"tool_calls,omitempty"`
+	Citations           []Citation `json:"citations,omitempty"`
+
+	Model    string `json:"model,omitempty"`    // the model that actually answered
+	Fallback bool   `json:"fallback,omitempty"` // answered by a fallback model
+	Degraded bool   `json:"degraded,omitempty"` // canned response, no model was available
+
+	ConversationID string    `json:"conversation_id,omitempty"` // for POST /feedback
+	Reminder       *Reminder `json:"reminder,omitempty"`        // scheduled from this prompt
 }
 
 type Conversation struct {
-	ID        string    `json:"id"`
-	User      string    `json:"user"`
-	Prompt    string    `json:"prompt"`
-	Response  string    `json:"response"`
-	Timestamp time.Time `json:"timestamp"`
+	ID        string     `json:"id"`
+	User      string     `json:"user"`
+	SessionID string     `json:"session_id,omitempty"`
+	Prompt    string     `json:"prompt"`
+	Response  string     `json:"response"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	Model     string     `json:"model,omitempty"` // the model that answered
+	Tier      string     `json:"tier,omitempty"`  // timeout category, e.g. "auto_short"
+	Feedback  *Feedback  `json:"feedback,omitempty"`
+	Timestamp time.Time  `json:"timestamp"`
 }
 
 type UserProfile struct {
@@ -78,24 +102,72 @@ type MemoryStore struct {
 	users      map[string]*UserProfile
 	maxHistory int
 	httpClient *http.Client
-	timeouts   TimeoutConfig
+
+	pins      map[string]int           // requests in flight per user; pinned users are not evicted
+	restoring map[string]chan struct{} // closed when the user's archive has been read
+	evictMu   sync.Mutex               // one eviction pass at a time
+
+	// Totals for the admin memory report
+	evicted, archived, restored, quotaTrims int
 }
 
 func NewMemoryStore(maxHistory int, timeouts TimeoutConfig) *MemoryStore {
 	return &MemoryStore{
 		users:      make(map[string]*UserProfile),
 		maxHistory: maxHistory,
-		timeouts:   timeouts,
+		pins:       make(map[string]int),
+		restoring:  make(map[string]chan struct{}),
 		httpClient: &http.Client{
 			Timeout: timeouts.HTTPClient,
 		},
 	}
 }
 
+// applyConfig updates the history limit and HTTP client after a reload.
+func (ms *MemoryStore) applyConfig(maxHistory int, timeouts TimeoutConfig) {
+	ms.Lock()
+	defer ms.Unlock()
+
+	ms.maxHistory = maxHistory
+	ms.httpClient = &http.Client{Timeout: timeouts.HTTPClient}
+}
+
+func (ms *MemoryStore) client() *http.Client {
+	ms.RLock()
+	defer ms.RUnlock()
+	return ms.httpClient
+}
+
 func (ms *MemoryStore) getOrCreateUser(username string) *UserProfile {
+	ms.restore(username)
+
 	ms.Lock()
 	defer ms.Unlock()
+	return ms.userLocked(username)
+}
+
+// pinUser is getOrCreateUser for a request that will store a turn: the
+// user is not evicted until the returned function is called.
+func (ms *MemoryStore) pinUser(username string) (unpin func()) {
+	ms.restore(username)
+
+	ms.Lock()
+	defer ms.Unlock()
+	ms.userLocked(username)
+	ms.pins[username]++
+
+	return func() {
+		ms.Lock()
+		defer ms.Unlock()
+		if ms.pins[username]--; ms.pins[username] <= 0 {
+			delete(ms.pins, username)
+		}
+	}
+}
 
+// userLocked returns the user, creating an empty profile if needed, and
+// marks them as seen. Callers must hold the lock.
+func (ms *MemoryStore) userLocked(username string) *UserProfile {
 	if user, exists := ms.users[username]; exists {
 		user.LastSeen = time.Now()
 		return user
@@ -112,22 +184,20 @@ func (ms *MemoryStore) getOrCreateUser(username string) *UserProfile {
 	return user
 }
 
-func (ms *MemoryStore) addConversation(username, prompt, response string) {
+// addConversation stores a turn for the user, filling in its ID, user and
+// timestamp, and returns the ID so the answer can be rated later.
+func (ms *MemoryStore) addConversation(username string, conversation Conversation) string {
 	ms.Lock()
 	defer ms.Unlock()
 
 	user := ms.users[username]
 	if user == nil {
-		return
+		return ""
 	}
 
-	conversation := Conversation{
-		ID:        fmt.Sprintf("%s_%d", username, time.Now().Unix()),
-		User:      username,
-		Prompt:    prompt,
-		Response:  response,
-		Timestamp: time.Now(),
-	}
+	conversation.ID = fmt.Sprintf("%s_%d", username, time.Now().UnixNano())
+	conversation.User = username
+	conversation.Timestamp = time.Now()
 
 	user.Conversations = append(user.Conversations, conversation)
 
@@ -135,7 +205,9 @@ func (ms *MemoryStore) addConversation(username, prompt, response string) {
 		user.Conversations = user.Conversations[len(user.Conversations)-ms.maxHistory:]
 	}
 
-	ms.extractPersonalInfo(user, prompt, response)
+	ms.extractPersonalInfo(user, conversation.Prompt, conversation.Response)
+	ms.enforceQuota(user)
+	return conversation.ID
 }
 
 func (ms *MemoryStore) extractPersonalInfo(user *UserProfile, prompt, _ string) {
@@ -150,36 +222,34 @@ func (ms *MemoryStore) extractPersonalInfo(user *UserProfile, prompt, _ string)
 			}
 		}
 	}
-     
+
 	if len(user.PersonalFacts) > 20 {
 		user.PersonalFacts = user.PersonalFacts[len(user.PersonalFacts)-20:]
 	}
 }
 
-// Enhanced context building with size limits based on timeout type
-func (ms *MemoryStore) buildContext(username, currentPrompt, timeoutType string) string {
+// Enhanced context building with size limits based on timeout type.
+// The persona's instructions always lead the context and are never
+// truncated; only the memory part is trimmed to the tier's size limit.
+// Retrieved documents sit right before the question so truncation drops
+// old history first, and may use at most half of the tier's budget.
+func (ms *MemoryStore) buildContext(username, currentPrompt, timeoutType string, persona Persona, docs []Citation) string {
 	ms.RLock()
 	defer ms.RUnlock()
 
+	system := persona.instructions()
+
+	// Adjust context size based on timeout type
+	tier := currentConfig().tier(timeoutType)
+	maxHistoryItems := tier.MaxHistoryItems
+	documents := documentsSection(docs, tier.MaxContextSize/2)
+
 	user := ms.users[username]
 	if user == nil {
-		return currentPrompt
+		return system + documents + currentPrompt
 	}
 
 	var contextBuilder strings.Builder
-	var maxHistoryItems int
-
-	// Adjust context size based on timeout type
-	switch timeoutType {
-	case "short":
-		maxHistoryItems = 2
-	case "medium":
-		maxHistoryItems = 5
-	case "long":
-		maxHistoryItems = 10
-	default:
-		maxHistoryItems = 3
-	}
 
 	if len(user.PersonalFacts) > 0 {
 		contextBuilder.WriteString("Personal Information about " + username + ":\n")
@@ -189,7 +259,13 @@ func (ms *MemoryStore) buildContext(username, currentPrompt, timeoutType string)
 		contextBuilder.WriteString("\n")
 	}
 
-	recentConversations := user.Conversations
+	// Turns the user marked as wrong are kept for stats but not repeated
+	recentConversations := make([]Conversation, 0, len(user.Conversations))
+	for _, conv := range user.Conversations {
+		if !conv.downvoted() {
+			recentConversations = append(recentConversations, conv)
+		}
+	}
 	if len(recentConversations) > maxHistoryItems {
 		recentConversations = recentConversations[len(recentConversations)-maxHistoryItems:]
 	}
@@ -201,29 +277,16 @@ func (ms *MemoryStore) buildContext(username, currentPrompt, timeoutType string)
 		}
 	}
 
+	contextBuilder.WriteString(documents)
 	contextBuilder.WriteString("Current Question: " + currentPrompt)
 
 	// Limit total context size
 	context := contextBuilder.String()
-	maxContextSize := getMaxContextSize(timeoutType)
-	if len(context) > maxContextSize {
-		context = context[len(context)-maxContextSize:]
+	if len(context) > tier.MaxContextSize {
+		context = context[len(context)-tier.MaxContextSize:]
 	}
 
-	return context
-}
-
-func getMaxContextSize(timeoutType string) int {
-	switch timeoutType {
-	case "short":
-		return 2000
-	case "medium":
-		return 6000
-	case "long":
-		return 15000
-	default:
-		return 4000
-	}
+	return system + context
 }
 
 func (ms *MemoryStore) getUserStats(username string) map[string]interface{} {
@@ -254,7 +317,9 @@ func contains(slice []string, item string) bool {
 }
 
 // Determine timeout based on request characteristics
-func determineTimeout(userInput UserPrompt, timeouts TimeoutConfig) (time.Duration, string) {
+func determineTimeout(userInput UserPrompt, cfg *Config) (time.Duration, string) {
+	timeouts := cfg.Timeouts
+
 	// Use custom timeout if provided
 	if userInput.CustomTimeout > 0 {
 		customDuration := time.Duration(userInput.CustomTimeout) * time.Second
@@ -269,11 +334,11 @@ func determineTimeout(userInput UserPrompt, timeouts TimeoutConfig) (time.Durati
 	if userInput.TimeoutType != "" {
 		switch userInput.TimeoutType {
 		case "short":
-			return timeouts.ShortRequest, "short_30s"
+			return timeouts.ShortRequest, "short_" + durationLabel(timeouts.ShortRequest)
 		case "medium":
-			return timeouts.MediumRequest, "medium_2m"
+			return timeouts.MediumRequest, "medium_" + durationLabel(timeouts.MediumRequest)
 		case "long":
-			return timeouts.LongRequest, "long_5m"
+			return timeouts.LongRequest, "long_" + durationLabel(timeouts.LongRequest)
 		}
 	}
 
@@ -281,15 +346,9 @@ func determineTimeout(userInput UserPrompt, timeouts TimeoutConfig) (time.Durati
 	promptLen := len(userInput.Prompt)
 
 	// Check for complexity indicators
-	complexityKeywords := []string{
-		"analyze", "explain in detail", "write a story", "create a plan",
-		"summarize", "compare", "research", "detailed analysis",
-		"step by step", "comprehensive", "thorough", "elaborate", "create",
-	}
-
 	isComplex := false
 	lowerPrompt := strings.ToLower(userInput.Prompt)
-	for _, keyword := range complexityKeywords {
+	for _, keyword := range cfg.ComplexityKeywords {
 		if strings.Contains(lowerPrompt, keyword) {
 			isComplex = true
 			break
@@ -298,29 +357,63 @@ func determineTimeout(userInput UserPrompt, timeouts TimeoutConfig) (time.Durati
 
 	// Determine timeout based on length and complexity
 	if promptLen > 1000 || isComplex {
-		return timeouts.LongRequest, "auto_long_5m"
+		return timeouts.LongRequest, "auto_long_" + durationLabel(timeouts.LongRequest)
 	} else if promptLen > 300 {
-		return timeouts.MediumRequest, "auto_medium_2m"
+		return timeouts.MediumRequest, "auto_medium_" + durationLabel(timeouts.MediumRequest)
 	}
 
-	return timeouts.ShortRequest, "auto_short_30s"
+	return timeouts.ShortRequest, "auto_short_" + durationLabel(timeouts.ShortRequest)
 }
 
-var memoryStore = NewMemoryStore(50, defaultTimeouts)
+var memoryStore = NewMemoryStore(defaultConfig().MaxHistory, defaultTimeouts)
 
-func queryLLaMA(ctx context.Context, fullPrompt string) (string, error) {
-	url := "http://localhost:11434/api/generate"
+// ollamaStatusError is returned when Ollama answers with a non-200 status.
+type ollamaStatusError struct {
+	StatusCode int
+}
 
+func (e *ollamaStatusError) Error() string {
+	return fmt.Sprintf("LLaMA API returned status %d", e.StatusCode)
+}
+
+// queryLLaMA sends the prompt through the model client, which falls back
+// to other models or endpoints when the preferred one is unhealthy.
+func queryLLaMA(ctx context.Context, fullPrompt string, persona Persona) (ModelAnswer, error) {
+	return modelClient.query(ctx, persona, func(ctx context.Context, t modelTarget) (string, error) {
+		return callOllama(ctx, t, fullPrompt, persona.Options)
+	})
+}
+
+// queryLLaMAStream is like queryLLaMA but asks Ollama to stream the answer
+// and calls onToken for every chunk as it arrives. Once a token has been
+// sent the answer cannot move to another model, so errors after that point
+// are returned as is.
+func queryLLaMAStream(ctx context.Context, fullPrompt string, persona Persona, onToken func(string)) (ModelAnswer, error) {
+	streamed := false
+	return modelClient.query(ctx, persona, func(ctx context.Context, t modelTarget) (string, error) {
+		response, err := callOllamaStream(ctx, t, fullPrompt, persona.Options, func(token string) {
+			streamed = true
+			onToken(token)
+		})
+		if err != nil && streamed {
+			return response, &partialStreamError{err: err}
+		}
+		return response, err
+	})
+}
+
+func callOllama(ctx context.Context, target modelTarget, fullPrompt string, options *ModelOptions) (string, error) {
 	requestBody, err := json.Marshal(OllamaRequest{
-		Model:  "llama3.2:1b",
-		Prompt: fullPrompt,
-		Stream: false,
+		Model:   target.Model,
+		Prompt:  fullPrompt,
+		Stream:  false,
+		Options: options,
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", target.URL, bytes.NewBuffer(requestBody))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
@@ -332,7 +425,7 @@ func queryLLaMA(ctx context.Context, fullPrompt string) (string, error) {
 	default:
 	}
 
-	resp, err := memoryStore.httpClient.Do(req)
+	resp, err := memoryStore.client().Do(req)
 	if err != nil {
 		if ctx.Err() == context.DeadlineExceeded {
 			return "", fmt.Errorf("request timeout: LLaMA took too long to respond")
@@ -342,7 +435,7 @@ func queryLLaMA(ctx context.Context, fullPrompt string) (string, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("LLaMA API returned status %d", resp.StatusCode)
+		return "", &ollamaStatusError{StatusCode: resp.StatusCode}
 	}
 
 	// Increased response size limit for long requests
@@ -360,13 +453,73 @@ func queryLLaMA(ctx context.Context, fullPrompt string) (string, error) {
 	return result.Response, nil
 }
 
+func callOllamaStream(ctx context.Context, target modelTarget, fullPrompt string, options *ModelOptions, onToken func(string)) (string, error) {
+	requestBody, err := json.Marshal(OllamaRequest{
+		Model:   target.Model,
+		Prompt:  fullPrompt,
+		Stream:  true,
+		Options: options,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", target.URL, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := memoryStore.client().Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("request timeout: LLaMA took too long to respond")
+		}
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &ollamaStatusError{StatusCode: resp.StatusCode}
+	}
+
+	var full strings.Builder
+	decoder := json.NewDecoder(io.LimitReader(resp.Body, 5*1024*1024))
+	for {
+		var chunk OllamaResponse
+		if err := decoder.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				break
+			}
+			if ctx.Err() == context.DeadlineExceeded {
+				return full.String(), fmt.Errorf("request timeout: LLaMA took too long to respond")
+			}
+			if ctx.Err() != nil {
+				return full.String(), ctx.Err()
+			}
+			return full.String(), fmt.Errorf("failed to read stream: %w", err)
+		}
+		if chunk.Response != "" {
+			full.WriteString(chunk.Response)
+			onToken(chunk.Response)
+		}
+		if chunk.Done {
+			break
+		}
+	}
+
+	return full.String(), nil
+}
+
 func handlePrompt(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
+	requestID := requestIDFromContext(r.Context())
+	reqLog := loggerFromContext(r.Context())
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -380,46 +533,76 @@ func handlePrompt(w http.ResponseWriter, r *http.Request) {
 
 	var userInput UserPrompt
 	if err := json.NewDecoder(r.Body).Decode(&userInput); err != nil {
-		json.NewEncoder(w).Encode(ModelResponse{Error: "Invalid JSON format"})
+		metrics.recordRequest("invalid", "none")
+		json.NewEncoder(w).Encode(ModelResponse{Error: "Invalid JSON format", RequestID: requestID})
 		return
 	}
 
-	if userInput.Prompt == "" || userInput.User == "" {
-		json.NewEncoder(w).Encode(ModelResponse{Error: "Prompt and user fields are required"})
+	if userInput.Prompt == "" {
+		metrics.recordRequest("invalid", "none")
+		json.NewEncoder(w).Encode(ModelResponse{Error: "Prompt field is required", RequestID: requestID})
 		return
 	}
 
+	// The identity always comes from the credential, never from the body
+	username, ok := checkUserAccess(w, r, "prompt", userInput.User)
+	if !ok {
+		return
+	}
+	userInput.User = username
+
 	// Validate prompt length (increased for long requests)
 	maxPromptLength := 10000 // 10KB for long requests
 	if len(userInput.Prompt) > maxPromptLength {
+		metrics.recordRequest("invalid", "none")
 		json.NewEncoder(w).Encode(ModelResponse{
-			Error: fmt.Sprintf("Prompt too long (max %d characters)", maxPromptLength),
+			Error:     fmt.Sprintf("Prompt too long (max %d characters)", maxPromptLength),
+			RequestID: requestID,
 		})
 		return
 	}
 
-	memoryStore.getOrCreateUser(userInput.User)
+	defer memoryStore.pinUser(userInput.User)()
+
+	cfg := currentConfig()
+	citations := documentsForPrompt(r.Context(), reqLog, userInput, cfg)
+	persona := personaStore.resolve(userInput.User, userInput.SessionID)
+	fullPrompt := memoryStore.buildContext(userInput.User, userInput.Prompt, userInput.TimeoutType, persona, citations)
+	reminder, reminderNote := reminderForPrompt(reqLog, userInput.User, userInput.Prompt, cfg)
+	fullPrompt += reminderNote
 
 	// Determine appropriate timeout
-	requestTimeout, timeoutLabel := determineTimeout(userInput, memoryStore.timeouts)
+	model := persona.modelName(cfg)
+	requestTimeout, timeoutLabel, estimate := chooseTimeout(userInput, cfg, len(fullPrompt), model)
 
-	fullPrompt := memoryStore.buildContext(userInput.User, userInput.Prompt, userInput.TimeoutType)
+	// Every response carries the chosen deadline and, when the latency
+	// model has enough data, the expected completion time.
+	deadline := requestTimeout.String()
+	var estimatedCompletion *time.Time
+	if estimate != nil {
+		eta := startTime.Add(estimate.Expected)
+		estimatedCompletion = &eta
+	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
 	defer cancel()
 
-	log.Printf("Processing request for user %s with timeout %s", userInput.User, timeoutLabel)
+	reqLog = reqLog.With("user", userInput.User, "timeout", timeoutLabel, "model", model)
+	reqLog.Info("processing prompt", "prompt_bytes", len(userInput.Prompt), "context_bytes", len(fullPrompt), "deadline", deadline, "citations", len(citations))
 
 	type result struct {
-		response string
-		err      error
+		answer    ModelAnswer
+		toolCalls []ToolCall
+		err       error
+		latency   time.Duration
 	}
 
 	resultChan := make(chan result, 1)
 
 	go func() {
-		response, err := queryLLaMA(ctx, fullPrompt)
-		resultChan <- result{response: response, err: err}
+		modelStart := time.Now()
+		answer, toolCalls, err := runToolLoop(ctx, userInput.User, fullPrompt, persona, nil)
+		resultChan <- result{answer: answer, toolCalls: toolCalls, err: err, latency: time.Since(modelStart)}
 	}()
 
 	select {
@@ -427,40 +610,89 @@ func handlePrompt(w http.ResponseWriter, r *http.Request) {
 		processingTime := time.Since(startTime)
 
 		if res.err != nil {
-			log.Printf("Error querying LLaMA for user %s: %v", userInput.User, res.err)
+			reqLog.Error("model query failed", "error", res.err.Error(), "duration_ms", processingTime.Milliseconds())
 
 			if strings.Contains(res.err.Error(), "timeout") {
+				metrics.recordRequest("timeout", timeoutLabel)
+				latencyModel.record(model, len(userInput.Prompt), len(fullPrompt), requestTimeout, true)
 				json.NewEncoder(w).Encode(ModelResponse{
 					Error:          "Request timeout - try using 'timeout_type': 'long' or 'custom_timeout': 300 for complex requests",
 					ProcessingTime: processingTime.String(),
 					TimeoutUsed:    timeoutLabel,
+					RequestID:      requestID,
+
+					Deadline:            deadline,
+					EstimatedCompletion: estimatedCompletion,
 				})
 			} else {
+				metrics.recordRequest("error", timeoutLabel)
 				json.NewEncoder(w).Encode(ModelResponse{
 					Error:          "AI service temporarily unavailable",
 					ProcessingTime: processingTime.String(),
 					TimeoutUsed:    timeoutLabel,
+					RequestID:      requestID,
+
+					Deadline:            deadline,
+					EstimatedCompletion: estimatedCompletion,
 				})
 			}
 			return
 		}
 
-		memoryStore.addConversation(userInput.User, userInput.Prompt, res.response)
-		log.Printf("User %s: %s (processed in %s)", userInput.User, userInput.Prompt, processingTime)
+		answer := res.answer
+		conversationID := ""
+		if answer.Degraded {
+			// A canned answer is not worth remembering or learning from
+			metrics.recordRequest("degraded", timeoutLabel)
+			reqLog.Warn("prompt answered in degraded mode", "duration_ms", processingTime.Milliseconds())
+		} else {
+			conversationID = memoryStore.addConversation(userInput.User, Conversation{
+				SessionID: userInput.SessionID,
+				Prompt:    userInput.Prompt,
+				Response:  answer.Text,
+				ToolCalls: res.toolCalls,
+				Model:     answer.Model,
+				Tier:      timeoutCategory(timeoutLabel),
+			})
+			metrics.recordRequest("success", timeoutLabel)
+			metrics.recordModelCall(res.latency, len(userInput.Prompt), len(fullPrompt), len(answer.Text))
+			latencyModel.record(answer.Model, len(userInput.Prompt), len(fullPrompt), res.latency, false)
+			reqLog.Info("prompt completed", "duration_ms", processingTime.Milliseconds(), "response_bytes", len(answer.Text),
+				"tool_calls", len(res.toolCalls), "answered_by", answer.Model)
+		}
+		metrics.recordModelAnswer(answer.Model)
 
 		json.NewEncoder(w).Encode(ModelResponse{
-			Response:       res.response,
+			Response:       answer.Text,
 			ProcessingTime: processingTime.String(),
 			TimeoutUsed:    timeoutLabel,
+			RequestID:      requestID,
+
+			Deadline:            deadline,
+			EstimatedCompletion: estimatedCompletion,
+			ToolCalls:           res.toolCalls,
+			Citations:           citations,
+
+			Model:          answer.Model,
+			Fallback:       answer.Fallback,
+			Degraded:       answer.Degraded,
+			ConversationID: conversationID,
+			Reminder:       reminder,
 		})
 
 	case <-ctx.Done():
 		processingTime := time.Since(startTime)
-		log.Printf("Request timeout for user %s after %s", userInput.User, processingTime)
+		metrics.recordRequest("timeout", timeoutLabel)
+		latencyModel.record(model, len(userInput.Prompt), len(fullPrompt), requestTimeout, true)
+		reqLog.Warn("prompt timed out", "duration_ms", processingTime.Milliseconds())
 		json.NewEncoder(w).Encode(ModelResponse{
 			Error:          "Request timeout - try using 'timeout_type': 'long' or increase 'custom_timeout' for complex requests",
 			ProcessingTime: processingTime.String(),
 			TimeoutUsed:    timeoutLabel,
+			RequestID:      requestID,
+
+			Deadline:            deadline,
+			EstimatedCompletion: estimatedCompletion,
 		})
 		return
 	}
@@ -475,9 +707,8 @@ func handleUserProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	username := r.URL.Query().Get("user")
-	if username == "" {
-		json.NewEncoder(w).Encode(map[string]string{"error": "User parameter is required"})
+	username, ok := checkUserAccess(w, r, "profile_read", r.URL.Query().Get("user"))
+	if !ok {
 		return
 	}
 
@@ -490,45 +721,67 @@ func handleUserProfile(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(stats)
 }
 
-// func handleHealth(w http.ResponseWriter, r *http.Request) {
-// 	w.Header().Set("Content-Type", "application/json")
-// 	w.Header().Set("Access-Control-Allow-Origin", "*")
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	memoryStore.RLock()
+	users := len(memoryStore.users)
+	memoryStore.RUnlock()
+
+	timeouts := currentConfig().Timeouts
+
+	// Any open circuit means some requests are served by a fallback
+	status := "healthy"
+	models := modelClient.status()
+	for _, m := range models {
+		if m.State != "closed" {
+			status = "degraded"
+		}
+	}
 
-// 	json.NewEncoder(w).Encode(map[string]interface{}{
-// 		"status":    "healthy",
-// 		"timestamp": time.Now().Format(time.RFC3339),
-// 		"users":     len(memoryStore.users),
-// 		"timeouts": map[string]string{
-// 			"short_requests":  memoryStore.timeouts.ShortRequest.String(),
-// 			"medium_requests": memoryStore.timeouts.MediumRequest.String(),
-// 			"long_requests":   memoryStore.timeouts.LongRequest.String(),
-// 			"http_client":     memoryStore.timeouts.HTTPClient.String(),
-// 		},
-// 	})
-// }
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    status,
+		"timestamp": time.Now().Format(time.RFC3339),
+		"users":     users,
+		"models":    models,
+		"timeouts": map[string]string{
+			"short_requests":  timeouts.ShortRequest.String(),
+			"medium_requests": timeouts.MediumRequest.String(),
+			"long_requests":   timeouts.LongRequest.String(),
+			"http_client":     timeouts.HTTPClient.String(),
+		},
+	})
+}
 
 // New endpoint to get timeout configuration
 func handleTimeoutInfo(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
+	timeouts := currentConfig().Timeouts
+
 	info := map[string]interface{}{
 		"timeout_types": map[string]string{
-			"short":  "30 seconds - for simple questions",
-			"medium": "2 minutes - for moderate complexity",
-			"long":   "5 minutes - for complex analysis/generation",
+			"short":  timeouts.ShortRequest.String() + " - for simple questions",
+			"medium": timeouts.MediumRequest.String() + " - for moderate complexity",
+			"long":   timeouts.LongRequest.String() + " - for complex analysis/generation",
 		},
 		"custom_timeout": "Use 'custom_timeout' field with seconds (max 600)",
 		"auto_detection": "System auto-detects based on prompt length and complexity",
+		"adaptive": map[string]interface{}{
+			"enabled":     currentConfig().Adaptive.Enabled,
+			"description": "Without timeout_type or custom_timeout, the deadline is learned from recent model latencies",
+			"latency":     latencyModel.stats(),
+		},
+		"authentication": "Send 'Authorization: Bearer <token>' or 'X-API-Key: <token>'; the user comes from the token",
 		"example_requests": map[string]interface{}{
 			"short_request": map[string]string{
 				"prompt":       "Hello, how are you?",
-				"user":         "username",
 				"timeout_type": "short",
 			},
 			"long_request": map[string]interface{}{
 				"prompt":         "Write a detailed analysis of...",
-				"user":           "username",
 				"timeout_type":   "long",
 				"custom_timeout": 300,
 			},
@@ -539,45 +792,88 @@ func handleTimeoutInfo(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	// Allow configuration via environment variables
-	if customShort := getEnvDuration("SHORT_TIMEOUT", defaultTimeouts.ShortRequest); customShort != 0 {
-		defaultTimeouts.ShortRequest = customShort
-	}
-	if customMedium := getEnvDuration("MEDIUM_TIMEOUT", defaultTimeouts.MediumRequest); customMedium != 0 {
-		defaultTimeouts.MediumRequest = customMedium
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or JSON config file")
+	flag.Parse()
+
+	// Defaults, then the config file, then environment variables
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
 	}
-	if customLong := getEnvDuration("LONG_TIMEOUT", defaultTimeouts.LongRequest); customLong != 0 {
-		defaultTimeouts.LongRequest = customLong
+
+	memoryStore = NewMemoryStore(cfg.MaxHistory, cfg.Timeouts)
+	applyConfig(cfg)
+	go watchConfigReload(*configPath)
+	go memoryStore.runJanitor()
+	if err := reminderStore.load(cfg.Reminders.File); err != nil {
+		log.Fatalf("Loading reminders: %v", err)
 	}
+	go reminderStore.runScheduler()
 
-	// Recreate memory store with updated timeouts
-	memoryStore = NewMemoryStore(50, defaultTimeouts)
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		// The token is a secret, so it stays out of the JSON log stream
+		adminToken = "adm_" + randomHex(24)
+		fmt.Fprintf(os.Stderr, "WARNING: ADMIN_TOKEN is not set. Generated an admin token valid until restart:\n%s\n", adminToken)
+		logger.Warn("ADMIN_TOKEN not set, generated an admin token and wrote it to stderr")
+	}
+	tokenStore = NewTokenStore(adminToken)
 
-	http.HandleFunc("/prompt", handlePrompt)
-	http.HandleFunc("/profile", handleUserProfile)
+	http.HandleFunc("/prompt", requireAuth("prompt", handlePrompt))
+	http.HandleFunc("/profile", requireAuth("profile_read", handleUserProfile))
 	http.HandleFunc("/timeout-info", handleTimeoutInfo)
+	http.HandleFunc("/ws", requireAuth("ws_connect", handleWebSocket))
+	http.HandleFunc("/chat", handleChatPage)
+	http.HandleFunc("/health", handleHealth)
+	http.HandleFunc("/metrics", handleMetrics)
+	http.HandleFunc("/personas", requireAuth("personas", handlePersonas))
+	http.HandleFunc("/persona", requireAuth("persona", handlePersona))
+	http.HandleFunc("/conversations/export", requireAuth("conversations_export", handleConversationExport))
+	http.HandleFunc("/conversations/import", requireAuth("conversations_import", handleConversationImport))
+	http.HandleFunc("/documents", requireAuth("documents", handleDocuments))
+	http.HandleFunc("/documents/reindex", requireAuth("documents", handleDocumentsReindex))
+	http.HandleFunc("/feedback", requireAuth("feedback", handleFeedback))
+	http.HandleFunc("/facts", requireAuth("facts", handleFacts))
+	http.HandleFunc("/reminders", requireAuth("reminders", handleReminders))
+	http.HandleFunc("/reminders/inbox", requireAuth("reminders", handleReminderInbox))
+	http.HandleFunc("/admin/tokens", requireAdmin(handleAdminTokens))
+	http.HandleFunc("/admin/personas", requireAdmin(handleAdminPersonas))
+	http.HandleFunc("/admin/audit", requireAdmin(handleAdminAudit))
+	http.HandleFunc("/admin/memory", requireAdmin(handleAdminMemory))
+	http.HandleFunc("/admin/feedback", requireAdmin(handleAdminFeedback))
 
 	server := &http.Server{
-		Addr:         ":8080",
-		ReadTimeout:  defaultTimeouts.ServerRead,
-		WriteTimeout: defaultTimeouts.ServerWrite,
+		Addr:         cfg.ListenAddr,
+		ReadTimeout:  cfg.Timeouts.ServerRead,
+		WriteTimeout: cfg.Timeouts.ServerWrite,
 		IdleTimeout:  2 * time.Minute,
+		Handler:      withRequestID(http.DefaultServeMux),
 	}
 
-	fmt.Println("Enhanced Personal Memory API Server starting...")
-	fmt.Println("Server running on http://localhost:8080")
-	
-	fmt.Println("Timeout Configuration:")
-	fmt.Printf("  Short requests:  %s\n", defaultTimeouts.ShortRequest)
-	fmt.Printf("  Medium requests: %s\n", defaultTimeouts.MediumRequest)
-	fmt.Printf("  Long requests:   %s\n", defaultTimeouts.LongRequest)
-	fmt.Printf("  HTTP Client:     %s\n", defaultTimeouts.HTTPClient)
+	logger.Info("server starting",
+		"addr", cfg.ListenAddr,
+		"model", cfg.Model,
+		"config_file", *configPath,
+		"timeout_short", cfg.Timeouts.ShortRequest.String(),
+		"timeout_medium", cfg.Timeouts.MediumRequest.String(),
+		"timeout_long", cfg.Timeouts.LongRequest.String(),
+		"timeout_http_client", cfg.Timeouts.HTTPClient.String(),
+	)
 
 	log.Fatal(server.ListenAndServe())
 }
 
-// Helper function to get duration from environment variable
-func getEnvDuration(envVar string, defaultValue time.Duration) time.Duration {
+// Helper function to get duration from environment variable.
+// Unset variables return defaultValue; invalid values are an error.
+func getEnvDuration(envVar string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(envVar)
+	if value == "" {
+		return defaultValue, nil
+	}
 
-	return 0
+	d, err := parseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid duration %q: %w", envVar, value, err)
+	}
+	return d, nil
 }
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MemoryConfig controls how long idle users stay in RAM and how much
+// each user may keep there.
+type MemoryConfig struct {
+	IdleTTL         time.Duration // users idle longer are evicted; 0 keeps them forever
+	JanitorInterval time.Duration
+	ArchiveDir      string // evicted users are written here; empty drops them
+	MaxHistoryBytes int    // per user, oldest conversations go first
+	MaxFactBytes    int    // per user, oldest facts go first
+}
+
+// hashUsername gives a file-system safe name for a user.
+func hashUsername(username string) string {
+	sum := sha256.Sum256([]byte(username))
+	return hex.EncodeToString(sum[:16])
+}
+
+func archivePath(dir, username string) string {
+	return filepath.Join(dir, hashUsername(username)+".json")
+}
+
+func conversationBytes(conv Conversation) int {
+	n := len(conv.Prompt) + len(conv.Response)
+	for _, call := range conv.ToolCalls {
+		n += len(call.Arguments) + len(call.Result) + len(call.Error)
+	}
+	return n
+}
+
+func historyBytes(user *UserProfile) int {
+	n := 0
+	for _, conv := range user.Conversations {
+		n += conversationBytes(conv)
+	}
+	return n
+}
+
+func factBytes(user *UserProfile) int {
+	n := 0
+	for _, fact := range user.PersonalFacts {
+		n += len(fact)
+	}
+	return n
+}
+
+// enforceQuota drops the oldest conversations and facts until the user
+// fits the configured byte quotas. Callers must hold the lock.
+func (ms *MemoryStore) enforceQuota(user *UserProfile) {
+	cfg := currentConfig().Memory
+
+	history := historyBytes(user)
+	drop := 0
+	for drop < len(user.Conversations) && history > cfg.MaxHistoryBytes {
+		history -= conversationBytes(user.Conversations[drop])
+		drop++
+	}
+	user.Conversations = user.Conversations[drop:]
+
+	facts := factBytes(user)
+	dropFacts := 0
+	for dropFacts < len(user.PersonalFacts) && facts > cfg.MaxFactBytes {
+		facts -= len(user.PersonalFacts[dropFacts])
+		dropFacts++
+	}
+	user.PersonalFacts = user.PersonalFacts[dropFacts:]
+
+	ms.quotaTrims += drop + dropFacts
+}
+
+// readArchive loads an archived user. It returns nil if the user was not
+// archived or the archive cannot be used.
+func readArchive(path, username string) *UserProfile {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error("reading archived user failed", "user", username, "error", err.Error())
+		}
+		return nil
+	}
+
+	var user UserProfile
+	if err := json.Unmarshal(data, &user); err != nil || user.Username != username {
+		logger.Error("archived user is corrupt, leaving it in place", "user", username, "path", path)
+		return nil
+	}
+	if user.Preferences == nil {
+		user.Preferences = make(map[string]string)
+	}
+	return &user
+}
+
+// restore brings an archived user back before a request touches them. The
+// archive is read and removed without holding the lock; concurrent
+// requests for the same user wait for the first one's restore.
+func (ms *MemoryStore) restore(username string) {
+	dir := currentConfig().Memory.ArchiveDir
+	if dir == "" {
+		return
+	}
+
+	ms.RLock()
+	_, loaded := ms.users[username]
+	ms.RUnlock()
+	if loaded {
+		return
+	}
+
+	ms.Lock()
+	if _, ok := ms.users[username]; ok {
+		ms.Unlock()
+		return
+	}
+	if wait, ok := ms.restoring[username]; ok {
+		ms.Unlock()
+		<-wait
+		return
+	}
+	done := make(chan struct{})
+	ms.restoring[username] = done
+	ms.Unlock()
+
+	path := archivePath(dir, username)
+	user := readArchive(path, username)
+	if user != nil {
+		if err := os.Remove(path); err != nil {
+			logger.Warn("removing restored archive failed", "user", username, "error", err.Error())
+		}
+		user.LastSeen = time.Now()
+	}
+
+	ms.Lock()
+	if user != nil {
+		ms.users[username] = user
+		ms.restored++
+	}
+	delete(ms.restoring, username)
+	close(done)
+	ms.Unlock()
+
+	if user != nil {
+		logger.Info("user restored from archive", "user", username, "conversations", len(user.Conversations))
+	}
+}
+
+// evictIdle removes users not seen since before now minus the idle TTL,
+// archiving them first when an archive directory is configured. Archives
+// are written without holding the lock; a user who was used or pinned in
+// the meantime, or whose archive cannot be written, stays in memory.
+func (ms *MemoryStore) evictIdle(now time.Time) int {
+	cfg := currentConfig().Memory
+	if cfg.IdleTTL <= 0 {
+		return 0
+	}
+	cutoff := now.Add(-cfg.IdleTTL)
+
+	ms.evictMu.Lock()
+	defer ms.evictMu.Unlock()
+
+	if cfg.ArchiveDir != "" {
+		if err := os.MkdirAll(cfg.ArchiveDir, 0o700); err != nil {
+			logger.Error("creating archive directory failed", "error", err.Error())
+			return 0
+		}
+	}
+
+	type candidate struct {
+		user *UserProfile
+		data []byte
+	}
+	idle := func(username string, user *UserProfile) bool {
+		return user.LastSeen.Before(cutoff) && ms.pins[username] == 0
+	}
+
+	ms.RLock()
+	candidates := make(map[string]candidate)
+	for username, user := range ms.users {
+		if !idle(username, user) {
+			continue
+		}
+		c := candidate{user: user}
+		if cfg.ArchiveDir != "" {
+			data, err := json.Marshal(user)
+			if err != nil {
+				logger.Error("archiving user failed", "user", username, "error", err.Error())
+				continue
+			}
+			c.data = data
+		}
+		candidates[username] = c
+	}
+	ms.RUnlock()
+
+	if cfg.ArchiveDir != "" {
+		for username, c := range candidates {
+			if err := writeArchive(archivePath(cfg.ArchiveDir, username), c.data); err != nil {
+				logger.Error("archiving user failed", "user", username, "error", err.Error())
+				delete(candidates, username)
+			}
+		}
+	}
+
+	var stale []string
+	evicted := 0
+	ms.Lock()
+	for username, c := range candidates {
+		if ms.users[username] != c.user || !idle(username, c.user) {
+			stale = append(stale, username)
+			continue
+		}
+		delete(ms.users, username)
+		if cfg.ArchiveDir != "" {
+			ms.archived++
+		}
+		ms.evicted++
+		evicted++
+	}
+	ms.Unlock()
+
+	// The user came back while the archive was written; memory is current
+	if cfg.ArchiveDir != "" {
+		for _, username := range stale {
+			os.Remove(archivePath(cfg.ArchiveDir, username))
+		}
+	}
+	return evicted
+}
+
+func writeArchive(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// runJanitor evicts idle users, session personas and document workspaces
+// in the background. The interval and TTL are read on every pass so a
+// config reload takes effect without a restart.
+func (ms *MemoryStore) runJanitor() {
+	for {
+		time.Sleep(currentConfig().Memory.JanitorInterval)
+		if n := ms.evictIdle(time.Now()); n > 0 {
+			logger.Info("evicted idle users", "count", n, "remaining", ms.userCount())
+		}
+		if ttl := currentConfig().Memory.IdleTTL; ttl > 0 {
+			if n := personaStore.evictSessions(time.Now().Add(-ttl)); n > 0 {
+				logger.Info("evicted idle session personas", "count", n)
+			}
+			if n := documentStore.evictIdle(time.Now().Add(-ttl)); n > 0 {
+				logger.Info("evicted idle document workspaces", "count", n)
+			}
+		}
+	}
+}
+
+func (ms *MemoryStore) userCount() int {
+	ms.RLock()
+	defer ms.RUnlock()
+	return len(ms.users)
+}
+
+type userMemoryUsage struct {
+	Username      string    `json:"username"`
+	HistoryBytes  int       `json:"history_bytes"`
+	FactBytes     int       `json:"fact_bytes"`
+	DocumentBytes int       `json:"document_bytes"`
+	Conversations int       `json:"conversations"`
+	LastSeen      time.Time `json:"last_seen"`
+}
+
+type memoryUsage struct {
+	Users         int               `json:"users"`
+	ArchivedUsers int               `json:"archived_users"`
+	HistoryBytes  int               `json:"history_bytes"`
+	FactBytes     int               `json:"fact_bytes"`
+	Evicted       int               `json:"evicted_total"`
+	Archived      int               `json:"archived_total"`
+	Restored      int               `json:"restored_total"`
+	QuotaTrims    int               `json:"quota_trims_total"`
+	Documents     documentUsage     `json:"documents"`
+	IdleTTL       string            `json:"idle_ttl"`
+	Quotas        map[string]int    `json:"quotas"`
+	TopUsers      []userMemoryUsage `json:"top_users"`
+}
+
+func (ms *MemoryStore) usage(top int) memoryUsage {
+	cfg := currentConfig().Memory
+	documentsDir := currentConfig().Documents.Dir
+	documents := documentStore.usage()
+
+	ms.RLock()
+	result := memoryUsage{
+		Users:      len(ms.users),
+		Evicted:    ms.evicted,
+		Archived:   ms.archived,
+		Restored:   ms.restored,
+		QuotaTrims: ms.quotaTrims,
+		Documents:  documents,
+		IdleTTL:    cfg.IdleTTL.String(),
+		Quotas:     map[string]int{"max_history_bytes": cfg.MaxHistoryBytes, "max_fact_bytes": cfg.MaxFactBytes},
+	}
+	users := make([]userMemoryUsage, 0, len(ms.users))
+	for _, user := range ms.users {
+		u := userMemoryUsage{
+			Username:      user.Username,
+			HistoryBytes:  historyBytes(user),
+			FactBytes:     factBytes(user),
+			DocumentBytes: documents.byDir[workspaceDir(documentsDir, user.Username)],
+			Conversations: len(user.Conversations),
+			LastSeen:      user.LastSeen,
+		}
+		result.HistoryBytes += u.HistoryBytes
+		result.FactBytes += u.FactBytes
+		users = append(users, u)
+	}
+	ms.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].HistoryBytes+users[i].FactBytes+users[i].DocumentBytes > users[j].HistoryBytes+users[j].FactBytes+users[j].DocumentBytes
+	})
+	if len(users) > top {
+		users = users[:top]
+	}
+	result.TopUsers = users
+
+	if cfg.ArchiveDir != "" {
+		if entries, err := os.ReadDir(cfg.ArchiveDir); err == nil {
+			for _, e := range entries {
+				if strings.HasSuffix(e.Name(), ".json") {
+					result.ArchivedUsers++
+				}
+			}
+		}
+	}
+	return result
+}
+
+// handleAdminMemory reports memory use across all users (GET) or runs the
+// janitor immediately (POST).
+func handleAdminMemory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(memoryStore.usage(10))
+	case http.MethodPost:
+		evicted := memoryStore.evictIdle(time.Now())
+		workspaces := 0
+		if ttl := currentConfig().Memory.IdleTTL; ttl > 0 {
+			workspaces = documentStore.evictIdle(time.Now().Add(-ttl))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"evicted": evicted, "users": memoryStore.userCount(), "evicted_workspaces": workspaces})
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Only GET and POST methods allowed")
+	}
+}
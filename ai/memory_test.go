@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func withMemoryConfig(t *testing.T, mem MemoryConfig) {
+	t.Helper()
+	previous := activeConfig.Load()
+	cfg := *currentConfig()
+	cfg.Memory = mem
+	activeConfig.Store(&cfg)
+	t.Cleanup(func() { activeConfig.Store(previous) })
+}
+
+func TestEvictAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	withMemoryConfig(t, MemoryConfig{IdleTTL: time.Minute, ArchiveDir: dir, MaxHistoryBytes: 1 << 20, MaxFactBytes: 1 << 20})
+
+	ms := NewMemoryStore(10, defaultTimeouts)
+	ms.getOrCreateUser("alice")
+	ms.addConversation("alice", Conversation{Prompt: "hello", Response: "hi"})
+
+	if n := ms.evictIdle(time.Now()); n != 0 {
+		t.Fatalf("evicted %d active users", n)
+	}
+	if n := ms.evictIdle(time.Now().Add(2 * time.Minute)); n != 1 {
+		t.Fatalf("evicted %d users, want 1", n)
+	}
+	if _, err := os.Stat(archivePath(dir, "alice")); err != nil {
+		t.Fatalf("no archive written: %v", err)
+	}
+
+	user := ms.getOrCreateUser("alice")
+	if len(user.Conversations) != 1 || user.Conversations[0].Prompt != "hello" {
+		t.Fatalf("restored conversations %+v", user.Conversations)
+	}
+	if _, err := os.Stat(archivePath(dir, "alice")); !os.IsNotExist(err) {
+		t.Fatalf("archive left behind after restore: %v", err)
+	}
+}
+
+func TestPinnedUserIsNotEvicted(t *testing.T) {
+	withMemoryConfig(t, MemoryConfig{IdleTTL: time.Minute, MaxHistoryBytes: 1 << 20, MaxFactBytes: 1 << 20})
+
+	ms := NewMemoryStore(10, defaultTimeouts)
+	unpin := ms.pinUser("bob")
+
+	if n := ms.evictIdle(time.Now().Add(2 * time.Minute)); n != 0 {
+		t.Fatalf("evicted a pinned user")
+	}
+	if id := ms.addConversation("bob", Conversation{Prompt: "q", Response: "a"}); id == "" {
+		t.Fatal("turn of a pinned user was dropped")
+	}
+
+	unpin()
+	if n := ms.evictIdle(time.Now().Add(2 * time.Minute)); n != 1 {
+		t.Fatalf("evicted %d users after unpinning, want 1", n)
+	}
+}
+
+func TestIdleWorkspaceIsEvicted(t *testing.T) {
+	ds := NewDocumentStore()
+	dir := t.TempDir()
+
+	ds.Lock()
+	ws, err := ds.load(dir)
+	ds.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds.Lock()
+	ws.inUse++
+	ds.Unlock()
+	if n := ds.evictIdle(time.Now().Add(time.Minute)); n != 0 {
+		t.Fatal("evicted a workspace in use")
+	}
+
+	ds.done(ws)
+	if n := ds.evictIdle(time.Now().Add(time.Minute)); n != 1 {
+		t.Fatalf("evicted %d workspaces, want 1", n)
+	}
+	if u := ds.usage(); u.Workspaces != 0 || u.Evicted != 1 {
+		t.Fatalf("usage after eviction %+v", u)
+	}
+}
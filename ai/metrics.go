@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// activeUserWindow is how recently a user must have been seen to count
+// towards the active users gauge.
+const activeUserWindow = 15 * time.Minute
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets ...float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(sb *strings.Builder, name, help string) {
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, upper := range h.buckets {
+		fmt.Fprintf(sb, "%s_bucket{le=\"%g\"} %d\n", name, upper, h.counts[i])
+	}
+	fmt.Fprintf(sb, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(sb, "%s_sum %g\n%s_count %d\n", name, h.sum, name, h.count)
+}
+
+type requestKey struct {
+	outcome     string
+	timeoutType string
+}
+
+type Metrics struct {
+	sync.Mutex
+	requests     map[requestKey]uint64
+	timeouts     map[string]uint64
+	modelAnswers map[string]uint64 // keyed by the model that answered
+	modelLatency *histogram
+	promptSize   *histogram
+	responseSize *histogram
+	contextSize  *histogram
+}
+
+func NewMetrics() *Metrics {
+	sizes := []float64{64, 256, 1024, 4096, 16384, 65536}
+	return &Metrics{
+		requests:     make(map[requestKey]uint64),
+		timeouts:     make(map[string]uint64),
+		modelAnswers: make(map[string]uint64),
+		modelLatency: newHistogram(0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600),
+		promptSize:   newHistogram(sizes...),
+		responseSize: newHistogram(sizes...),
+		contextSize:  newHistogram(sizes...),
+	}
+}
+
+var metrics = NewMetrics()
+
+// timeoutCategory strips the duration suffix from a timeout label so that
+// "auto_short_30s" becomes "auto_short" and "custom_300s" becomes "custom",
+// keeping label cardinality bounded.
+func timeoutCategory(label string) string {
+	if i := strings.LastIndex(label, "_"); i > 0 {
+		return label[:i]
+	}
+	return label
+}
+
+func (m *Metrics) recordRequest(outcome, timeoutLabel string) {
+	category := timeoutCategory(timeoutLabel)
+
+	m.Lock()
+	defer m.Unlock()
+	m.requests[requestKey{outcome: outcome, timeoutType: category}]++
+	if outcome == "timeout" {
+		m.timeouts[category]++
+	}
+}
+
+func (m *Metrics) recordModelAnswer(model string) {
+	m.Lock()
+	defer m.Unlock()
+	m.modelAnswers[model]++
+}
+
+func (m *Metrics) recordModelCall(latency time.Duration, promptBytes, contextBytes, responseBytes int) {
+	m.Lock()
+	defer m.Unlock()
+	m.modelLatency.observe(latency.Seconds())
+	m.promptSize.observe(float64(promptBytes))
+	m.contextSize.observe(float64(contextBytes))
+	m.responseSize.observe(float64(responseBytes))
+}
+
+func (ms *MemoryStore) activeUsers(window time.Duration) int {
+	ms.RLock()
+	defer ms.RUnlock()
+
+	cutoff := time.Now().Add(-window)
+	active := 0
+	for _, user := range ms.users {
+		if user.LastSeen.After(cutoff) {
+			active++
+		}
+	}
+	return active
+}
+
+func (m *Metrics) render() string {
+	m.Lock()
+	defer m.Unlock()
+
+	var sb strings.Builder
+
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].outcome != keys[j].outcome {
+			return keys[i].outcome < keys[j].outcome
+		}
+		return keys[i].timeoutType < keys[j].timeoutType
+	})
+	sb.WriteString("# HELP ai_prompt_requests_total Prompt requests by outcome and timeout type.\n")
+	sb.WriteString("# TYPE ai_prompt_requests_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "ai_prompt_requests_total{outcome=%q,timeout_type=%q} %d\n", k.outcome, k.timeoutType, m.requests[k])
+	}
+
+	categories := make([]string, 0, len(m.timeouts))
+	for c := range m.timeouts {
+		categories = append(categories, c)
+	}
+	sort.Strings(categories)
+	sb.WriteString("# HELP ai_prompt_timeouts_total Prompt requests that hit their deadline.\n")
+	sb.WriteString("# TYPE ai_prompt_timeouts_total counter\n")
+	for _, c := range categories {
+		fmt.Fprintf(&sb, "ai_prompt_timeouts_total{timeout_type=%q} %d\n", c, m.timeouts[c])
+	}
+
+	models := make([]string, 0, len(m.modelAnswers))
+	for model := range m.modelAnswers {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	sb.WriteString("# HELP ai_model_answers_total Answers by the model that produced them (\"degraded\" for canned responses).\n")
+	sb.WriteString("# TYPE ai_model_answers_total counter\n")
+	for _, model := range models {
+		fmt.Fprintf(&sb, "ai_model_answers_total{model=%q} %d\n", model, m.modelAnswers[model])
+	}
+
+	m.modelLatency.write(&sb, "ai_model_latency_seconds", "Time spent waiting for the model.")
+	m.promptSize.write(&sb, "ai_prompt_size_bytes", "Size of the user prompt.")
+	m.contextSize.write(&sb, "ai_context_size_bytes", "Size of the full prompt sent to the model.")
+	m.responseSize.write(&sb, "ai_response_size_bytes", "Size of the model response.")
+
+	return sb.String()
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, metrics.render())
+	var sb strings.Builder
+	feedbackStats.write(&sb)
+	fmt.Fprint(w, sb.String())
+	fmt.Fprintf(w, "# HELP ai_active_users Users seen in the last %s.\n# TYPE ai_active_users gauge\n", activeUserWindow)
+	fmt.Fprintf(w, "ai_active_users %d\n", memoryStore.activeUsers(activeUserWindow))
+}
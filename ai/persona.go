@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ModelOptions are passed through to Ollama's "options" field.
+type ModelOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	NumPredict  *int     `json:"num_predict,omitempty"`
+}
+
+// Persona shapes how the assistant answers. A user or session persona
+// names a preset and may override any of its fields.
+type Persona struct {
+	Name         string        `json:"name,omitempty"`
+	Preset       string        `json:"preset,omitempty"`
+	SystemPrompt string        `json:"system_prompt,omitempty"`
+	Tone         string        `json:"tone,omitempty"`
+	AnswerLength string        `json:"answer_length,omitempty"` // "short", "medium", "long"
+	Language     string        `json:"language,omitempty"`
+	Model        string        `json:"model,omitempty"`
+	Options      *ModelOptions `json:"options,omitempty"`
+}
+
+var answerLengths = map[string]string{
+	"short":  "Keep answers brief, at most two or three sentences.",
+	"medium": "Answer in a few short paragraphs.",
+	"long":   "Give thorough, detailed answers.",
+}
+
+func (p Persona) validate() error {
+	var errs []error
+
+	if p.AnswerLength != "" {
+		if _, ok := answerLengths[p.AnswerLength]; !ok {
+			errs = append(errs, fmt.Errorf("answer_length must be short, medium or long, got %q", p.AnswerLength))
+		}
+	}
+	if len(p.SystemPrompt) > 4000 {
+		errs = append(errs, errors.New("system_prompt must be at most 4000 characters"))
+	}
+	if o := p.Options; o != nil {
+		if o.Temperature != nil && (*o.Temperature < 0 || *o.Temperature > 2) {
+			errs = append(errs, fmt.Errorf("temperature must be between 0 and 2, got %g", *o.Temperature))
+		}
+		if o.TopP != nil && (*o.TopP <= 0 || *o.TopP > 1) {
+			errs = append(errs, fmt.Errorf("top_p must be in (0, 1], got %g", *o.TopP))
+		}
+		if o.NumPredict != nil && (*o.NumPredict < -1 || *o.NumPredict == 0 || *o.NumPredict > 8192) {
+			errs = append(errs, fmt.Errorf("num_predict must be -1 or between 1 and 8192, got %d", *o.NumPredict))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// overlay returns p with every non-empty field of o applied on top.
+func (p Persona) overlay(o Persona) Persona {
+	if o.SystemPrompt != "" {
+		p.SystemPrompt = o.SystemPrompt
+	}
+	if o.Tone != "" {
+		p.Tone = o.Tone
+	}
+	if o.AnswerLength != "" {
+		p.AnswerLength = o.AnswerLength
+	}
+	if o.Language != "" {
+		p.Language = o.Language
+	}
+	if o.Model != "" {
+		p.Model = o.Model
+	}
+	if o.Options != nil {
+		merged := ModelOptions{}
+		if p.Options != nil {
+			merged = *p.Options
+		}
+		if o.Options.Temperature != nil {
+			merged.Temperature = o.Options.Temperature
+		}
+		if o.Options.TopP != nil {
+			merged.TopP = o.Options.TopP
+		}
+		if o.Options.NumPredict != nil {
+			merged.NumPredict = o.Options.NumPredict
+		}
+		p.Options = &merged
+	}
+	return p
+}
+
+// modelName returns the persona's model, falling back to the configured one
+// when none is set or the model is no longer configured.
+func (p Persona) modelName(cfg *Config) string {
+	if p.Model != "" && knownModel(cfg, p.Model) {
+		return p.Model
+	}
+	return cfg.Model
+}
+
+// knownModel reports whether model is one the server is configured to call:
+// the primary model, a fallback model or a secondary endpoint's model.
+// Personas may only pick these, so users cannot make the server query (and
+// keep a breaker for) arbitrary models.
+func knownModel(cfg *Config, model string) bool {
+	if model == cfg.Model {
+		return true
+	}
+	for _, m := range cfg.Fallback.Models {
+		if m == model {
+			return true
+		}
+	}
+	for _, ep := range cfg.Fallback.Endpoints {
+		if ep.Model == model {
+			return true
+		}
+	}
+	return false
+}
+
+func validateModel(cfg *Config, model string) error {
+	if model != "" && !knownModel(cfg, model) {
+		return fmt.Errorf("unknown model %q", model)
+	}
+	return nil
+}
+
+// instructions renders the persona as the system block at the top of the
+// context sent to the model.
+func (p Persona) instructions() string {
+	var sb strings.Builder
+	if p.SystemPrompt != "" {
+		sb.WriteString(p.SystemPrompt + "\n")
+	}
+	if p.Tone != "" {
+		sb.WriteString("Tone: " + p.Tone + ".\n")
+	}
+	if p.AnswerLength != "" {
+		sb.WriteString(answerLengths[p.AnswerLength] + "\n")
+	}
+	if p.Language != "" {
+		sb.WriteString("Always respond in " + p.Language + ".\n")
+	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	return "System Instructions:\n" + sb.String() + "\n"
+}
+
+func floatPtr(f float64) *float64 { return &f }
+
+type sessionPersona struct {
+	Persona
+	lastUsed time.Time
+}
+
+type PersonaStore struct {
+	sync.RWMutex
+	presets  map[string]Persona
+	users    map[string]Persona        // keyed by username
+	sessions map[string]sessionPersona // keyed by username + "/" + session ID
+}
+
+func NewPersonaStore() *PersonaStore {
+	return &PersonaStore{
+		presets: map[string]Persona{
+			"default": {
+				Name:         "default",
+				SystemPrompt: "You are a helpful personal assistant that remembers what the user has told you.",
+			},
+			"concise": {
+				Name:         "concise",
+				SystemPrompt: "You are a precise assistant. Answer directly without filler.",
+				AnswerLength: "short",
+				Options:      &ModelOptions{Temperature: floatPtr(0.2)},
+			},
+			"teacher": {
+				Name:         "teacher",
+				SystemPrompt: "You are a patient teacher. Explain concepts step by step with simple examples.",
+				Tone:         "encouraging",
+				AnswerLength: "long",
+			},
+			"creative": {
+				Name:         "creative",
+				SystemPrompt: "You are a creative writing partner.",
+				Tone:         "playful",
+				Options:      &ModelOptions{Temperature: floatPtr(1.0), TopP: floatPtr(0.95)},
+			},
+		},
+		users:    make(map[string]Persona),
+		sessions: make(map[string]sessionPersona),
+	}
+}
+
+var personaStore = NewPersonaStore()
+
+func sessionKey(username, sessionID string) string {
+	return username + "/" + sessionID
+}
+
+// resolve returns the effective persona: the session persona if one is set,
+// otherwise the user's, otherwise the "default" preset. Using a session
+// persona keeps it from expiring.
+func (ps *PersonaStore) resolve(username, sessionID string) Persona {
+	ps.Lock()
+	defer ps.Unlock()
+
+	selected, ok := Persona{}, false
+	if sessionID != "" {
+		var session sessionPersona
+		key := sessionKey(username, sessionID)
+		if session, ok = ps.sessions[key]; ok {
+			session.lastUsed = time.Now()
+			ps.sessions[key] = session
+			selected = session.Persona
+		}
+	}
+	if !ok {
+		selected, ok = ps.users[username]
+	}
+
+	preset := "default"
+	if ok && selected.Preset != "" {
+		preset = selected.Preset
+	}
+	base, exists := ps.presets[preset]
+	if !exists {
+		base = ps.presets["default"]
+	}
+	return base.overlay(selected)
+}
+
+func (ps *PersonaStore) set(username, sessionID string, p Persona) error {
+	if err := errors.Join(p.validate(), validateModel(currentConfig(), p.Model)); err != nil {
+		return err
+	}
+
+	ps.Lock()
+	defer ps.Unlock()
+
+	if p.Preset != "" {
+		if _, ok := ps.presets[p.Preset]; !ok {
+			return fmt.Errorf("unknown preset %q", p.Preset)
+		}
+	}
+	p.Name = ""
+	if sessionID != "" {
+		ps.sessions[sessionKey(username, sessionID)] = sessionPersona{Persona: p, lastUsed: time.Now()}
+	} else {
+		ps.users[username] = p
+	}
+	return nil
+}
+
+func (ps *PersonaStore) clear(username, sessionID string) {
+	ps.Lock()
+	defer ps.Unlock()
+
+	if sessionID != "" {
+		delete(ps.sessions, sessionKey(username, sessionID))
+	} else {
+		delete(ps.users, username)
+	}
+}
+
+// evictSessions drops session personas not used since cutoff. Sessions have
+// no explicit end, so they live as long as the idle TTL of user memory.
+func (ps *PersonaStore) evictSessions(cutoff time.Time) int {
+	ps.Lock()
+	defer ps.Unlock()
+
+	evicted := 0
+	for key, session := range ps.sessions {
+		if session.lastUsed.Before(cutoff) {
+			delete(ps.sessions, key)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+func (ps *PersonaStore) listPresets() []Persona {
+	ps.RLock()
+	defer ps.RUnlock()
+
+	result := make([]Persona, 0, len(ps.presets))
+	for _, p := range ps.presets {
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func (ps *PersonaStore) upsertPreset(p Persona) error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+	if p.Preset != "" {
+		return errors.New("presets cannot be based on other presets")
+	}
+	if err := errors.Join(p.validate(), validateModel(currentConfig(), p.Model)); err != nil {
+		return err
+	}
+
+	ps.Lock()
+	defer ps.Unlock()
+	ps.presets[p.Name] = p
+	return nil
+}
+
+func (ps *PersonaStore) deletePreset(name string) error {
+	if name == "default" {
+		return errors.New("the default preset cannot be deleted")
+	}
+
+	ps.Lock()
+	defer ps.Unlock()
+
+	if _, ok := ps.presets[name]; !ok {
+		return fmt.Errorf("unknown preset %q", name)
+	}
+	delete(ps.presets, name)
+	return nil
+}
+
+// handlePersonas lists the available presets.
+func handlePersonas(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Only GET method allowed")
+		return
+	}
+	json.NewEncoder(w).Encode(personaStore.listPresets())
+}
+
+// handlePersona reads (GET), sets (PUT) or clears (DELETE) the caller's
+// persona, or a session's persona when session_id is given.
+func handlePersona(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, ok := checkUserAccess(w, r, "persona", r.URL.Query().Get("user"))
+	if !ok {
+		return
+	}
+	sessionID := r.URL.Query().Get("session_id")
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(personaStore.resolve(username, sessionID))
+
+	case http.MethodPut:
+		var p Persona
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		if err := personaStore.set(username, sessionID, p); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(personaStore.resolve(username, sessionID))
+
+	case http.MethodDelete:
+		personaStore.clear(username, sessionID)
+		json.NewEncoder(w).Encode(personaStore.resolve(username, sessionID))
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Only GET, PUT and DELETE methods allowed")
+	}
+}
+
+// handleAdminPersonas creates or replaces (POST) and deletes (DELETE) presets.
+func handleAdminPersonas(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		var p Persona
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		if err := personaStore.upsertPreset(p); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(personaStore.listPresets())
+
+	case http.MethodDelete:
+		if err := personaStore.deletePreset(r.URL.Query().Get("name")); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(personaStore.listPresets())
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Only POST and DELETE methods allowed")
+	}
+}
@@ -0,0 +1,717 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RemindersConfig controls the reminder scheduler.
+type RemindersConfig struct {
+	Enabled        bool
+	File           string        // pending and undelivered reminders, survives restarts
+	Interval       time.Duration // how often due reminders are checked
+	Timezone       string        // used to read "tomorrow at 9"; empty means the server's zone
+	WebhookURL     string        // fired reminders are POSTed here; empty disables
+	WebhookSecret  string        // signs webhook bodies (X-Signature-256) when set
+	WebhookTimeout time.Duration
+	MaxPerUser     int // pending reminders per user, and fired ones kept in the inbox
+}
+
+func (c RemindersConfig) location() *time.Location {
+	if c.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// Reminder is scheduled by a user. Once fired it waits in the user's inbox
+// until acknowledged.
+type Reminder struct {
+	ID        string     `json:"id"`
+	User      string     `json:"user"`
+	Text      string     `json:"text"`
+	DueAt     time.Time  `json:"due_at"`
+	Status    string     `json:"status"` // "pending" or "fired"
+	Source    string     `json:"source"` // "explicit", "model" or "api"
+	CreatedAt time.Time  `json:"created_at"`
+	FiredAt   *time.Time `json:"fired_at,omitempty"`
+	Webhook   string     `json:"webhook,omitempty"` // delivery result
+}
+
+var (
+	reminderIntent = regexp.MustCompile(`(?i)^\s*(?:/remind(?:\s+me)?|(?:please\s+|can you\s+|could you\s+)?remind\s+me)\b[\s,:]*(.+?)[\s.!?]*$`)
+	relativeWhen   = regexp.MustCompile(`(?i)\bin\s+(\d+|an?|one)\s*(minutes?|mins?|hours?|hrs?|days?|weeks?)\b`)
+	dayWhen        = regexp.MustCompile(`(?i)\b(?:(today|tonight|tomorrow)|(?:on\s+|next\s+)?(monday|tuesday|wednesday|thursday|friday|saturday|sunday)|on\s+(\d{4}-\d{2}-\d{2}))\b`)
+	clockWhen      = regexp.MustCompile(`(?i)\bat\s+(?:(noon|midnight)|(\d{1,2})(?::(\d{2}))?\s*(am|pm)?)\b`)
+	reminderLead   = regexp.MustCompile(`(?i)^(?:to|that|about)\s+`)
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+// parseWhen reads a time expression such as "in 20 minutes", "tomorrow at
+// 9", "friday at 3pm", "on 2026-05-01 at 14:30" or an RFC 3339 timestamp.
+// It returns the due time and the input with the expression removed.
+func parseWhen(text string, now time.Time, loc *time.Location) (time.Time, string, error) {
+	if t, err := time.Parse(time.RFC3339, strings.TrimSpace(text)); err == nil {
+		return t, "", nil
+	}
+	now = now.In(loc)
+	rest := text
+
+	if m := relativeWhen.FindStringSubmatchIndex(rest); m != nil {
+		amount := strings.ToLower(rest[m[2]:m[3]])
+		n := 1
+		if amount != "a" && amount != "an" && amount != "one" {
+			n, _ = strconv.Atoi(amount)
+		}
+		var unit time.Duration
+		switch u := strings.ToLower(rest[m[4]:m[5]]); {
+		case strings.HasPrefix(u, "m"):
+			unit = time.Minute
+		case strings.HasPrefix(u, "h"):
+			unit = time.Hour
+		case strings.HasPrefix(u, "d"):
+			unit = 24 * time.Hour
+		default:
+			unit = 7 * 24 * time.Hour
+		}
+		if n <= 0 || n > 10000 {
+			return time.Time{}, text, fmt.Errorf("cannot schedule %d %s ahead", n, rest[m[4]:m[5]])
+		}
+		return now.Add(time.Duration(n) * unit), rest[:m[0]] + rest[m[1]:], nil
+	}
+
+	day, dayGiven, defaultHour := now, false, 9
+	if m := dayWhen.FindStringSubmatchIndex(rest); m != nil {
+		dayGiven = true
+		switch {
+		case m[2] >= 0:
+			switch strings.ToLower(rest[m[2]:m[3]]) {
+			case "tomorrow":
+				day = now.AddDate(0, 0, 1)
+			case "tonight":
+				defaultHour = 20
+			}
+		case m[4] >= 0:
+			target := weekdays[strings.ToLower(rest[m[4]:m[5]])]
+			ahead := (int(target) - int(now.Weekday()) + 7) % 7
+			if ahead == 0 {
+				ahead = 7
+			}
+			day = now.AddDate(0, 0, ahead)
+		default:
+			d, err := time.ParseInLocation("2006-01-02", rest[m[6]:m[7]], loc)
+			if err != nil {
+				return time.Time{}, text, fmt.Errorf("invalid date %q", rest[m[6]:m[7]])
+			}
+			day = d
+		}
+		rest = rest[:m[0]] + rest[m[1]:]
+	}
+
+	hour, minute, clockGiven, meridiem := defaultHour, 0, false, ""
+	if m := clockWhen.FindStringSubmatchIndex(rest); m != nil {
+		clockGiven = true
+		if m[2] >= 0 {
+			hour = 12
+			if strings.EqualFold(rest[m[2]:m[3]], "midnight") {
+				hour = 0
+			}
+		} else {
+			hour, _ = strconv.Atoi(rest[m[4]:m[5]])
+			if m[6] >= 0 {
+				minute, _ = strconv.Atoi(rest[m[6]:m[7]])
+			}
+			if m[8] >= 0 {
+				meridiem = strings.ToLower(rest[m[8]:m[9]])
+			}
+		}
+		if hour > 23 || minute > 59 || (meridiem != "" && (hour < 1 || hour > 12)) {
+			return time.Time{}, text, fmt.Errorf("invalid time %q", strings.TrimSpace(rest[m[0]:m[1]]))
+		}
+		switch {
+		case meridiem == "pm" && hour < 12:
+			hour += 12
+		case meridiem == "am" && hour == 12:
+			hour = 0
+		}
+		rest = rest[:m[0]] + rest[m[1]:]
+	}
+
+	if !dayGiven && !clockGiven {
+		return time.Time{}, text, errors.New(`could not tell when, try "in 30 minutes" or "tomorrow at 9"`)
+	}
+
+	due := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+	if !dayGiven && !due.After(now) {
+		// "at 5" said in the afternoon most likely means 5pm today
+		if meridiem == "" && hour < 12 && due.Add(12*time.Hour).After(now) {
+			due = due.Add(12 * time.Hour)
+		} else {
+			due = due.AddDate(0, 0, 1)
+		}
+	}
+	return due, rest, nil
+}
+
+// parseReminder recognises explicit reminder requests such as "remind me
+// tomorrow at 9 to review the PR" or "/remind in 20 minutes stretch". It
+// reports ok=false when the prompt is not a reminder request at all.
+func parseReminder(prompt string, now time.Time, loc *time.Location) (text string, due time.Time, ok bool, err error) {
+	m := reminderIntent.FindStringSubmatch(prompt)
+	if m == nil {
+		return "", time.Time{}, false, nil
+	}
+
+	due, rest, err := parseWhen(m[1], now, loc)
+	if err != nil {
+		return "", time.Time{}, true, err
+	}
+	text = strings.Join(strings.Fields(rest), " ")
+	text = reminderLead.ReplaceAllString(text, "")
+	if text == "" {
+		return "", time.Time{}, true, errors.New("what should the reminder say?")
+	}
+	return text, due, true, nil
+}
+
+type ReminderStore struct {
+	sync.Mutex
+	reminders map[string]*Reminder
+	loaded    string // file the reminders were loaded from and are saved to
+}
+
+func NewReminderStore() *ReminderStore {
+	return &ReminderStore{reminders: make(map[string]*Reminder)}
+}
+
+var reminderStore = NewReminderStore()
+
+// load reads the reminders file. A missing file is not an error.
+func (rs *ReminderStore) load(path string) error {
+	rs.Lock()
+	defer rs.Unlock()
+
+	rs.loaded = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var reminders []*Reminder
+	if err := json.Unmarshal(data, &reminders); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, r := range reminders {
+		rs.reminders[r.ID] = r
+	}
+	return nil
+}
+
+// saveLocked writes all reminders atomically to the file they were loaded
+// from, so a config reload naming another file cannot overwrite it with
+// these reminders. Callers must hold the lock.
+func (rs *ReminderStore) saveLocked() error {
+	path := rs.loaded
+	if path == "" {
+		return nil
+	}
+	reminders := make([]*Reminder, 0, len(rs.reminders))
+	for _, r := range rs.reminders {
+		reminders = append(reminders, r)
+	}
+	sort.Slice(reminders, func(i, j int) bool { return reminders[i].DueAt.Before(reminders[j].DueAt) })
+
+	data, err := json.MarshalIndent(reminders, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (rs *ReminderStore) persistLocked() {
+	if err := rs.saveLocked(); err != nil {
+		logger.Error("saving reminders failed", "error", err.Error())
+	}
+}
+
+// add schedules a reminder. Asking twice for the same text at the same
+// time returns the existing reminder, so the explicit parser and the
+// model's set_reminder tool cannot double-book one request.
+func (rs *ReminderStore) add(username, text, source string, due time.Time) (Reminder, error) {
+	cfg := currentConfig().Reminders
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return Reminder{}, errors.New("text is required")
+	}
+	if len(text) > 1000 {
+		return Reminder{}, errors.New("text must be at most 1000 characters")
+	}
+	if !due.After(time.Now()) {
+		return Reminder{}, fmt.Errorf("due time %s is in the past", due.Format(time.RFC3339))
+	}
+
+	rs.Lock()
+	defer rs.Unlock()
+
+	pending := 0
+	for _, r := range rs.reminders {
+		if r.User != username || r.Status != "pending" {
+			continue
+		}
+		if strings.EqualFold(r.Text, text) && r.DueAt.Equal(due) {
+			return *r, nil
+		}
+		pending++
+	}
+	if pending >= cfg.MaxPerUser {
+		return Reminder{}, fmt.Errorf("limit of %d pending reminders reached", cfg.MaxPerUser)
+	}
+
+	r := &Reminder{
+		ID:        "rem_" + randomHex(8),
+		User:      username,
+		Text:      text,
+		DueAt:     due,
+		Status:    "pending",
+		Source:    source,
+		CreatedAt: time.Now(),
+	}
+	rs.reminders[r.ID] = r
+	rs.persistLocked()
+	return *r, nil
+}
+
+var errReminderNotFound = errors.New("reminder not found")
+
+func (rs *ReminderStore) list(username, status string) []Reminder {
+	rs.Lock()
+	defer rs.Unlock()
+
+	result := make([]Reminder, 0)
+	for _, r := range rs.reminders {
+		if r.User == username && (status == "" || r.Status == status) {
+			result = append(result, *r)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].DueAt.Before(result[j].DueAt) })
+	return result
+}
+
+// update changes the text and/or due time of a pending reminder.
+func (rs *ReminderStore) update(username, id, text string, due *time.Time) (Reminder, error) {
+	rs.Lock()
+	defer rs.Unlock()
+
+	r, ok := rs.reminders[id]
+	if !ok || r.User != username {
+		return Reminder{}, errReminderNotFound
+	}
+	if r.Status != "pending" {
+		return Reminder{}, errors.New("only pending reminders can be edited")
+	}
+	text = strings.TrimSpace(text)
+	if len(text) > 1000 {
+		return Reminder{}, errors.New("text must be at most 1000 characters")
+	}
+	if due != nil && !due.After(time.Now()) {
+		return Reminder{}, fmt.Errorf("due time %s is in the past", due.Format(time.RFC3339))
+	}
+
+	if text != "" {
+		r.Text = text
+	}
+	if due != nil {
+		r.DueAt = *due
+	}
+	rs.persistLocked()
+	return *r, nil
+}
+
+// remove cancels a pending reminder or acknowledges a fired one.
+func (rs *ReminderStore) remove(username, id, status string) (Reminder, error) {
+	rs.Lock()
+	defer rs.Unlock()
+
+	r, ok := rs.reminders[id]
+	if !ok || r.User != username || r.Status != status {
+		return Reminder{}, errReminderNotFound
+	}
+	delete(rs.reminders, id)
+	rs.persistLocked()
+	return *r, nil
+}
+
+// acknowledgeAll clears the user's inbox.
+func (rs *ReminderStore) acknowledgeAll(username string) int {
+	rs.Lock()
+	defer rs.Unlock()
+
+	n := 0
+	for id, r := range rs.reminders {
+		if r.User == username && r.Status == "fired" {
+			delete(rs.reminders, id)
+			n++
+		}
+	}
+	if n > 0 {
+		rs.persistLocked()
+	}
+	return n
+}
+
+// fireDue moves every reminder due by now into its owner's inbox and
+// returns them for webhook delivery. Reminders that came due while the
+// server was down fire on the first pass after start. An inbox holds at
+// most limit reminders; the oldest unacknowledged ones are dropped.
+func (rs *ReminderStore) fireDue(now time.Time, limit int) []Reminder {
+	rs.Lock()
+	defer rs.Unlock()
+
+	fired := make([]Reminder, 0)
+	users := make(map[string]bool)
+	for _, r := range rs.reminders {
+		if r.Status != "pending" || r.DueAt.After(now) {
+			continue
+		}
+		firedAt := now
+		r.Status = "fired"
+		r.FiredAt = &firedAt
+		fired = append(fired, *r)
+		users[r.User] = true
+	}
+	for user := range users {
+		rs.pruneInboxLocked(user, limit)
+	}
+	if len(fired) > 0 {
+		rs.persistLocked()
+	}
+	return fired
+}
+
+// pruneInboxLocked drops the user's oldest fired reminders beyond limit.
+// Callers must hold the lock.
+func (rs *ReminderStore) pruneInboxLocked(username string, limit int) {
+	var inbox []*Reminder
+	for _, r := range rs.reminders {
+		if r.User == username && r.Status == "fired" {
+			inbox = append(inbox, r)
+		}
+	}
+	if len(inbox) <= limit {
+		return
+	}
+	sort.Slice(inbox, func(i, j int) bool { return inbox[i].DueAt.Before(inbox[j].DueAt) })
+	for _, r := range inbox[:len(inbox)-limit] {
+		delete(rs.reminders, r.ID)
+	}
+}
+
+func (rs *ReminderStore) setWebhookResult(id, result string) {
+	rs.Lock()
+	defer rs.Unlock()
+
+	if r, ok := rs.reminders[id]; ok {
+		r.Webhook = result
+		rs.persistLocked()
+	}
+}
+
+// runScheduler fires due reminders. The interval is read on every pass so a
+// config reload takes effect without a restart.
+func (rs *ReminderStore) runScheduler() {
+	for {
+		cfg := currentConfig().Reminders
+		time.Sleep(cfg.Interval)
+		if !cfg.Enabled {
+			continue
+		}
+		for _, r := range rs.fireDue(time.Now(), cfg.MaxPerUser) {
+			logger.Info("reminder fired", "user", r.User, "reminder_id", r.ID, "late_ms", time.Since(r.DueAt).Milliseconds())
+			if cfg.WebhookURL != "" {
+				go rs.deliver(r, cfg)
+			}
+		}
+	}
+}
+
+// deliver POSTs a fired reminder to the webhook, retrying connection errors
+// and 5xx responses a few times.
+func (rs *ReminderStore) deliver(r Reminder, cfg RemindersConfig) {
+	body, err := json.Marshal(map[string]interface{}{"type": "reminder", "reminder": r})
+	if err != nil {
+		return
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 2 * time.Second)
+		}
+		lastErr = postWebhook(cfg, body)
+		if lastErr == nil {
+			rs.setWebhookResult(r.ID, "delivered")
+			return
+		}
+		var status *webhookStatusError
+		if errors.As(lastErr, &status) && status.StatusCode < 500 {
+			break
+		}
+	}
+	logger.Warn("reminder webhook failed", "reminder_id", r.ID, "error", lastErr.Error())
+	rs.setWebhookResult(r.ID, "failed: "+lastErr.Error())
+}
+
+type webhookStatusError struct {
+	StatusCode int
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook returned status %d", e.StatusCode)
+}
+
+func postWebhook(cfg RemindersConfig, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.WebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.WebhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(cfg.WebhookSecret))
+		mac.Write(body)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := memoryStore.client().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &webhookStatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// reminderForPrompt schedules a reminder when the prompt is an explicit
+// reminder request. The returned note is appended to the model's context
+// so the answer can confirm it (or explain what went wrong).
+func reminderForPrompt(reqLog *slog.Logger, username, prompt string, cfg *Config) (*Reminder, string) {
+	if !cfg.Reminders.Enabled {
+		return nil, ""
+	}
+	loc := cfg.Reminders.location()
+	text, due, ok, err := parseReminder(prompt, time.Now(), loc)
+	if !ok {
+		return nil, ""
+	}
+	if err == nil {
+		var r Reminder
+		if r, err = reminderStore.add(username, text, "explicit", due); err == nil {
+			reqLog.Info("reminder scheduled", "reminder_id", r.ID, "due_at", r.DueAt)
+			return &r, fmt.Sprintf("\n\n(A reminder %q has been scheduled for %s. Confirm this briefly; do not schedule it again.)",
+				r.Text, r.DueAt.In(loc).Format("Monday, 02 January 2006 15:04 MST"))
+		}
+	}
+	reqLog.Info("reminder request not scheduled", "error", err.Error())
+	return nil, fmt.Sprintf("\n\n(The reminder could not be scheduled: %s. Tell the user briefly.)", err.Error())
+}
+
+func runSetReminder(_ context.Context, tc toolContext, args json.RawMessage) (string, error) {
+	var in struct {
+		Text string `json:"text"`
+		When string `json:"when"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil || strings.TrimSpace(in.Text) == "" || strings.TrimSpace(in.When) == "" {
+		return "", errors.New("text and when are required")
+	}
+	if !tc.Config.Reminders.Enabled {
+		return "", errors.New("reminders are disabled")
+	}
+
+	loc := tc.Config.Reminders.location()
+	due, _, err := parseWhen(in.When, time.Now(), loc)
+	if err != nil {
+		return "", err
+	}
+	r, err := reminderStore.add(tc.Username, in.Text, "model", due)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Reminder %s scheduled for %s", r.ID, r.DueAt.In(loc).Format("Monday, 02 January 2006 15:04 MST")), nil
+}
+
+func init() {
+	registerTool(Tool{
+		Name:        "set_reminder",
+		Description: "Schedule a reminder for the user.",
+		Arguments:   `{"text": "string, what to remind about", "when": "string, e.g. \"in 2 hours\", \"tomorrow at 9am\" or RFC 3339"}`,
+		Run:         runSetReminder,
+	})
+}
+
+type reminderRequest struct {
+	Text     string     `json:"text"`
+	DueAt    *time.Time `json:"due_at"`
+	When     string     `json:"when"`     // natural language alternative to due_at
+	Timezone string     `json:"timezone"` // for when; defaults to the configured zone
+}
+
+// dueTime resolves due_at or when. It returns nil if neither is set.
+func (req reminderRequest) dueTime(cfg RemindersConfig) (*time.Time, error) {
+	if req.DueAt != nil {
+		return req.DueAt, nil
+	}
+	if strings.TrimSpace(req.When) == "" {
+		return nil, nil
+	}
+	loc := cfg.location()
+	if req.Timezone != "" {
+		l, err := time.LoadLocation(req.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("unknown timezone %q", req.Timezone)
+		}
+		loc = l
+	}
+	due, _, err := parseWhen(req.When, time.Now(), loc)
+	if err != nil {
+		return nil, err
+	}
+	return &due, nil
+}
+
+// handleReminders lists (GET, optional ?status=), creates (POST), edits
+// (PATCH ?id=) and cancels (DELETE ?id=) the caller's reminders.
+func handleReminders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, ok := checkUserAccess(w, r, "reminders", r.URL.Query().Get("user"))
+	if !ok {
+		return
+	}
+	cfg := currentConfig().Reminders
+	id := r.URL.Query().Get("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(reminderStore.list(username, r.URL.Query().Get("status")))
+
+	case http.MethodPost, http.MethodPatch:
+		if !cfg.Enabled {
+			writeJSONError(w, http.StatusServiceUnavailable, "Reminders are disabled")
+			return
+		}
+		var req reminderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		due, err := req.dueTime(cfg)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var reminder Reminder
+		if r.Method == http.MethodPost {
+			if due == nil {
+				writeJSONError(w, http.StatusBadRequest, "due_at or when is required")
+				return
+			}
+			reminder, err = reminderStore.add(username, req.Text, "api", *due)
+		} else {
+			reminder, err = reminderStore.update(username, id, req.Text, due)
+		}
+		if errors.Is(err, errReminderNotFound) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(reminder)
+
+	case http.MethodDelete:
+		reminder, err := reminderStore.remove(username, id, "pending")
+		if err != nil {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(reminder)
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Only GET, POST, PATCH and DELETE methods allowed")
+	}
+}
+
+// handleReminderInbox returns fired reminders (GET) and acknowledges them
+// (DELETE ?id=, or all without an id). Clients poll it.
+func handleReminderInbox(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, ok := checkUserAccess(w, r, "reminders", r.URL.Query().Get("user"))
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(reminderStore.list(username, "fired"))
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			json.NewEncoder(w).Encode(map[string]int{"acknowledged": reminderStore.acknowledgeAll(username)})
+			return
+		}
+		if _, err := reminderStore.remove(username, id, "fired"); err != nil {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]int{"acknowledged": 1})
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Only GET and DELETE methods allowed")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Wednesday afternoon
+var (
+	testLoc = time.FixedZone("IST", 5*3600+1800)
+	testNow = time.Date(2025, 3, 5, 14, 10, 0, 0, testLoc)
+)
+
+func at(month time.Month, day, hour, minute int) time.Time {
+	return time.Date(2025, month, day, hour, minute, 0, 0, testLoc)
+}
+
+func TestParseWhen(t *testing.T) {
+	tests := []struct {
+		text string
+		want time.Time
+		rest string
+	}{
+		{"in 20 minutes", testNow.Add(20 * time.Minute), ""},
+		{"in an hour stretch", testNow.Add(time.Hour), " stretch"},
+		{"in 2 days", testNow.Add(48 * time.Hour), ""},
+		{"in 1 week", testNow.Add(7 * 24 * time.Hour), ""},
+		{"tomorrow at 9 review", at(3, 6, 9, 0), " review"},
+		{"tomorrow", at(3, 6, 9, 0), ""},
+		{"tonight", at(3, 5, 20, 0), ""},
+		{"friday at 3pm", at(3, 7, 15, 0), " "},
+		{"next wednesday", at(3, 12, 9, 0), ""},
+		{"on 2025-05-01 at 14:30", at(5, 1, 14, 30), " "},
+		{"at 5", at(3, 5, 17, 0), ""},    // 5am has passed, 5pm has not
+		{"at 9am", at(3, 6, 9, 0), ""},   // passed today, so tomorrow
+		{"at noon", at(3, 6, 12, 0), ""}, // passed today, so tomorrow
+		{"at midnight", at(3, 6, 0, 0), ""},
+		{"tomorrow at 12am", at(3, 6, 0, 0), " "},
+		{"at 14:45", at(3, 5, 14, 45), ""},
+		{"2025-03-06T10:00:00Z", time.Date(2025, 3, 6, 10, 0, 0, 0, time.UTC), ""},
+	}
+	for _, tt := range tests {
+		got, rest, err := parseWhen(tt.text, testNow, testLoc)
+		if err != nil || !got.Equal(tt.want) || rest != tt.rest {
+			t.Errorf("parseWhen(%q) = %v, %q, %v, want %v, %q", tt.text, got, rest, err, tt.want, tt.rest)
+		}
+	}
+
+	for _, text := range []string{"whenever", "at 25", "at 13pm", "at 9:75", "in 0 minutes", "in 20000 days", "on 2025-02-30"} {
+		if got, _, err := parseWhen(text, testNow, testLoc); err == nil {
+			t.Errorf("parseWhen(%q) = %v, want an error", text, got)
+		}
+	}
+}
+
+func TestParseReminder(t *testing.T) {
+	tests := []struct {
+		prompt string
+		text   string
+		due    time.Time
+		ok     bool
+		err    bool
+	}{
+		{"remind me tomorrow at 9 to review the PR", "review the PR", at(3, 6, 9, 0), true, false},
+		{"/remind in 20 minutes stretch", "stretch", testNow.Add(20 * time.Minute), true, false},
+		{"Please remind me in 2 hours about the oven.", "the oven", testNow.Add(2 * time.Hour), true, false},
+		{"Can you remind me that the demo is on friday at 3pm?", "the demo is", at(3, 7, 15, 0), true, false},
+		{"What is a reminder?", "", time.Time{}, false, false},
+		{"Don't remind me of that", "", time.Time{}, false, false},
+		{"remind me to call mom", "", time.Time{}, true, true},
+		{"remind me in 5 minutes", "", time.Time{}, true, true},
+	}
+	for _, tt := range tests {
+		text, due, ok, err := parseReminder(tt.prompt, testNow, testLoc)
+		if ok != tt.ok || (err != nil) != tt.err || text != tt.text || !due.Equal(tt.due) {
+			t.Errorf("parseReminder(%q) = %q, %v, %v, %v", tt.prompt, text, due, ok, err)
+		}
+	}
+}
+
+func withRemindersConfig(t *testing.T, rem RemindersConfig) {
+	t.Helper()
+	previous := activeConfig.Load()
+	cfg := *currentConfig()
+	cfg.Reminders = rem
+	activeConfig.Store(&cfg)
+	t.Cleanup(func() { activeConfig.Store(previous) })
+}
+
+func TestReminderLimits(t *testing.T) {
+	withRemindersConfig(t, RemindersConfig{Enabled: true, MaxPerUser: 3})
+	rs := NewReminderStore()
+	due := time.Now().Add(time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if _, err := rs.add("alice", fmt.Sprintf("task %d", i), "api", due); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := rs.add("alice", "one too many", "api", due); err == nil || !strings.Contains(err.Error(), "limit") {
+		t.Errorf("fourth pending reminder: %v", err)
+	}
+	if r, err := rs.add("alice", "TASK 0", "api", due); err != nil || r.Text != "task 0" {
+		t.Errorf("repeated reminder: %+v, %v", r, err)
+	}
+
+	// Fired reminders do not count against the pending limit...
+	if fired := rs.fireDue(due, 3); len(fired) != 3 {
+		t.Fatalf("fired %d reminders", len(fired))
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := rs.add("alice", fmt.Sprintf("later %d", i), "api", due.Add(time.Duration(i+1)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// ...but the inbox keeps only the newest of them
+	rs.fireDue(due.Add(2*time.Hour), 3)
+	inbox := rs.list("alice", "fired")
+	if len(inbox) != 3 {
+		t.Fatalf("inbox holds %d reminders, want 3", len(inbox))
+	}
+	if inbox[len(inbox)-1].Text != "later 1" || inbox[0].DueAt.Before(due) {
+		t.Errorf("inbox %+v", inbox)
+	}
+	if pending := rs.list("alice", "pending"); len(pending) != 1 || pending[0].Text != "later 2" {
+		t.Errorf("pending %+v", pending)
+	}
+}
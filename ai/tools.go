@@ -0,0 +1,577 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	maxToolFileSize   = 64 * 1024
+	maxExpressionSize = 1000
+)
+
+// ToolsConfig controls the tool-calling loop.
+type ToolsConfig struct {
+	Enabled    bool
+	MaxSteps   int           // tool calls allowed per prompt
+	Timeout    time.Duration // per tool call
+	SandboxDir string        // read_file sees only <SandboxDir>/<username>
+}
+
+// ToolCall records a single tool invocation for the response and history.
+type ToolCall struct {
+	Tool      string          `json:"tool"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+	Result    string          `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Duration  string          `json:"duration"`
+}
+
+type toolContext struct {
+	Username string
+	Config   *Config
+}
+
+type Tool struct {
+	Name        string
+	Description string
+	Arguments   string // argument schema shown to the model
+	Run         func(ctx context.Context, tc toolContext, args json.RawMessage) (string, error)
+}
+
+var toolRegistry = map[string]Tool{}
+
+func registerTool(t Tool) {
+	toolRegistry[t.Name] = t
+}
+
+func init() {
+	registerTool(Tool{
+		Name:        "calculator",
+		Description: "Evaluate an arithmetic expression with + - * / % ^ and parentheses.",
+		Arguments:   `{"expression": "string"}`,
+		Run:         runCalculator,
+	})
+	registerTool(Tool{
+		Name:        "current_time",
+		Description: "Get the current date and time, optionally in an IANA time zone such as Asia/Kolkata.",
+		Arguments:   `{"timezone": "string, optional"}`,
+		Run:         runCurrentTime,
+	})
+	registerTool(Tool{
+		Name:        "memory_search",
+		Description: "Search the user's remembered facts and past conversations.",
+		Arguments:   `{"query": "string"}`,
+		Run:         runMemorySearch,
+	})
+	registerTool(Tool{
+		Name:        "read_file",
+		Description: "Read a text file from the user's document folder.",
+		Arguments:   `{"path": "string, relative to the document folder"}`,
+		Run:         runReadFile,
+	})
+}
+
+// toolInstructions describes the registered tools and the call format.
+func toolInstructions() string {
+	names := make([]string, 0, len(toolRegistry))
+	for name := range toolRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString("You can use tools. To call a tool, reply with ONLY a JSON object on one line:\n")
+	sb.WriteString(`{"tool": "<name>", "arguments": {...}}` + "\n")
+	sb.WriteString("After the tool result is shown, either call another tool or reply with the final answer as plain text.\n")
+	sb.WriteString("Available tools:\n")
+	for _, name := range names {
+		t := toolRegistry[name]
+		fmt.Fprintf(&sb, "- %s: %s Arguments: %s\n", t.Name, t.Description, t.Arguments)
+	}
+	return sb.String()
+}
+
+type toolRequest struct {
+	Tool      string          `json:"tool"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// parseToolRequest reports whether the model reply is a tool call. Only a
+// reply that is a JSON object (optionally fenced) counts, so answers that
+// merely mention JSON are left alone.
+func parseToolRequest(reply string) (toolRequest, bool) {
+	text := strings.TrimSpace(reply)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```")
+		text = strings.TrimPrefix(text, "json")
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+		text = strings.TrimSpace(text)
+	}
+	if !strings.HasPrefix(text, "{") || !strings.HasSuffix(text, "}") {
+		return toolRequest{}, false
+	}
+
+	var req toolRequest
+	if err := json.Unmarshal([]byte(text), &req); err != nil || req.Tool == "" {
+		return toolRequest{}, false
+	}
+	return req, true
+}
+
+func callTool(ctx context.Context, tc toolContext, req toolRequest) ToolCall {
+	start := time.Now()
+	call := ToolCall{Tool: req.Tool, Arguments: req.Arguments}
+
+	tool, ok := toolRegistry[req.Tool]
+	if !ok {
+		call.Error = fmt.Sprintf("unknown tool %q", req.Tool)
+		call.Duration = time.Since(start).String()
+		return call
+	}
+
+	// Tools run on the caller's goroutine and must give up once toolCtx
+	// is done, so a slow tool cannot outlive the request
+	toolCtx, cancel := context.WithTimeout(ctx, tc.Config.Tools.Timeout)
+	defer cancel()
+
+	result, err := tool.Run(toolCtx, tc, req.Arguments)
+	switch {
+	case errors.Is(toolCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil:
+		call.Error = fmt.Sprintf("tool timed out after %s", tc.Config.Tools.Timeout)
+	case err != nil:
+		call.Error = err.Error()
+	default:
+		call.Result = result
+	}
+
+	call.Duration = time.Since(start).String()
+	return call
+}
+
+// runToolLoop queries the model, executes any tool it asks for, feeds the
+// result back and repeats until the model answers in plain text. If it
+// still asks for a tool after the step limit, the answer summarizes the
+// tool results instead. With a non-nil onToken the answer is streamed;
+// replies that may be tool calls are held back until they are complete.
+func runToolLoop(ctx context.Context, username, fullPrompt string, persona Persona, onToken func(string)) (ModelAnswer, []ToolCall, error) {
+	cfg := currentConfig()
+	if !cfg.Tools.Enabled {
+		if onToken != nil {
+			answer, err := queryLLaMAStream(ctx, fullPrompt, persona, onToken)
+			return answer, nil, err
+		}
+		answer, err := queryLLaMA(ctx, fullPrompt, persona)
+		return answer, nil, err
+	}
+
+	tc := toolContext{Username: username, Config: cfg}
+	calls := make([]ToolCall, 0)
+
+	var transcript strings.Builder
+	transcript.WriteString(toolInstructions())
+	transcript.WriteString("\n")
+	transcript.WriteString(fullPrompt)
+
+	for step := 0; ; step++ {
+		if step == cfg.Tools.MaxSteps {
+			transcript.WriteString("\n\nTool limit reached. Reply with the final answer now, without calling tools.")
+		}
+
+		var answer ModelAnswer
+		var err error
+		held := ""
+		streaming := false
+		if onToken == nil {
+			answer, err = queryLLaMA(ctx, transcript.String(), persona)
+		} else {
+			answer, err = queryLLaMAStream(ctx, transcript.String(), persona, func(token string) {
+				if streaming {
+					onToken(token)
+					return
+				}
+				// parseToolRequest only accepts replies starting with
+				// "{" or a fence; anything else can go out as it comes
+				held += token
+				if text := strings.TrimSpace(held); text != "" && text[0] != '{' && text[0] != '`' {
+					streaming = true
+					onToken(held)
+				}
+			})
+		}
+		if err != nil {
+			return answer, calls, err
+		}
+		reply := answer.Text
+
+		req, isCall := parseToolRequest(reply)
+		if isCall && step >= cfg.Tools.MaxSteps {
+			answer.Text = toolLimitAnswer(calls)
+			if onToken != nil {
+				onToken(answer.Text)
+			}
+			return answer, calls, nil
+		}
+		if !isCall {
+			if onToken != nil && !streaming && held != "" {
+				onToken(held)
+			}
+			return answer, calls, nil
+		}
+
+		call := callTool(ctx, tc, req)
+		calls = append(calls, call)
+
+		result := call.Result
+		if call.Error != "" {
+			result = "error: " + call.Error
+		}
+		fmt.Fprintf(&transcript, "\n\nAssistant: %s\nTool result (%s): %s", strings.TrimSpace(reply), call.Tool, result)
+	}
+}
+
+// toolLimitAnswer is the answer given when the model keeps calling tools
+// past the step limit: what the tools returned, rather than another call.
+func toolLimitAnswer(calls []ToolCall) string {
+	var sb strings.Builder
+	sb.WriteString("I could not finish the answer within the tool call limit.")
+	if len(calls) > 0 {
+		sb.WriteString(" Tool results so far:")
+		for _, call := range calls {
+			result := call.Result
+			if call.Error != "" {
+				result = "error: " + call.Error
+			}
+			fmt.Fprintf(&sb, "\n- %s: %s", call.Tool, truncate(result, 300))
+		}
+	}
+	return sb.String()
+}
+
+func runCalculator(_ context.Context, _ toolContext, args json.RawMessage) (string, error) {
+	var in struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil || strings.TrimSpace(in.Expression) == "" {
+		return "", errors.New("expression is required")
+	}
+	if len(in.Expression) > maxExpressionSize {
+		return "", fmt.Errorf("expression must be at most %d characters", maxExpressionSize)
+	}
+
+	p := &exprParser{input: in.Expression}
+	value, err := p.parse()
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatFloat(value, 'g', 12, 64), nil
+}
+
+func runCurrentTime(_ context.Context, _ toolContext, args json.RawMessage) (string, error) {
+	var in struct {
+		Timezone string `json:"timezone"`
+	}
+	if len(args) > 0 {
+		json.Unmarshal(args, &in)
+	}
+
+	loc := time.Local
+	if in.Timezone != "" {
+		l, err := time.LoadLocation(in.Timezone)
+		if err != nil {
+			return "", fmt.Errorf("unknown timezone %q", in.Timezone)
+		}
+		loc = l
+	}
+	return time.Now().In(loc).Format("Monday, 02 January 2006 15:04:05 MST"), nil
+}
+
+func runMemorySearch(ctx context.Context, tc toolContext, args json.RawMessage) (string, error) {
+	var in struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil || strings.TrimSpace(in.Query) == "" {
+		return "", errors.New("query is required")
+	}
+
+	matches := memoryStore.searchMemory(tc.Username, in.Query, 5)
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "No matching memories.", nil
+	}
+	return strings.Join(matches, "\n"), nil
+}
+
+// userSandbox returns the user's folder below the sandbox directory. Users
+// whose name is not a single path element have no folder.
+func userSandbox(dir, username string) (string, error) {
+	if username == "" || username == "." || username == ".." || strings.ContainsAny(username, `/\`) {
+		return "", errors.New("document folder is not available")
+	}
+	return filepath.Abs(filepath.Join(dir, username))
+}
+
+// runReadFile reads a file below the user's sandbox folder. Paths are
+// resolved (including symlinks) and rejected if they escape it.
+func runReadFile(ctx context.Context, tc toolContext, args json.RawMessage) (string, error) {
+	var in struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil || strings.TrimSpace(in.Path) == "" {
+		return "", errors.New("path is required")
+	}
+
+	root, err := userSandbox(tc.Config.Tools.SandboxDir, tc.Username)
+	if err != nil {
+		return "", errors.New("document folder is not available")
+	}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+
+	target := filepath.Join(root, filepath.Clean("/"+in.Path))
+	resolved, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return "", fmt.Errorf("file not found: %s", in.Path)
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New("path is outside the document folder")
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", fmt.Errorf("not a regular file: %s", in.Path)
+	}
+
+	f, err := os.Open(resolved)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s", in.Path)
+	}
+	defer f.Close()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(io.LimitReader(f, maxToolFileSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s", in.Path)
+	}
+	if info.Size() > maxToolFileSize {
+		return string(data) + "\n[truncated]", nil
+	}
+	return string(data), nil
+}
+
+// searchMemory returns facts and conversation snippets for username that
+// contain every word of query, newest conversations first.
+func (ms *MemoryStore) searchMemory(username, query string, limit int) []string {
+	ms.RLock()
+	defer ms.RUnlock()
+
+	user := ms.users[username]
+	if user == nil {
+		return nil
+	}
+
+	words := strings.Fields(strings.ToLower(query))
+	matchesAll := func(text string) bool {
+		lower := strings.ToLower(text)
+		for _, w := range words {
+			if !strings.Contains(lower, w) {
+				return false
+			}
+		}
+		return true
+	}
+
+	results := make([]string, 0, limit)
+	for _, fact := range user.PersonalFacts {
+		if len(results) < limit && matchesAll(fact) {
+			results = append(results, "Fact: "+fact)
+		}
+	}
+	for i := len(user.Conversations) - 1; i >= 0 && len(results) < limit; i-- {
+		conv := user.Conversations[i]
+		if !conv.downvoted() && matchesAll(conv.Prompt+" "+conv.Response) {
+			results = append(results, fmt.Sprintf("[%s] User: %s | Assistant: %s",
+				conv.Timestamp.Format("2006-01-02 15:04"), conv.Prompt, truncate(conv.Response, 300)))
+		}
+	}
+	return results
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// exprParser is a small recursive descent parser for the calculator tool.
+//
+//	expr   = term { ("+" | "-") term }
+//	term   = factor { ("*" | "/" | "%") factor }
+//	factor = unary [ "^" factor ]
+//	unary  = [ "-" | "+" ] primary
+//	primary = number | "(" expr ")"
+type exprParser struct {
+	input string
+	pos   int
+}
+
+func (p *exprParser) parse() (float64, error) {
+	v, err := p.expr()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0, errors.New("result is not a finite number")
+	}
+	return v, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) expr() (float64, error) {
+	v, err := p.term()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch p.peek() {
+		case '+', '-':
+			op := p.input[p.pos]
+			p.pos++
+			rhs, err := p.term()
+			if err != nil {
+				return 0, err
+			}
+			if op == '+' {
+				v += rhs
+			} else {
+				v -= rhs
+			}
+		default:
+			return v, nil
+		}
+	}
+}
+
+func (p *exprParser) term() (float64, error) {
+	v, err := p.factor()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch p.peek() {
+		case '*', '/', '%':
+			op := p.input[p.pos]
+			p.pos++
+			rhs, err := p.factor()
+			if err != nil {
+				return 0, err
+			}
+			switch op {
+			case '*':
+				v *= rhs
+			case '/':
+				if rhs == 0 {
+					return 0, errors.New("division by zero")
+				}
+				v /= rhs
+			case '%':
+				if rhs == 0 {
+					return 0, errors.New("division by zero")
+				}
+				v = math.Mod(v, rhs)
+			}
+		default:
+			return v, nil
+		}
+	}
+}
+
+func (p *exprParser) factor() (float64, error) {
+	base, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() == '^' {
+		p.pos++
+		exp, err := p.factor()
+		if err != nil {
+			return 0, err
+		}
+		return math.Pow(base, exp), nil
+	}
+	return base, nil
+}
+
+func (p *exprParser) unary() (float64, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		v, err := p.unary()
+		return -v, err
+	case '+':
+		p.pos++
+		return p.unary()
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (float64, error) {
+	if p.peek() == '(' {
+		p.pos++
+		v, err := p.expr()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+		return v, nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.input) && (p.input[p.pos] >= '0' && p.input[p.pos] <= '9' || p.input[p.pos] == '.') {
+		p.pos++
+	}
+	if start == p.pos {
+		if p.pos >= len(p.input) {
+			return 0, errors.New("unexpected end of expression")
+		}
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+	return strconv.ParseFloat(p.input[start:p.pos], 64)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCalculator(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"(1 + 2) * 3", "9"},
+		{"10 - 4 - 3", "3"},
+		{"2 ^ 3 ^ 2", "512"},
+		{"-3 + +5", "2"},
+		{"7 % 4", "3"},
+		{"1 / 3", "0.333333333333"},
+		{" 2.5*4 ", "10"},
+	}
+	for _, tt := range tests {
+		args, _ := json.Marshal(map[string]string{"expression": tt.expr})
+		got, err := runCalculator(context.Background(), toolContext{}, args)
+		if err != nil || got != tt.want {
+			t.Errorf("%q = %q, %v, want %q", tt.expr, got, err, tt.want)
+		}
+	}
+
+	for _, expr := range []string{
+		"",
+		"1 / 0",
+		"5 % 0",
+		"(1 + 2",
+		"1 +",
+		"2 * x",
+		"1 2",
+		"1..2",
+		"10 ^ 400",
+		strings.Repeat("1+", maxExpressionSize),
+	} {
+		args, _ := json.Marshal(map[string]string{"expression": expr})
+		if got, err := runCalculator(context.Background(), toolContext{}, args); err == nil {
+			t.Errorf("%q = %q, want an error", expr, got)
+		}
+	}
+}
+
+func TestReadFileSandbox(t *testing.T) {
+	base := t.TempDir()
+	sandbox := filepath.Join(base, "sandbox")
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(sandbox, "alice", "notes.txt"), "alice's notes")
+	write(filepath.Join(sandbox, "alice", "docs", "plan.md"), "the plan")
+	write(filepath.Join(sandbox, "alice", "big.txt"), strings.Repeat("x", maxToolFileSize+10))
+	write(filepath.Join(sandbox, "bob", "secret.txt"), "bob's secret")
+	write(filepath.Join(base, "outside", "passwd"), "root:x:0:0")
+
+	symlink := func(target, link string) {
+		t.Helper()
+		if err := os.Symlink(target, filepath.Join(sandbox, "alice", link)); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	symlink(filepath.Join(sandbox, "bob", "secret.txt"), "bob-secret")
+	symlink(filepath.Join(base, "outside"), "outside")
+	symlink("../bob", "bob")
+	symlink("docs/plan.md", "plan-link")
+
+	cfg := &Config{Tools: ToolsConfig{SandboxDir: sandbox}}
+	read := func(user, path string) (string, error) {
+		args, _ := json.Marshal(map[string]string{"path": path})
+		return runReadFile(context.Background(), toolContext{Username: user, Config: cfg}, args)
+	}
+
+	for path, want := range map[string]string{
+		"notes.txt":           "alice's notes",
+		"docs/plan.md":        "the plan",
+		"./docs/../notes.txt": "alice's notes",
+		"plan-link":           "the plan",
+	} {
+		if got, err := read("alice", path); err != nil || got != want {
+			t.Errorf("%s: %q, %v, want %q", path, got, err, want)
+		}
+	}
+
+	if got, err := read("alice", "big.txt"); err != nil || !strings.HasSuffix(got, "\n[truncated]") || len(got) != maxToolFileSize+len("\n[truncated]") {
+		t.Errorf("big file: %d bytes, %v", len(got), err)
+	}
+
+	escapes := []struct{ user, path string }{
+		{"alice", "../bob/secret.txt"},
+		{"alice", "../../outside/passwd"},
+		{"alice", filepath.Join(base, "outside", "passwd")},
+		{"alice", "/etc/passwd"},
+		{"alice", "bob-secret"},
+		{"alice", "outside/passwd"},
+		{"alice", "bob/secret.txt"},
+		{"alice", "docs"},
+		{"alice", "missing.txt"},
+		{"../bob", "secret.txt"},
+		{"..", "bob/secret.txt"},
+		{"", "bob/secret.txt"},
+	}
+	for _, e := range escapes {
+		got, err := read(e.user, e.path)
+		if err == nil {
+			t.Errorf("user %q read %q: %q", e.user, e.path, got)
+		}
+		if strings.Contains(got, "secret") || strings.Contains(got, "root:") {
+			t.Errorf("user %q read %q leaked %q", e.user, e.path, got)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteWait    = 10 * time.Second
+	wsPongWait     = 60 * time.Second
+	wsPingInterval = 30 * time.Second
+	wsMaxMessage   = 64 * 1024
+	wsHistoryLimit = 20
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	// The API is already token protected and the REST endpoints allow any
+	// origin, so the socket does too.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// Client -> server: "prompt", "cancel", "typing", "ping".
+// Server -> client: "history", "start", "token", "done", "cancelled",
+// "error", "typing", "pong".
+type wsMessage struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+
+	// prompt
+	Prompt        string `json:"prompt,omitempty"`
+	TimeoutType   string `json:"timeout_type,omitempty"`
+	CustomTimeout int    `json:"custom_timeout,omitempty"`
+	SessionID     string `json:"session_id,omitempty"`
+
+	// server events
+	Token          string         `json:"token,omitempty"`
+	Response       string         `json:"response,omitempty"`
+	Error          string         `json:"error,omitempty"`
+	RequestID      string         `json:"request_id,omitempty"`
+	TimeoutUsed    string         `json:"timeout_used,omitempty"`
+	ProcessingTime string         `json:"processing_time,omitempty"`
+	Typing         *bool          `json:"typing,omitempty"`
+	Conversations  []Conversation `json:"conversations,omitempty"`
+	Citations      []Citation     `json:"citations,omitempty"`
+	Model          string         `json:"model,omitempty"`
+	Degraded       bool           `json:"degraded,omitempty"`
+	ConversationID string         `json:"conversation_id,omitempty"`
+	Reminder       *Reminder      `json:"reminder,omitempty"`
+	ToolCalls      []ToolCall     `json:"tool_calls,omitempty"`
+}
+
+type wsSession struct {
+	conn     *websocket.Conn
+	token    *APIToken
+	username string
+	ctx      context.Context
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+func (s *wsSession) send(msg wsMessage) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return s.conn.WriteJSON(msg)
+}
+
+func (s *wsSession) sendTyping(id string, typing bool) {
+	s.send(wsMessage{Type: "typing", ID: id, Typing: &typing})
+}
+
+// handleWebSocket upgrades an authenticated request to a chat socket.
+// Dropping the connection cancels every generation it started.
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	token := tokenFromContext(r.Context())
+	if _, ok := checkUserAccess(w, r, "ws_connect", r.URL.Query().Get("user")); !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		loggerFromContext(r.Context()).Warn("websocket upgrade failed", "error", err.Error())
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	session := &wsSession{
+		conn:     conn,
+		token:    token,
+		username: token.User,
+		ctx:      ctx,
+		cancels:  make(map[string]context.CancelFunc),
+	}
+	reqLog := loggerFromContext(r.Context()).With("user", token.User)
+	reqLog.Info("websocket connected")
+
+	memoryStore.getOrCreateUser(token.User)
+	session.send(wsMessage{Type: "history", Conversations: memoryStore.recentConversations(token.User, wsHistoryLimit)})
+
+	conn.SetReadLimit(wsMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		return nil
+	})
+
+	go session.heartbeat()
+
+	for {
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				reqLog.Warn("websocket read failed", "error", err.Error())
+			}
+			break
+		}
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		switch msg.Type {
+		case "prompt":
+			session.startPrompt(msg)
+		case "cancel":
+			if !session.cancel(msg.ID) {
+				session.send(wsMessage{Type: "error", ID: msg.ID, Error: "No active generation with this id"})
+			}
+		case "typing":
+			memoryStore.getOrCreateUser(token.User)
+		case "ping":
+			session.send(wsMessage{Type: "pong", ID: msg.ID})
+		default:
+			session.send(wsMessage{Type: "error", ID: msg.ID, Error: "Unknown message type"})
+		}
+	}
+
+	reqLog.Info("websocket disconnected")
+}
+
+// heartbeat pings the client so dead connections are noticed even when
+// nobody is typing.
+func (s *wsSession) heartbeat() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.writeMu.Lock()
+			err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+			s.writeMu.Unlock()
+			if err != nil {
+				s.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (s *wsSession) cancel(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cancel, ok := s.cancels[id]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+func (s *wsSession) startPrompt(msg wsMessage) {
+	if msg.ID == "" {
+		s.send(wsMessage{Type: "error", Error: "Prompt messages need an id"})
+		return
+	}
+	if strings.TrimSpace(msg.Prompt) == "" {
+		s.send(wsMessage{Type: "error", ID: msg.ID, Error: "Prompt field is required"})
+		return
+	}
+	if len(msg.Prompt) > 10000 {
+		s.send(wsMessage{Type: "error", ID: msg.ID, Error: "Prompt too long (max 10000 characters)"})
+		return
+	}
+	if !tokenStore.allow(s.token) {
+		s.send(wsMessage{Type: "error", ID: msg.ID, Error: "Rate limit exceeded"})
+		return
+	}
+
+	userInput := UserPrompt{
+		Prompt:        msg.Prompt,
+		User:          s.username,
+		TimeoutType:   msg.TimeoutType,
+		CustomTimeout: msg.CustomTimeout,
+		SessionID:     msg.SessionID,
+	}
+
+	unpin := memoryStore.pinUser(s.username)
+	ctx, cancel := context.WithCancel(s.ctx)
+
+	s.mu.Lock()
+	if _, busy := s.cancels[msg.ID]; busy {
+		s.mu.Unlock()
+		cancel()
+		unpin()
+		s.send(wsMessage{Type: "error", ID: msg.ID, Error: "A generation with this id is already running"})
+		return
+	}
+	s.cancels[msg.ID] = cancel
+	s.mu.Unlock()
+
+	requestID := "req_" + randomHex(8)
+	tokenStore.recordAudit(AuditEntry{TokenID: s.token.ID, User: s.username, Action: "ws_prompt", Target: s.username, Allowed: true, RequestID: requestID})
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.cancels, msg.ID)
+			s.mu.Unlock()
+			cancel()
+			unpin()
+		}()
+
+		// Building the prompt can wait on document retrieval, so it runs
+		// here rather than on the read loop, and a cancel for this id
+		// already stops it.
+		reqLog := logger.With("request_id", requestID, "user", s.username)
+		cfg := currentConfig()
+		citations := documentsForPrompt(ctx, reqLog, userInput, cfg)
+		persona := personaStore.resolve(userInput.User, userInput.SessionID)
+		fullPrompt := memoryStore.buildContext(userInput.User, userInput.Prompt, userInput.TimeoutType, persona, citations)
+		reminder, reminderNote := reminderForPrompt(reqLog, userInput.User, userInput.Prompt, cfg)
+		fullPrompt += reminderNote
+		model := persona.modelName(cfg)
+		requestTimeout, timeoutLabel, _ := chooseTimeout(userInput, cfg, len(fullPrompt), model)
+		reqLog = reqLog.With("timeout", timeoutLabel)
+
+		if ctx.Err() != nil {
+			metrics.recordRequest("cancelled", timeoutLabel)
+			reqLog.Info("websocket prompt cancelled before generation")
+			s.send(wsMessage{Type: "cancelled", ID: msg.ID, RequestID: requestID})
+			return
+		}
+		s.send(wsMessage{Type: "start", ID: msg.ID, RequestID: requestID, TimeoutUsed: timeoutLabel})
+
+		// The deadline covers generation only, as it does for /prompt.
+		ctx, stop := context.WithTimeout(ctx, requestTimeout)
+		defer stop()
+
+		start := time.Now()
+		s.sendTyping(msg.ID, true)
+
+		answer, toolCalls, err := runToolLoop(ctx, s.username, fullPrompt, persona, func(token string) {
+			s.send(wsMessage{Type: "token", ID: msg.ID, Token: token})
+		})
+		response := answer.Text
+		elapsed := time.Since(start)
+		s.sendTyping(msg.ID, false)
+
+		switch {
+		case err == nil && answer.Degraded:
+			metrics.recordRequest("degraded", timeoutLabel)
+			metrics.recordModelAnswer(answer.Model)
+			reqLog.Warn("websocket prompt answered in degraded mode", "duration_ms", elapsed.Milliseconds())
+			s.send(wsMessage{Type: "done", ID: msg.ID, RequestID: requestID, Response: response, Model: answer.Model,
+				Degraded: true, TimeoutUsed: timeoutLabel, ProcessingTime: elapsed.String()})
+
+		case err == nil:
+			metrics.recordModelAnswer(answer.Model)
+			conversationID := memoryStore.addConversation(s.username, Conversation{
+				SessionID: userInput.SessionID,
+				Prompt:    userInput.Prompt,
+				Response:  response,
+				ToolCalls: toolCalls,
+				Model:     answer.Model,
+				Tier:      timeoutCategory(timeoutLabel),
+			})
+			metrics.recordRequest("success", timeoutLabel)
+			metrics.recordModelCall(elapsed, len(userInput.Prompt), len(fullPrompt), len(response))
+			latencyModel.record(answer.Model, len(userInput.Prompt), len(fullPrompt), elapsed, false)
+			reqLog.Info("websocket prompt completed", "duration_ms", elapsed.Milliseconds(), "tool_calls", len(toolCalls), "answered_by", answer.Model)
+			s.send(wsMessage{Type: "done", ID: msg.ID, RequestID: requestID, Response: response, Model: answer.Model,
+				ConversationID: conversationID, TimeoutUsed: timeoutLabel, ProcessingTime: elapsed.String(), Citations: citations,
+				Reminder: reminder, ToolCalls: toolCalls})
+
+		case ctx.Err() == context.DeadlineExceeded:
+			metrics.recordRequest("timeout", timeoutLabel)
+			latencyModel.record(model, len(userInput.Prompt), len(fullPrompt), requestTimeout, true)
+			reqLog.Warn("websocket prompt timed out", "duration_ms", elapsed.Milliseconds())
+			s.send(wsMessage{Type: "error", ID: msg.ID, RequestID: requestID, Error: "Request timeout",
+				TimeoutUsed: timeoutLabel, ProcessingTime: elapsed.String()})
+
+		case ctx.Err() == context.Canceled:
+			metrics.recordRequest("cancelled", timeoutLabel)
+			reqLog.Info("websocket prompt cancelled", "duration_ms", elapsed.Milliseconds())
+			s.send(wsMessage{Type: "cancelled", ID: msg.ID, RequestID: requestID, Response: response})
+
+		default:
+			metrics.recordRequest("error", timeoutLabel)
+			reqLog.Error("websocket prompt failed", "error", err.Error())
+			s.send(wsMessage{Type: "error", ID: msg.ID, RequestID: requestID, Error: "AI service temporarily unavailable"})
+		}
+	}()
+}
+
+func (ms *MemoryStore) recentConversations(username string, limit int) []Conversation {
+	ms.RLock()
+	defer ms.RUnlock()
+
+	user := ms.users[username]
+	if user == nil {
+		return nil
+	}
+
+	conversations := user.Conversations
+	if len(conversations) > limit {
+		conversations = conversations[len(conversations)-limit:]
+	}
+	result := make([]Conversation, len(conversations))
+	copy(result, conversations)
+	return result
+}
+
+func handleChatPage(w http.ResponseWriter, r *http.Request) {
+	http.ServeFile(w, r, "chat.html")
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The model is asked for JSON but often wraps it in a code fence, adds a
+// sentence before or after it, or stops halfway through. extractJSON finds
+// the JSON value in such a reply and repairs what it can.
+
+var codeFence = regexp.MustCompile("(?s)```[a-zA-Z0-9_-]*[ \t]*\\n?(.*?)(?:```|$)")
+
+// extractJSON returns the outermost JSON object or array in a model reply
+// and the list of repairs that were needed to parse it.
+func extractJSON(reply string) (interface{}, []string, error) {
+	var repairs []string
+	text := reply
+
+	for _, m := range codeFence.FindAllStringSubmatch(reply, -1) {
+		if strings.ContainsAny(m[1], "{[") {
+			text = m[1]
+			repairs = append(repairs, "removed code fence")
+			break
+		}
+	}
+
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return nil, repairs, fmt.Errorf("no JSON object or array found")
+	}
+	if strings.TrimSpace(text[:start]) != "" {
+		repairs = append(repairs, "removed leading text")
+	}
+	if end := matchingBracket(text, start); end >= 0 {
+		if strings.TrimSpace(text[end+1:]) != "" {
+			repairs = append(repairs, "removed trailing text")
+		}
+		text = text[start : end+1]
+	} else {
+		text = text[start:]
+	}
+
+	if !json.Valid([]byte(text)) {
+		var fixes []string
+		text, fixes = repairJSON(text)
+		repairs = append(repairs, fixes...)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, repairs, fmt.Errorf("invalid JSON after repair: %w", err)
+	}
+	return value, repairs, nil
+}
+
+// matchingBracket returns the index of the bracket closing the one at
+// start, or -1 if the text ends first. Brackets inside strings are ignored.
+func matchingBracket(text string, start int) int {
+	depth := 0
+	var quote byte
+	prev := byte('{') // last significant character outside strings
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		if quote != 0 {
+			switch c {
+			case '\\':
+				i++
+			case quote:
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			quote = c
+		case '\'':
+			// Only a quote where a value or key starts; otherwise it is an
+			// apostrophe in a bare word
+			if strings.IndexByte("{[,:", prev) >= 0 {
+				quote = c
+			}
+		case '{', '[':
+			depth++
+		case '}', ']':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			prev = c
+		}
+	}
+	return -1
+}
+
+// jsonToken is a lexical token of almost-JSON: one of the punctuation
+// characters {}[]:, or a string ('s') or bare word ('w').
+type jsonToken struct {
+	kind byte
+	text string // JSON-encoded string, or the raw word
+}
+
+// lexJSON splits almost-JSON into tokens, converting every string to a
+// valid JSON string. Comments are dropped.
+func lexJSON(text string, note func(string)) []jsonToken {
+	var tokens []jsonToken
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case strings.HasPrefix(text[i:], "//"):
+			end := strings.IndexByte(text[i:], '\n')
+			if end < 0 {
+				end = len(text) - i
+			}
+			i += end
+			note("removed comments")
+
+		case strings.HasPrefix(text[i:], "/*"):
+			end := strings.Index(text[i+2:], "*/")
+			if end < 0 {
+				i = len(text)
+			} else {
+				i += end + 4
+			}
+			note("removed comments")
+
+		case strings.IndexByte("{}[]:,", c) >= 0:
+			tokens = append(tokens, jsonToken{kind: c})
+			i++
+
+		case c == '"' || c == '\'' && quoteStarts(tokens):
+			j := i + 1
+			for j < len(text) && text[j] != c {
+				if text[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			closed := j < len(text)
+			content := text[i+1 : min(j, len(text))]
+			raw := text[i:min(j+1, len(text))]
+
+			switch {
+			case !closed:
+				note("closed an unterminated string")
+				raw = requote(content)
+			case c == '\'':
+				note("converted single-quoted strings")
+				raw = requote(content)
+			case !json.Valid([]byte(raw)):
+				note("escaped control characters in strings")
+				raw = requote(content)
+			}
+			tokens = append(tokens, jsonToken{kind: 's', text: raw})
+			i = j + 1
+
+		default:
+			j := i
+			for j < len(text) && !strings.ContainsRune(" \t\n\r{}[]:,\"", rune(text[j])) {
+				j++
+			}
+			tokens = append(tokens, jsonToken{kind: 'w', text: text[i:j]})
+			i = j
+		}
+	}
+	return tokens
+}
+
+// quoteStarts reports whether a single quote after tokens opens a string,
+// as matchingBracket decides: only where a key or value starts. Elsewhere
+// it is an apostrophe in a bare word.
+func quoteStarts(tokens []jsonToken) bool {
+	return len(tokens) == 0 || strings.IndexByte("{[,:", tokens[len(tokens)-1].kind) >= 0
+}
+
+// requote turns the content of a single-quoted, unterminated or otherwise
+// broken string into a valid JSON string.
+func requote(content string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '\\' && i+1 < len(content):
+			next := content[i+1]
+			switch {
+			case next == '\'':
+				sb.WriteByte('\'')
+			case next == 'u' && i+6 <= len(content) && isHex(content[i+2:i+6]):
+				sb.WriteString(content[i : i+6])
+				i += 4
+			case strings.IndexByte(`"\/bfnrt`, next) >= 0:
+				sb.WriteByte('\\')
+				sb.WriteByte(next)
+			default:
+				sb.WriteString(`\\`)
+				sb.WriteByte(next)
+			}
+			i++
+		case c == '\\':
+			sb.WriteString(`\\`)
+		case c == '"':
+			sb.WriteString(`\"`)
+		case c < 0x20:
+			fmt.Fprintf(&sb, `\u%04x`, c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func isHex(s string) bool {
+	_, err := strconv.ParseUint(s, 16, 16)
+	return err == nil
+}
+
+// bareValue converts a bare word in value position to JSON.
+func bareValue(word string, note func(string)) string {
+	switch word {
+	case "true", "false", "null":
+		return word
+	}
+	switch strings.ToLower(word) {
+	case "true", "false":
+		note("replaced non-JSON literal " + strconv.QuoteToASCII(word))
+		return strings.ToLower(word)
+	case "none", "null", "nil", "undefined", "nan", "infinity", "-infinity":
+		note("replaced non-JSON literal " + strconv.QuoteToASCII(word))
+		return "null"
+	}
+	if json.Valid([]byte(word)) {
+		return word
+	}
+	if f, err := strconv.ParseFloat(word, 64); err == nil {
+		note("normalised number " + strconv.QuoteToASCII(word))
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	note("quoted bare value " + strconv.QuoteToASCII(word))
+	return strconv.Quote(word)
+}
+
+// Parser states within an object or array.
+const (
+	expectKey = iota
+	expectColon
+	expectValue
+	afterValue
+)
+
+type jsonFrame struct {
+	kind  byte // '{', '[' or 0 for the top level
+	state int
+}
+
+// repairJSON rewrites almost-JSON into JSON: trailing and missing commas,
+// single quotes, unquoted keys, Python literals, comments, unbalanced
+// brackets and truncated output. It returns the new text and a
+// description of every kind of repair made.
+func repairJSON(text string) (string, []string) {
+	var repairs []string
+	note := func(repair string) {
+		if !contains(repairs, repair) {
+			repairs = append(repairs, repair)
+		}
+	}
+
+	tokens := lexJSON(text, note)
+	var out strings.Builder
+	stack := []jsonFrame{{state: expectValue}}
+	safe := 0 // output length at the last point where closing brackets yields valid JSON
+
+	top := func() *jsonFrame { return &stack[len(stack)-1] }
+	closer := func(kind byte) byte {
+		if kind == '{' {
+			return '}'
+		}
+		return ']'
+	}
+	// beginValue fixes up the container before a value is written.
+	beginValue := func() {
+		f := top()
+		switch {
+		case f.kind == '[' && f.state == afterValue:
+			note("inserted missing commas")
+			out.WriteByte(',')
+		case f.kind == '{' && f.state == expectColon:
+			note("inserted missing colons")
+			out.WriteByte(':')
+		}
+	}
+	endValue := func() {
+		top().state = afterValue
+		safe = out.Len()
+	}
+	writeKey := func(key string) {
+		f := top()
+		if f.state == afterValue {
+			note("inserted missing commas")
+			out.WriteByte(',')
+		}
+		out.WriteString(key)
+		f.state = expectColon
+	}
+
+	for i := 0; i < len(tokens) && !(len(stack) == 1 && top().state == afterValue); i++ {
+		t := tokens[i]
+		f := top()
+		switch t.kind {
+		case '{', '[':
+			beginValue()
+			out.WriteByte(t.kind)
+			stack = append(stack, jsonFrame{kind: t.kind, state: expectValue})
+			if t.kind == '{' {
+				top().state = expectKey
+			}
+			safe = out.Len()
+
+		case '}', ']':
+			match := -1
+			for j := len(stack) - 1; j > 0; j-- {
+				if closer(stack[j].kind) == t.kind {
+					match = j
+					break
+				}
+			}
+			if match < 0 {
+				note("removed unmatched closing brackets")
+				continue
+			}
+			if f.kind == '{' && (f.state == expectColon || f.state == expectValue) {
+				note("filled missing values with null")
+				if f.state == expectColon {
+					out.WriteByte(':')
+				}
+				out.WriteString("null")
+			}
+			if match < len(stack)-1 {
+				note("closed unbalanced brackets")
+			}
+			for len(stack)-1 >= match {
+				out.WriteByte(closer(top().kind))
+				stack = stack[:len(stack)-1]
+			}
+			endValue()
+
+		case ':':
+			if f.kind == '{' && f.state == expectColon {
+				out.WriteByte(':')
+				f.state = expectValue
+			} else {
+				note("removed stray colons")
+			}
+
+		case ',':
+			switch {
+			case f.state != afterValue:
+				note("removed extra commas")
+			case i+1 == len(tokens) || tokens[i+1].kind == '}' || tokens[i+1].kind == ']':
+				note("removed trailing commas")
+			default:
+				out.WriteByte(',')
+				f.state = expectValue
+				if f.kind == '{' {
+					f.state = expectKey
+				}
+			}
+
+		case 's':
+			if f.kind == '{' && (f.state == expectKey || f.state == afterValue) {
+				writeKey(t.text)
+				continue
+			}
+			beginValue()
+			out.WriteString(t.text)
+			endValue()
+
+		case 'w':
+			if f.kind == '{' && (f.state == expectKey || f.state == afterValue) {
+				note("quoted key " + strconv.QuoteToASCII(t.text))
+				writeKey(strconv.Quote(t.text))
+				continue
+			}
+			beginValue()
+			out.WriteString(bareValue(t.text, note))
+			endValue()
+		}
+	}
+
+	if len(stack) == 1 {
+		return out.String(), repairs
+	}
+
+	// The output stopped inside a value: drop the incomplete member and
+	// close what is still open
+	note("closed truncated output")
+	result := out.String()[:safe]
+	for len(stack) > 1 {
+		result += string(closer(top().kind))
+		stack = stack[:len(stack)-1]
+	}
+	return result, repairs
+}
+
+func contains(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  string // the extracted value, re-encoded
+	}{
+		{"plain", `{"name": "Rajan", "age": 30}`, `{"age":30,"name":"Rajan"}`},
+		{"fenced with text", "Here you go:\n```json\n{\"name\": \"Rajan\"}\n```\nHope it helps.", `{"name":"Rajan"}`},
+		{"leading and trailing text", `Sure! {"a": [1, 2]} Anything else?`, `{"a":[1,2]}`},
+		{"brace in string", `{"note": "use } carefully"} done`, `{"note":"use } carefully"}`},
+		{"array", `Result: [1, 2, 3]`, `[1,2,3]`},
+		{"trailing comma", `{"a": 1, "b": 2,}`, `{"a":1,"b":2}`},
+		{"single quotes and bare keys", `{name: 'Rajan', city: 'Pune'}`, `{"city":"Pune","name":"Rajan"}`},
+		{"python literals", `{"ok": True, "missing": None}`, `{"missing":null,"ok":true}`},
+		{"comments", "{\n  \"a\": 1, // first\n  /* second */ \"b\": 2\n}", `{"a":1,"b":2}`},
+		{"missing commas", "{\"a\": 1\n\"b\": [1 2]}", `{"a":1,"b":[1,2]}`},
+		{"truncated", `{"name": "Rajan", "skills": ["go", "sq`, `{"name":"Rajan","skills":["go","sq"]}`},
+		{"apostrophe in bare word", `{"a": it's}`, `{"a":"it's"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, repairs, err := extractJSON(tt.reply)
+			if err != nil {
+				t.Fatalf("error %v (repairs %v)", err, repairs)
+			}
+			got, _ := json.Marshal(value)
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s (repairs %v)", got, tt.want, repairs)
+			}
+		})
+	}
+}
+
+func TestExtractJSONReportsRepairs(t *testing.T) {
+	_, repairs, err := extractJSON(`{"a": 1}`)
+	if err != nil || len(repairs) != 0 {
+		t.Errorf("valid JSON: repairs %v, error %v", repairs, err)
+	}
+
+	_, repairs, err = extractJSON("```\n{'a': 1,}\n```")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"removed code fence", "converted single-quoted strings"} {
+		if !contains(repairs, want) {
+			t.Errorf("repairs %v lack %q", repairs, want)
+		}
+	}
+}
+
+func TestExtractJSONWithoutJSON(t *testing.T) {
+	if _, _, err := extractJSON("I do not know the answer."); err == nil {
+		t.Error("reply without JSON was accepted")
+	}
+}
+
+func TestRepairJSONOutputIsValid(t *testing.T) {
+	for _, text := range []string{
+		`{"a": [1, {"b": 'x'`,
+		`{a: 1 b: 2}`,
+		`[1, 2,, 3]`,
+		`{"a": "line` + "\n" + `break"}`,
+		`{"a": NaN, "b": 1.}`,
+	} {
+		fixed, repairs := repairJSON(text)
+		if !json.Valid([]byte(fixed)) {
+			t.Errorf("repairJSON(%q) = %q (repairs %v) is not valid JSON", text, fixed, repairs)
+		}
+	}
+}
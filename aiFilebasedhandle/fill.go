@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Fields of template.json that take user data hold a placeholder such as
+// "INSERT_FULL_NAME". Everything else (ids, configs, image paths) is
+// structure and is never sent to or taken from the model.
+var templatePlaceholder = regexp.MustCompile(`^INSERT_[A-Z0-9_]+$`)
+
+// templateField is a placeholder and where it sits in the template.
+type templateField struct {
+	Path        string
+	Placeholder string
+}
+
+// FieldChange is one field of the card that was filled in.
+type FieldChange struct {
+	Path   string `json:"path"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+type FillResult struct {
+	Card     interface{}   `json:"card"`
+	Changes  []FieldChange `json:"changes"`
+	Unfilled []string      `json:"unfilled,omitempty"` // placeholders the model had no value for
+	Repairs  []string      `json:"repairs,omitempty"`  // fixes needed to parse the model's reply
+}
+
+// loadTemplate reads template.txt. Numbers are kept as json.Number so they
+// are written back exactly as they were.
+func loadTemplate() (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(ReadFile("template")))
+	dec.UseNumber()
+	var template interface{}
+	if err := dec.Decode(&template); err != nil {
+		return nil, fmt.Errorf("template.txt is not valid JSON: %w", err)
+	}
+	return template, nil
+}
+
+// walkTemplate calls fn for every string in the template, in a stable
+// order. fn returns the new value for the string.
+func walkTemplate(node interface{}, path string, fn func(path, value string) string) interface{} {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			childPath := k
+			if path != "" {
+				childPath = path + "." + k
+			}
+			v[k] = walkTemplate(v[k], childPath, fn)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = walkTemplate(v[i], path+"["+strconv.Itoa(i)+"]", fn)
+		}
+	case string:
+		return fn(path, v)
+	}
+	return node
+}
+
+// templateFields lists the placeholders in the template.
+func templateFields(template interface{}) []templateField {
+	var fields []templateField
+	walkTemplate(template, "", func(path, value string) string {
+		if templatePlaceholder.MatchString(value) {
+			fields = append(fields, templateField{Path: path, Placeholder: value})
+		}
+		return value
+	})
+	return fields
+}
+
+// fieldPrompt asks the model for the placeholder values only.
+func fieldPrompt(userPrompt, data string, fields []templateField) string {
+	var sb strings.Builder
+	sb.WriteString(userPrompt)
+	sb.WriteString("\n\nUser data:\n")
+	sb.WriteString(data)
+	sb.WriteString("\n\nFill in the fields of a digital business card from the user data above. ")
+	sb.WriteString("Reply with only a JSON object that has exactly these keys, each with a string value. ")
+	sb.WriteString("Use \"\" when the data does not contain the value; do not invent contact details.\n")
+
+	seen := make(map[string]bool)
+	for _, f := range fields {
+		if !seen[f.Placeholder] {
+			seen[f.Placeholder] = true
+			sb.WriteString("- " + f.Placeholder + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// fieldValues normalises the model's reply into placeholder -> value. Keys
+// are matched case-insensitively, with or without the INSERT_ prefix.
+func fieldValues(reply interface{}) map[string]string {
+	values := make(map[string]string)
+	obj, ok := reply.(map[string]interface{})
+	if !ok {
+		return values
+	}
+	for k, v := range obj {
+		key := strings.ToUpper(strings.TrimSpace(k))
+		if !strings.HasPrefix(key, "INSERT_") {
+			key = "INSERT_" + key
+		}
+		switch v := v.(type) {
+		case string:
+			values[key] = strings.TrimSpace(v)
+		case json.Number, bool:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return values
+}
+
+// fillTemplate merges the values into the template's placeholder fields.
+// Fields without a value are emptied rather than left as placeholders.
+func fillTemplate(template interface{}, values map[string]string) (interface{}, []FieldChange, []string) {
+	changes := make([]FieldChange, 0)
+	var unfilled []string
+	card := walkTemplate(template, "", func(path, value string) string {
+		if !templatePlaceholder.MatchString(value) {
+			return value
+		}
+		filled := values[value]
+		if filled == "" || templatePlaceholder.MatchString(filled) {
+			if !contains(unfilled, value) {
+				unfilled = append(unfilled, value)
+			}
+			filled = ""
+		}
+		changes = append(changes, FieldChange{Path: path, Before: value, After: filled})
+		return filled
+	})
+	return card, changes, unfilled
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testTemplate = `{
+	"template_id": "b_685bb94064ea9b78d567cade",
+	"version": 12345678901234567890,
+	"scale": 1.50,
+	"content": [
+		{"component": "profile", "pr_img": "/images/profile_1.webp", "name": "INSERT_FULL_NAME", "desc": "INSERT_JOB_TITLE"},
+		{"component": "contact", "value": "INSERT_PHONE_NUMBER", "title_config": {"bold": 1, "size": 1e3, "color": "#000"}}
+	],
+	"footer": "INSERT_FULL_NAME"
+}`
+
+func decodeTemplate(t *testing.T) interface{} {
+	t.Helper()
+	dec := json.NewDecoder(strings.NewReader(testTemplate))
+	dec.UseNumber()
+	var template interface{}
+	if err := dec.Decode(&template); err != nil {
+		t.Fatal(err)
+	}
+	return template
+}
+
+func TestFillTemplate(t *testing.T) {
+	// Everything but the placeholders, as encoding/json writes it back
+	const structure = `"content":[{"component":"profile","desc":%s,"name":%s,"pr_img":"/images/profile_1.webp"},` +
+		`{"component":"contact","title_config":{"bold":1,"color":"#000","size":1e3},"value":%s}],` +
+		`"footer":%s,"scale":1.50,"template_id":"b_685bb94064ea9b78d567cade","version":12345678901234567890`
+
+	tests := []struct {
+		name                   string
+		values                 map[string]string
+		fullName, title, phone string
+		unfilled               []string
+	}{
+		{
+			name:     "all values",
+			values:   map[string]string{"INSERT_FULL_NAME": "Rajan", "INSERT_JOB_TITLE": "Engineer", "INSERT_PHONE_NUMBER": "+91 98765"},
+			fullName: "Rajan",
+			title:    "Engineer",
+			phone:    "+91 98765",
+			unfilled: nil,
+		},
+		{
+			name:     "missing and empty values",
+			values:   map[string]string{"INSERT_FULL_NAME": "Rajan", "INSERT_JOB_TITLE": ""},
+			fullName: "Rajan",
+			unfilled: []string{"INSERT_JOB_TITLE", "INSERT_PHONE_NUMBER"},
+		},
+		{
+			name:     "placeholder echoed back",
+			values:   map[string]string{"INSERT_FULL_NAME": "INSERT_FULL_NAME", "INSERT_JOB_TITLE": "Engineer", "INSERT_PHONE_NUMBER": "1"},
+			title:    "Engineer",
+			phone:    "1",
+			unfilled: []string{"INSERT_FULL_NAME"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			card, changes, unfilled := fillTemplate(decodeTemplate(t), tt.values)
+
+			got, err := json.Marshal(card)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q := func(s string) string { b, _ := json.Marshal(s); return string(b) }
+			want := "{" + fmt.Sprintf(structure, q(tt.title), q(tt.fullName), q(tt.phone), q(tt.fullName)) + "}"
+			if string(got) != want {
+				t.Errorf("card\n got %s\nwant %s", got, want)
+			}
+
+			if !reflect.DeepEqual(unfilled, tt.unfilled) {
+				t.Errorf("unfilled %q, want %q", unfilled, tt.unfilled)
+			}
+			if len(changes) != 4 {
+				t.Errorf("got %d changes, want one per placeholder field: %+v", len(changes), changes)
+			}
+		})
+	}
+}
+
+func TestFieldValues(t *testing.T) {
+	var reply interface{}
+	dec := json.NewDecoder(strings.NewReader(`{"full_name": " Rajan ", "INSERT_JOB_TITLE": "Engineer", "phone_number": 98765, "extra": {"a": 1}}`))
+	dec.UseNumber()
+	if err := dec.Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"INSERT_FULL_NAME": "Rajan", "INSERT_JOB_TITLE": "Engineer", "INSERT_PHONE_NUMBER": "98765"}
+	if got := fieldValues(reply); !reflect.DeepEqual(got, want) {
+		t.Errorf("fieldValues = %q, want %q", got, want)
+	}
+	if got := fieldValues([]interface{}{"a"}); len(got) != 0 {
+		t.Errorf("fieldValues of an array = %q", got)
+	}
+}
@@ -3,3 +3,7 @@ module ai
 go 1.24.3
 
 require golang.org/x/text v0.26.0
+
+require pdfreader v0.0.0
+
+replace pdfreader => ../pdfReader
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// Greeting styles.
+const (
+	styleFormal    = "formal"
+	styleCasual    = "casual"
+	styleTimeOfDay = "time_of_day"
+)
+
+const (
+	greetingCacheTTL  = 24 * time.Hour
+	greetingCacheSize = 1000
+)
+
+var styleRules = map[string]string{
+	styleFormal:    "Write a formal, respectful greeting. Address the person by their full name and refer to their current role.",
+	styleCasual:    "Write a friendly, casual greeting. Address the person by their first name and mention one thing from their background.",
+	styleTimeOfDay: "Write a warm greeting that starts with \"Good %s\". Address the person by their first name and mention their current work.",
+}
+
+// languageNames names the languages the model handles well; other valid
+// tags are passed to the model as they are.
+var languageNames = map[string]string{
+	"en": "English", "hi": "Hindi", "mr": "Marathi", "gu": "Gujarati", "bn": "Bengali",
+	"ta": "Tamil", "te": "Telugu", "es": "Spanish", "fr": "French", "de": "German",
+	"it": "Italian", "pt": "Portuguese", "ru": "Russian", "ja": "Japanese", "zh": "Chinese",
+	"ar": "Arabic",
+}
+
+type GreetingResponse struct {
+	User        string    `json:"user"`
+	Greeting    string    `json:"greeting"`
+	Style       string    `json:"style"`
+	Language    string    `json:"language"`
+	TimeOfDay   string    `json:"time_of_day,omitempty"`
+	DataHash    string    `json:"data_hash"`
+	Cached      bool      `json:"cached"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+type cachedGreeting struct {
+	greeting    string
+	generatedAt time.Time
+}
+
+// greetingCache remembers greetings by user data hash, style, language and
+// time of day, so a greeting is only regenerated when one of them changes.
+type greetingCache struct {
+	sync.Mutex
+	entries map[string]cachedGreeting
+}
+
+var greetings = &greetingCache{entries: make(map[string]cachedGreeting)}
+
+func (c *greetingCache) get(key string) (cachedGreeting, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Since(entry.generatedAt) > greetingCacheTTL {
+		return cachedGreeting{}, false
+	}
+	return entry, true
+}
+
+func (c *greetingCache) put(key string, entry cachedGreeting) {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.entries) >= greetingCacheSize {
+		oldestKey, oldest := "", time.Now()
+		for k, e := range c.entries {
+			if e.generatedAt.Before(oldest) {
+				oldestKey, oldest = k, e.generatedAt
+			}
+		}
+		delete(c.entries, oldestKey)
+	}
+	c.entries[key] = entry
+}
+
+// timeOfDay names the part of the day for the hour of t.
+func timeOfDay(t time.Time) string {
+	switch h := t.Hour(); {
+	case h >= 5 && h < 12:
+		return "morning"
+	case h >= 12 && h < 17:
+		return "afternoon"
+	}
+	return "evening"
+}
+
+// greetingPrompt asks for a short greeting that only uses the user's data.
+func greetingPrompt(data, style, lang, period string) string {
+	rule := styleRules[style]
+	if style == styleTimeOfDay {
+		rule = fmt.Sprintf(rule, period)
+	}
+	return "Here is information about a person:\n\n" + data + "\n\n" +
+		rule + "\n" +
+		"Rules: write in " + lang + ". Use at most two sentences and 40 words. " +
+		"Only use facts from the information above and never invent details. " +
+		"Do not add a signature, quotes, markdown or any explanation. Reply with the greeting only."
+}
+
+// cleanGreeting strips the wrapping models like to add.
+func cleanGreeting(reply string) string {
+	reply = strings.TrimSpace(reply)
+	if i := strings.Index(reply, ":\n"); i >= 0 && i < 40 {
+		reply = strings.TrimSpace(reply[i+2:]) // "Here is your greeting:\n..."
+	}
+	return strings.Trim(reply, "\"“”'` \n")
+}
+
+// userhandle greets a user from their data file:
+// POST /user {"user": "rajan", "style": "time_of_day", "language": "hi", "timezone": "Asia/Kolkata"}
+func userhandle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": Only POST method allowed}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var u User
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		http.Error(w, "user input didn't decoded", http.StatusBadRequest)
+		return
+	}
+	if u.User == "" {
+		http.Error(w, "user are empty", http.StatusBadRequest)
+		return
+	}
+	if !validUser(u.User) {
+		http.Error(w, "invalid user name", http.StatusBadRequest)
+		return
+	}
+
+	style := strings.ToLower(u.Style)
+	if style == "" {
+		style = styleCasual
+	}
+	if _, ok := styleRules[style]; !ok {
+		http.Error(w, "style must be formal, casual or time_of_day", http.StatusBadRequest)
+		return
+	}
+
+	tag := language.English
+	if u.Language != "" {
+		var err error
+		if tag, err = language.Parse(u.Language); err != nil {
+			http.Error(w, "invalid language tag", http.StatusBadRequest)
+			return
+		}
+	}
+	base, _ := tag.Base()
+	langName, ok := languageNames[base.String()]
+	if !ok {
+		langName = "the language with BCP 47 tag " + tag.String()
+	}
+
+	loc := time.Local
+	if u.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(u.Timezone); err != nil {
+			http.Error(w, "invalid timezone", http.StatusBadRequest)
+			return
+		}
+	}
+	var period string
+	if style == styleTimeOfDay {
+		period = timeOfDay(time.Now().In(loc))
+	}
+
+	data, err := userData(u.User)
+	if err != nil {
+		log.Printf("reading data of user %s: %v", u.User, err)
+		http.Error(w, "user pdf not readable", http.StatusInternalServerError)
+		return
+	}
+	if strings.TrimSpace(data) == "" {
+		http.Error(w, "no user data", http.StatusNotFound)
+		return
+	}
+
+	sum := sha256.Sum256([]byte(data))
+	dataHash := hex.EncodeToString(sum[:])
+	resp := GreetingResponse{
+		User:      u.User,
+		Style:     style,
+		Language:  tag.String(),
+		TimeOfDay: period,
+		DataHash:  dataHash,
+	}
+
+	key := strings.Join([]string{u.User, dataHash, style, tag.String(), period}, "|")
+	if entry, ok := greetings.get(key); ok {
+		resp.Greeting, resp.GeneratedAt, resp.Cached = entry.greeting, entry.generatedAt, true
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	reply, err := queryLLaMA(greetingPrompt(data, style, langName, period))
+	if err != nil {
+		http.Error(w, "lamma intenal server error", http.StatusInternalServerError)
+		return
+	}
+	entry := cachedGreeting{greeting: cleanGreeting(reply), generatedAt: time.Now()}
+	if entry.greeting == "" {
+		http.Error(w, "model returned an empty greeting", http.StatusBadGateway)
+		return
+	}
+	greetings.put(key, entry)
+
+	resp.Greeting, resp.GeneratedAt = entry.greeting, entry.generatedAt
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTimeOfDay(t *testing.T) {
+	tests := []struct {
+		hour int
+		want string
+	}{
+		{0, "evening"},
+		{4, "evening"},
+		{5, "morning"},
+		{11, "morning"},
+		{12, "afternoon"},
+		{16, "afternoon"},
+		{17, "evening"},
+		{23, "evening"},
+	}
+	for _, tt := range tests {
+		at := time.Date(2025, 3, 1, tt.hour, 30, 0, 0, time.UTC)
+		if got := timeOfDay(at); got != tt.want {
+			t.Errorf("timeOfDay(%02d:30) = %q, want %q", tt.hour, got, tt.want)
+		}
+	}
+
+	// The hour is read in the time's own zone
+	kolkata := time.FixedZone("IST", 5*3600+1800)
+	if got := timeOfDay(time.Date(2025, 3, 1, 4, 0, 0, 0, time.UTC).In(kolkata)); got != "morning" {
+		t.Errorf("04:00 UTC in Kolkata is %q, want morning", got)
+	}
+}
+
+func TestCleanGreeting(t *testing.T) {
+	tests := []struct{ reply, want string }{
+		{"Hello Rajan!", "Hello Rajan!"},
+		{"  \"Hello Rajan!\"\n", "Hello Rajan!"},
+		{"“Namaste Rajan”", "Namaste Rajan"},
+		{"Here is your greeting:\nGood morning, Rajan.", "Good morning, Rajan."},
+		// Only a short lead-in before ":\n" is taken for wrapping
+		{"Good morning Rajan, I hope the launch went well:\nall the best", "Good morning Rajan, I hope the launch went well:\nall the best"},
+		{"It's Rajan's day", "It's Rajan's day"},
+	}
+	for _, tt := range tests {
+		if got := cleanGreeting(tt.reply); got != tt.want {
+			t.Errorf("cleanGreeting(%q) = %q, want %q", tt.reply, got, tt.want)
+		}
+	}
+}
+
+func TestGreetingCache(t *testing.T) {
+	c := &greetingCache{entries: make(map[string]cachedGreeting)}
+
+	c.put("fresh", cachedGreeting{greeting: "hi", generatedAt: time.Now()})
+	c.put("stale", cachedGreeting{greeting: "old", generatedAt: time.Now().Add(-greetingCacheTTL - time.Minute)})
+	if entry, ok := c.get("fresh"); !ok || entry.greeting != "hi" {
+		t.Errorf("fresh entry: %+v, %v", entry, ok)
+	}
+	if _, ok := c.get("stale"); ok {
+		t.Error("entry older than the TTL was returned")
+	}
+	if _, ok := c.get("missing"); ok {
+		t.Error("missing entry was returned")
+	}
+
+	c = &greetingCache{entries: make(map[string]cachedGreeting)}
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < greetingCacheSize; i++ {
+		c.put(fmt.Sprint(i), cachedGreeting{greeting: "hi", generatedAt: start.Add(time.Duration(i) * time.Second)})
+	}
+	c.put("new", cachedGreeting{greeting: "hello", generatedAt: time.Now()})
+	if len(c.entries) != greetingCacheSize {
+		t.Errorf("cache holds %d entries, want %d", len(c.entries), greetingCacheSize)
+	}
+	if _, ok := c.get("0"); ok {
+		t.Error("oldest entry was not evicted")
+	}
+	if _, ok := c.get("1"); !ok {
+		t.Error("second oldest entry was evicted")
+	}
+	if _, ok := c.get("new"); !ok {
+		t.Error("new entry missing")
+	}
+}
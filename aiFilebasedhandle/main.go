@@ -7,7 +7,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+
+	"pdfreader/readpdf"
 )
 
 type OllamaRequest struct {
@@ -23,7 +27,10 @@ type userPrompt struct {
 	User   string
 }
 type User struct {
-	User string
+	User     string
+	Style    string // formal, casual or time_of_day; casual by default
+	Language string // BCP 47 tag such as "en" or "hi"; English by default
+	Timezone string // IANA zone for time_of_day, e.g. "Asia/Kolkata"
 }
 
 func ReadFile(filename string) string {
@@ -59,47 +66,63 @@ func WriteFile(filename string, data string) bool {
 	return true
 }
 
-// func ReadPDFAsString(filename string) (string, error) {
-// 	fmt.Println(filename)
-// 	f, err := os.Open(filename)
-// 	if err != nil {
-// 		return "", fmt.Errorf("error opening PDF file: %w", err)
-// 	}
-// 	defer f.Close()
-
-// 	r, err := pdf.NewReader(f, -1)
-// 	if err != nil {
-// 		return "", fmt.Errorf("error reading PDF: %w", err)
-// 	}
-
-// 	var content strings.Builder
-// 	numPages := r.NumPage()
-
-// 	for i := 1; i <= numPages; i++ {
-// 		page := r.Page(i)
-// 		if page.V.IsNull() {
-// 			continue
-// 		}
-
-// 		// Extract text from the page
-// 		var buf strings.Builder
-// 		pageContent := page.Content()
-// 		for _, text := range pageContent.Text {
-// 			buf.WriteString(text.S)
-// 			buf.WriteString(" ")
-// 		}
-
-// 		content.WriteString(buf.String())
-// 		content.WriteString("\n") // Add newline between pages
-// 	}
-
-// 	return content.String(), nil
-// }
+// userDir holds "<user>.txt" and "<user>.pdf". It is kept apart from the
+// server's own files so that a user named "template" reads nothing.
+const userDir = "users"
+
+// User names double as file names, so they are limited to characters
+// that cannot leave the data directory.
+var userNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+func validUser(user string) bool {
+	return userNamePattern.MatchString(user)
+}
+
+// userData returns what is known about a user: their .txt file followed
+// by the text of their PDF, if they have one. A PDF that cannot be read is
+// logged and skipped; it is only an error when there is no .txt to fall
+// back on.
+func userData(user string) (string, error) {
+	data := ReadFile(filepath.Join(userDir, user))
+	if path := userPDF(user); path != "" {
+		pages, err := readpdf.ReadPages(path)
+		if err != nil {
+			if strings.TrimSpace(data) == "" {
+				return "", err
+			}
+			log.Printf("skipping PDF of user %s: %v", user, err)
+			return data, nil
+		}
+		data += pdfText(pages)
+	}
+	return data, nil
+}
+
+// userPDF returns the path of the user's PDF, or "" if they have none.
+func userPDF(user string) string {
+	if !validUser(user) {
+		return ""
+	}
+	path := filepath.Join(userDir, user+".pdf")
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+		return path
+	}
+	return ""
+}
+
+// pdfText formats PDF pages for the prompt, keeping the page boundaries.
+func pdfText(pages []string) string {
+	var sb strings.Builder
+	for i, page := range pages {
+		fmt.Fprintf(&sb, "\n--- Page %d ---\n%s\n", i+1, page)
+	}
+	return sb.String()
+}
 
 func handle(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Contol-Allow-Origin", "*")
-	w.Header().Set("Access-Contol-Allow-Methos", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
 	if r.Method == http.MethodOptions {
@@ -122,8 +145,20 @@ func handle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "promp and user are empty", http.StatusBadRequest)
 		return
 	}
-	filename := userInput.User
-	data := ReadFile(filename)
+	if !validUser(userInput.User) {
+		http.Error(w, "invalid user name", http.StatusBadRequest)
+		return
+	}
+	data, err := userData(userInput.User)
+	if err != nil {
+		log.Printf("reading data of user %s: %v", userInput.User, err)
+		http.Error(w, "user pdf not readable", http.StatusInternalServerError)
+		return
+	}
+	if strings.TrimSpace(data) == "" {
+		http.Error(w, "no user data", http.StatusNotFound)
+		return
+	}
 
 	// if err != nil {
 	// 	fmt.Println(err)
@@ -134,36 +169,44 @@ func handle(w http.ResponseWriter, r *http.Request) {
 
 	// fmt.Println(data)
 
-	template := ReadFile("template")
-
-	// var jsonTemplate map[string]interface{}
-	// json.Unmarshal([]byte(template), &jsonTemplate)
-
-	// fmt.Println(jsonTemplate)
-	fullPrompt := userInput.Prompt + data + template
-
-	response, err := queryLLaMA(fullPrompt)
+	template, err := loadTemplate()
 	if err != nil {
-		http.Error(w, "ollama server error", http.StatusInternalServerError)
+		fmt.Println("Error:", err)
+		http.Error(w, "template not readable", http.StatusInternalServerError)
 		return
 	}
+	fields := templateFields(template)
 
-	fmt.Println(response)
-
-	clean := strings.TrimSpace(response)
-	clean = strings.TrimPrefix(clean, "```")
-	clean = strings.TrimSuffix(clean, "```")
-	clean = strings.TrimSpace(clean)
-
-	var result map[string]interface{}
-	err = json.Unmarshal([]byte(clean), &result)
-	if err != nil {
-		fmt.Println("Error:", err)
+	values := map[string]string{}
+	var repairs []string
+	if len(fields) > 0 {
+		response, err := queryLLaMA(fieldPrompt(userInput.Prompt, data, fields))
+		if err != nil {
+			http.Error(w, "ollama server error", http.StatusInternalServerError)
+			return
+		}
 
+		reply, fixes, err := extractJSON(response)
+		if err != nil {
+			// The reply is in the response body; the log only needs a hint
+			log.Printf("model reply is not JSON: %v (reply starts %.200q)", err, response)
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":    "model did not return the field values as JSON: " + err.Error(),
+				"response": response,
+			})
+			return
+		}
+		values, repairs = fieldValues(reply), fixes
 	}
-	fmt.Println(result["template_id"])
 
-	json.NewEncoder(w).Encode(response)
+	card, changes, unfilled := fillTemplate(template, values)
+	json.NewEncoder(w).Encode(FillResult{
+		Card:     card,
+		Changes:  changes,
+		Unfilled: unfilled,
+		Repairs:  repairs,
+	})
 
 }
 
@@ -196,53 +239,6 @@ func queryLLaMA(fullPrompt string) (string, error) {
 	return ollamaResp.Response, nil
 }
 
-func userhandle(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Contol-Allow-Origin", "*")
-	w.Header().Set("Access-Contol-Allow-Methos", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
-	if r.Method != http.MethodPost {
-		http.Error(w, `{"error": Only POST method allowed}`, http.StatusMethodNotAllowed)
-		return
-	}
-
-	var u User
-	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
-		http.Error(w, "user input didn't decoded", http.StatusBadRequest)
-		return
-	}
-	if u.User == "" {
-		http.Error(w, "user are empty", http.StatusBadRequest)
-		return
-	}
-	filename := u.User + ".txt"
-
-	data := ReadFile(filename)
-
-	if data != "" {
-		res, err := queryLLaMA(data + "this is the data of mine so its usefull so make only greating")
-
-		if err != nil {
-			http.Error(w, "lamma intenal server error", http.StatusInternalServerError)
-			return
-		} else {
-
-			json.NewEncoder(w).Encode(res)
-			return
-		}
-
-	}
-
-	http.Error(w, "no user data", http.StatusBadRequest)
-
-}
-
 func main() {
 
 	http.HandleFunc("/prompt", handle)
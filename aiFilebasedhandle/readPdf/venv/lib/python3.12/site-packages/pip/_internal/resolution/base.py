@@ -1,20 +0,0 @@
-from typing import Callable, List, Optional
-
-from pip._internal.req.req_install import InstallRequirement
-from pip._internal.req.req_set import RequirementSet
-
-InstallRequirementProvider = Callable[
-    [str, Optional[InstallRequirement]], InstallRequirement
-]
-
-
-class BaseResolver:
-    def resolve(
-        self, root_reqs: List[InstallRequirement], check_supported_wheels: bool
-    ) -> RequirementSet:
-        raise NotImplementedError()
-
-    def get_installation_order(
-        self, req_set: RequirementSet
-    ) -> List[InstallRequirement]:
-        raise NotImplementedError()
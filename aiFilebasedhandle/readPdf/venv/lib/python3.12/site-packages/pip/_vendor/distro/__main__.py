@@ -1,4 +0,0 @@
-from .distro import main
-
-if __name__ == "__main__":
-    main()
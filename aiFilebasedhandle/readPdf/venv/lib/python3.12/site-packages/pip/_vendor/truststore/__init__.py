@@ -1,13 +0,0 @@
-"""Verify certificates using native system trust stores"""
-
-import sys as _sys
-
-if _sys.version_info < (3, 10):
-    raise ImportError("truststore requires Python 3.10 or later")
-
-from ._api import SSLContext, extract_from_ssl, inject_into_ssl  # noqa: E402
-
-del _api, _sys  # type: ignore[name-defined] # noqa: F821
-
-__all__ = ["SSLContext", "inject_into_ssl", "extract_from_ssl"]
-__version__ = "0.8.0"
@@ -1,4 +0,0 @@
-from .serving import run_simple as run_simple
-from .test import Client as Client
-from .wrappers import Request as Request
-from .wrappers import Response as Response
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// compareModels are the models asked by /compare when the request does not
+// name any, e.g. COMPARE_MODELS="llama3,llama3.2:1b".
+var compareModels = parseModelList(getEnv("COMPARE_MODELS", "llama3,llama3.2:1b"))
+
+// pendingComparisonTTL is how long a comparison waits for the user to pick.
+const pendingComparisonTTL = time.Hour
+
+func parseModelList(value string) []string {
+	models := make([]string, 0)
+	for _, model := range strings.Split(value, ",") {
+		if model = strings.TrimSpace(model); model != "" && !contains(models, model) {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+type CompareRequest struct {
+	Prompt string   `json:"prompt"`
+	User   string   `json:"user"`
+	Models []string `json:"models,omitempty"`
+}
+
+type CompareAnswer struct {
+	Model            string `json:"model"`
+	Response         string `json:"response,omitempty"`
+	Error            string `json:"error,omitempty"`
+	LatencyMs        int64  `json:"latency_ms"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+type CompareResponse struct {
+	ComparisonID string          `json:"comparison_id,omitempty"`
+	Answers      []CompareAnswer `json:"answers,omitempty"`
+	Error        string          `json:"error,omitempty"`
+}
+
+type PickRequest struct {
+	ComparisonID string `json:"comparison_id"`
+	User         string `json:"user"`
+	Model        string `json:"model"`
+}
+
+type comparison struct {
+	user      string
+	prompt    string
+	answers   []CompareAnswer
+	createdAt time.Time
+	picking   bool // a pick is being saved
+}
+
+type modelRecord struct {
+	Comparisons    int   `json:"comparisons"` // decided comparisons the model answered in
+	Wins           int   `json:"wins"`
+	Errors         int   `json:"errors"`
+	totalLatencyMs int64 // over successful answers
+	answered       int
+}
+
+func (r *modelRecord) add(o modelRecord) {
+	r.Comparisons += o.Comparisons
+	r.Wins += o.Wins
+	r.Errors += o.Errors
+	r.totalLatencyMs += o.totalLatencyMs
+	r.answered += o.answered
+}
+
+type ModelStats struct {
+	Model string `json:"model"`
+	modelRecord
+	WinRate      float64 `json:"win_rate"`
+	AvgLatencyMs int64   `json:"avg_latency_ms"`
+}
+
+// CompareStore keeps comparisons awaiting a pick in memory; the per-model
+// record is kept in the repository.
+type CompareStore struct {
+	sync.Mutex
+	repo    Repository
+	pending map[string]*comparison
+}
+
+func NewCompareStore(repo Repository) *CompareStore {
+	return &CompareStore{
+		repo:    repo,
+		pending: make(map[string]*comparison),
+	}
+}
+
+var compareStore = NewCompareStore(memoryStore.repo)
+
+// add stores a comparison until the user picks a winner, dropping any that
+// were never decided, and counts each model's answer or error. The
+// comparison is kept even if the record cannot be updated.
+func (cs *CompareStore) add(ctx context.Context, c *comparison) (string, error) {
+	cs.Lock()
+	for id, old := range cs.pending {
+		if time.Since(old.createdAt) > pendingComparisonTTL {
+			delete(cs.pending, id)
+		}
+	}
+
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	id := "cmp_" + hex.EncodeToString(buf)
+	cs.pending[id] = c
+	cs.Unlock()
+
+	for _, a := range c.answers {
+		delta := modelRecord{Errors: 1}
+		if a.Error == "" {
+			delta = modelRecord{answered: 1, totalLatencyMs: a.LatencyMs}
+		}
+		if err := cs.repo.updateModelRecord(ctx, a.Model, delta); err != nil {
+			return id, err
+		}
+	}
+	return id, nil
+}
+
+// pick looks up the chosen answer of a pending comparison and holds the
+// comparison until commit or release, so two picks cannot both be saved.
+// The comparison stays pending until the winner has been stored.
+func (cs *CompareStore) pick(id, user, model string) (*comparison, CompareAnswer, string) {
+	cs.Lock()
+	defer cs.Unlock()
+
+	c, ok := cs.pending[id]
+	if !ok || c.user != user || c.picking {
+		return nil, CompareAnswer{}, "Comparison not found or already decided"
+	}
+
+	var winner *CompareAnswer
+	for i := range c.answers {
+		if c.answers[i].Model == model && c.answers[i].Error == "" {
+			winner = &c.answers[i]
+		}
+	}
+	if winner == nil {
+		return nil, CompareAnswer{}, "Model did not answer in this comparison"
+	}
+
+	c.picking = true
+	return c, *winner, ""
+}
+
+// commit removes a picked comparison once its winner is stored.
+func (cs *CompareStore) commit(id string) {
+	cs.Lock()
+	defer cs.Unlock()
+	delete(cs.pending, id)
+}
+
+// release makes a picked comparison available again after the winner
+// could not be stored, so the user can retry.
+func (cs *CompareStore) release(id string) {
+	cs.Lock()
+	defer cs.Unlock()
+	if c, ok := cs.pending[id]; ok {
+		c.picking = false
+	}
+}
+
+// settle records the outcome of a picked comparison.
+func (cs *CompareStore) settle(ctx context.Context, c *comparison, winner string) error {
+	for _, a := range c.answers {
+		if a.Error != "" {
+			continue
+		}
+		delta := modelRecord{Comparisons: 1}
+		if a.Model == winner {
+			delta.Wins = 1
+		}
+		if err := cs.repo.updateModelRecord(ctx, a.Model, delta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cs *CompareStore) stats(ctx context.Context) ([]ModelStats, error) {
+	records, err := cs.repo.modelRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ModelStats, 0, len(records))
+	for model, r := range records {
+		s := ModelStats{Model: model, modelRecord: r}
+		if r.Comparisons > 0 {
+			s.WinRate = float64(r.Wins) / float64(r.Comparisons)
+		}
+		if r.answered > 0 {
+			s.AvgLatencyMs = r.totalLatencyMs / int64(r.answered)
+		}
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].WinRate != result[j].WinRate {
+			return result[i].WinRate > result[j].WinRate
+		}
+		return result[i].Model < result[j].Model
+	})
+	return result, nil
+}
+
+// handleCompare sends the same context to several models in parallel and
+// returns every answer. Nothing is remembered until the user picks one.
+func handleCompare(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error":"Only POST method allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CompareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		json.NewEncoder(w).Encode(CompareResponse{Error: "Invalid JSON format"})
+		return
+	}
+	if req.Prompt == "" || req.User == "" {
+		json.NewEncoder(w).Encode(CompareResponse{Error: "Prompt and user fields are required"})
+		return
+	}
+
+	// Requests may narrow the configured models, never add to them
+	models := compareModels
+	if len(req.Models) > 0 {
+		models = parseModelList(strings.Join(req.Models, ","))
+		for _, model := range models {
+			if !contains(compareModels, model) {
+				json.NewEncoder(w).Encode(CompareResponse{Error: fmt.Sprintf("Unknown model %q (available: %s)", model, strings.Join(compareModels, ", "))})
+				return
+			}
+		}
+	}
+	if len(models) < 2 || len(models) > 5 {
+		json.NewEncoder(w).Encode(CompareResponse{Error: "Compare needs between 2 and 5 models"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 25*time.Second)
+	defer cancel()
+
+	if err := memoryStore.getOrCreateUser(ctx, req.User); err != nil {
+		log.Printf("Error loading user %s: %v", req.User, err)
+		json.NewEncoder(w).Encode(CompareResponse{Error: "Failed to load user memory"})
+		return
+	}
+	fullPrompt, err := memoryStore.buildContext(ctx, req.User, req.Prompt)
+	if err != nil {
+		log.Printf("Error building context for user %s: %v", req.User, err)
+		json.NewEncoder(w).Encode(CompareResponse{Error: "Failed to load user memory"})
+		return
+	}
+
+	// Comparing is never downgraded, the point is to ask the chosen models
+	release, period, err := usageStore.reserve(ctx, req.User, estimateTokens(fullPrompt)*len(models))
+	if err != nil {
+		log.Printf("Error checking budget for user %s: %v", req.User, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(CompareResponse{Error: "Failed to check token budget"})
+		return
+	}
+	if period != "" {
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(CompareResponse{Error: period + " token budget exceeded"})
+		return
+	}
+	defer release()
+
+	answers := make([]CompareAnswer, len(models))
+	var wg sync.WaitGroup
+	for i, model := range models {
+		wg.Add(1)
+		go func(i int, model string) {
+			defer wg.Done()
+			start := time.Now()
+			answer, err := queryModel(ctx, model, fullPrompt)
+			answers[i] = CompareAnswer{
+				Model:            model,
+				Response:         answer.Text,
+				LatencyMs:        time.Since(start).Milliseconds(),
+				PromptTokens:     answer.PromptTokens,
+				CompletionTokens: answer.CompletionTokens,
+			}
+			if err != nil {
+				log.Printf("Error querying %s for user %s: %v", model, req.User, err)
+				answers[i].Error = "Failed to get response from AI model"
+				return
+			}
+			if err := usageStore.record(ctx, req.User, model, answerUsage(answer, time.Since(start))); err != nil {
+				log.Printf("Error recording usage for user %s: %v", req.User, err)
+			}
+		}(i, model)
+	}
+	wg.Wait()
+
+	id, err := compareStore.add(ctx, &comparison{user: req.User, prompt: req.Prompt, answers: answers, createdAt: time.Now()})
+	if err != nil {
+		log.Printf("Error recording comparison for user %s: %v", req.User, err)
+	}
+	log.Printf("User %s compared %d models: %s", req.User, len(models), req.Prompt)
+
+	json.NewEncoder(w).Encode(CompareResponse{ComparisonID: id, Answers: answers})
+}
+
+// handleComparePick records the user's preferred answer and stores it as
+// the canonical turn in their history.
+func handleComparePick(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error":"Only POST method allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PickRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON format"})
+		return
+	}
+	if req.ComparisonID == "" || req.User == "" || req.Model == "" {
+		json.NewEncoder(w).Encode(map[string]string{"error": "comparison_id, user and model fields are required"})
+		return
+	}
+
+	c, winner, errMsg := compareStore.pick(req.ComparisonID, req.User, req.Model)
+	if errMsg != "" {
+		json.NewEncoder(w).Encode(map[string]string{"error": errMsg})
+		return
+	}
+
+	if err := memoryStore.addConversation(r.Context(), Conversation{
+		User:     c.user,
+		Prompt:   c.prompt,
+		Response: winner.Response,
+		Model:    winner.Model,
+	}); err != nil {
+		log.Printf("Error saving conversation for user %s: %v", c.user, err)
+		compareStore.release(req.ComparisonID)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save the picked answer"})
+		return
+	}
+
+	compareStore.commit(req.ComparisonID)
+
+	if err := compareStore.settle(r.Context(), c, winner.Model); err != nil {
+		log.Printf("Error recording pick for user %s: %v", c.user, err)
+	}
+	stats, err := compareStore.stats(r.Context())
+	if err != nil {
+		log.Printf("Error loading compare stats: %v", err)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"model":    winner.Model,
+		"response": winner.Response,
+		"stats":    stats,
+	})
+}
+
+// handleCompareStats reports how often each model wins when compared.
+func handleCompareStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"Only GET method allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	stats, err := compareStore.stats(r.Context())
+	if err != nil {
+		log.Printf("Error loading compare stats: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load compare stats"})
+		return
+	}
+	json.NewEncoder(w).Encode(stats)
+}
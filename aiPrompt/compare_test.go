@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestComparePickCommitAndRelease(t *testing.T) {
+	cs := NewCompareStore(newMemoryRepository())
+	id, err := cs.add(context.Background(), &comparison{
+		user:      "alice",
+		prompt:    "hi",
+		answers:   []CompareAnswer{{Model: "llama3", Response: "hello"}, {Model: "mistral", Error: "timeout"}},
+		createdAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, msg := cs.pick(id, "bob", "llama3"); msg == "" {
+		t.Error("another user picked the comparison")
+	}
+	if _, _, msg := cs.pick(id, "alice", "mistral"); msg == "" {
+		t.Error("picked a model that failed to answer")
+	}
+
+	_, winner, msg := cs.pick(id, "alice", "llama3")
+	if msg != "" || winner.Response != "hello" {
+		t.Fatalf("pick: %+v, %q", winner, msg)
+	}
+	if _, _, msg := cs.pick(id, "alice", "llama3"); msg == "" {
+		t.Error("comparison picked twice while the first pick was being saved")
+	}
+
+	// Saving failed: the user can pick again
+	cs.release(id)
+	if _, _, msg := cs.pick(id, "alice", "llama3"); msg != "" {
+		t.Fatalf("pick after release: %q", msg)
+	}
+	cs.commit(id)
+	if _, _, msg := cs.pick(id, "alice", "llama3"); msg == "" {
+		t.Error("committed comparison picked again")
+	}
+}
@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
 	"strings"
-	"sync"
 	"time"
+
+	"aiPrompt/config"
 )
 
 // Request/Response structures
@@ -21,17 +24,39 @@ type OllamaRequest struct {
 }
 
 type OllamaResponse struct {
-	Response string `json:"response"`
+	Response        string `json:"response"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	TotalDuration   int64  `json:"total_duration"` // nanoseconds
+}
+
+// ModelAnswer is a model's reply with the token counts and processing time
+// Ollama reports.
+type ModelAnswer struct {
+	Text             string
+	PromptTokens     int
+	CompletionTokens int
+	Duration         time.Duration
 }
 
 type UserPrompt struct {
 	Prompt string `json:"prompt"`
 	User   string `json:"user"`
+
+	// Render the prompt from a template instead; version 0 means the latest
+	TemplateID      string                     `json:"template_id,omitempty"`
+	TemplateVersion int                        `json:"template_version,omitempty"`
+	Variables       map[string]json.RawMessage `json:"variables,omitempty"`
 }
 
 type ModelResponse struct {
-	Response string `json:"response"`
-	Error    string `json:"error,omitempty"`
+	Response        string `json:"response"`
+	Model           string `json:"model,omitempty"`
+	Downgraded      bool   `json:"downgraded,omitempty"` // served by the budget downgrade model
+	Usage           *Usage `json:"usage,omitempty"`
+	TemplateID      string `json:"template_id,omitempty"`
+	TemplateVersion int    `json:"template_version,omitempty"`
+	Error           string `json:"error,omitempty"`
 }
 
 // Enhanced memory structures
@@ -40,7 +65,12 @@ type Conversation struct {
 	User      string    `json:"user"`
 	Prompt    string    `json:"prompt"`
 	Response  string    `json:"response"`
+	Model     string    `json:"model,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
+
+	// Set when the prompt was rendered from a template
+	TemplateID      string `json:"template_id,omitempty"`
+	TemplateVersion int    `json:"template_version,omitempty"`
 }
 
 type UserProfile struct {
@@ -51,18 +81,20 @@ type UserProfile struct {
 	LastSeen      time.Time         `json:"last_seen"`
 }
 
-// Thread-safe memory store
+// MemoryStore builds prompt context from what a Repository remembers
+// about each user.
 type MemoryStore struct {
-	sync.RWMutex
-	users      map[string]*UserProfile
+	repo       Repository
 	maxHistory int
+	maxFacts   int
 	httpClient *http.Client
 }
 
-func NewMemoryStore(maxHistory int) *MemoryStore {
+func NewMemoryStore(repo Repository, maxHistory int) *MemoryStore {
 	return &MemoryStore{
-		users:      make(map[string]*UserProfile),
+		repo:       repo,
 		maxHistory: maxHistory,
+		maxFacts:   20,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
@@ -70,100 +102,64 @@ func NewMemoryStore(maxHistory int) *MemoryStore {
 }
 
 // Get or create user profile
-func (ms *MemoryStore) getOrCreateUser(username string) *UserProfile {
-	ms.Lock()
-	defer ms.Unlock()
-
-	if user, exists := ms.users[username]; exists {
-		user.LastSeen = time.Now()
-		return user
-	}
-
-	user := &UserProfile{
-		Username:      username,
-		Conversations: make([]Conversation, 0),
-		PersonalFacts: make([]string, 0),
-		Preferences:   make(map[string]string),
-		LastSeen:      time.Now(),
-	}
-	ms.users[username] = user
-	return user
+func (ms *MemoryStore) getOrCreateUser(ctx context.Context, username string) error {
+	return ms.repo.touchUser(ctx, username)
 }
 
-// Add conversation to user's history
-func (ms *MemoryStore) addConversation(username, prompt, response string) {
-	ms.Lock()
-	defer ms.Unlock()
-
-	user := ms.users[username]
-	if user == nil {
-		return
-	}
-
-	conversation := Conversation{
-		ID:        fmt.Sprintf("%s_%d", username, time.Now().Unix()),
-		User:      username,
-		Prompt:    prompt,
-		Response:  response,
-		Timestamp: time.Now(),
-	}
-
-	user.Conversations = append(user.Conversations, conversation)
+// Add conversation to user's history. The ID and timestamp are filled in.
+func (ms *MemoryStore) addConversation(ctx context.Context, conversation Conversation) error {
+	username := conversation.User
+	conversation.ID = fmt.Sprintf("%s_%d", username, time.Now().UnixNano())
+	conversation.Timestamp = time.Now()
 
-	// Maintain conversation history limit
-	if len(user.Conversations) > ms.maxHistory {
-		user.Conversations = user.Conversations[len(user.Conversations)-ms.maxHistory:]
+	if err := ms.repo.addConversation(ctx, conversation, ms.maxHistory); err != nil {
+		return err
 	}
 
 	// Extract and store personal information
-	ms.extractPersonalInfo(user, prompt, response)
+	for _, fact := range extractPersonalInfo(conversation.Prompt) {
+		if err := ms.repo.addFact(ctx, username, fact, ms.maxFacts); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Extract personal information from conversations
-func (ms *MemoryStore) extractPersonalInfo(user *UserProfile, prompt, _ string) {
+func extractPersonalInfo(prompt string) []string {
 	// Simple keyword-based extraction (can be enhanced with NLP)
 	personalKeywords := []string{"my name is", "i am", "i like", "i work", "i live", "my job", "my hobby"}
 
 	lowerPrompt := strings.ToLower(prompt)
 	for _, keyword := range personalKeywords {
 		if strings.Contains(lowerPrompt, keyword) {
-			fact := strings.TrimSpace(prompt)
-			if !contains(user.PersonalFacts, fact) {
-				user.PersonalFacts = append(user.PersonalFacts, fact)
-			}
+			return []string{strings.TrimSpace(prompt)}
 		}
 	}
-
-	if len(user.PersonalFacts) > 20 {
-		user.PersonalFacts = user.PersonalFacts[len(user.PersonalFacts)-20:]
-	}
+	return nil
 }
 
 // Build context for LLM
-func (ms *MemoryStore) buildContext(username, currentPrompt string) string {
-	ms.RLock()
-	defer ms.RUnlock()
-
-	user := ms.users[username]
-	if user == nil {
-		return currentPrompt
+func (ms *MemoryStore) buildContext(ctx context.Context, username, currentPrompt string) (string, error) {
+	facts, err := ms.repo.facts(ctx, username)
+	if err != nil {
+		return "", err
+	}
+	recentConversations, err := ms.repo.recentConversations(ctx, username, 5)
+	if err != nil {
+		return "", err
 	}
 
 	var contextBuilder strings.Builder
 
-	if len(user.PersonalFacts) > 0 {
+	if len(facts) > 0 {
 		contextBuilder.WriteString("Personal Information about " + username + ":\n")
-		for _, fact := range user.PersonalFacts {
+		for _, fact := range facts {
 			contextBuilder.WriteString("- " + fact + "\n")
 		}
 		contextBuilder.WriteString("\n")
 	}
 
-	recentConversations := user.Conversations
-	if len(recentConversations) > 5 {
-		recentConversations = recentConversations[len(recentConversations)-5:]
-	}
-
 	if len(recentConversations) > 0 {
 		contextBuilder.WriteString("Recent Conversation History:\n")
 		for _, conv := range recentConversations {
@@ -173,25 +169,11 @@ func (ms *MemoryStore) buildContext(username, currentPrompt string) string {
 
 	contextBuilder.WriteString("Current Question: " + currentPrompt)
 
-	return contextBuilder.String()
+	return contextBuilder.String(), nil
 }
 
-func (ms *MemoryStore) getUserStats(username string) map[string]interface{} {
-	ms.RLock()
-	defer ms.RUnlock()
-
-	user := ms.users[username]
-	if user == nil {
-		return nil
-	}
-
-	return map[string]interface{}{
-		"username":            user.Username,
-		"total_conversations": len(user.Conversations),
-		"personal_facts":      len(user.PersonalFacts),
-		"last_seen":           user.LastSeen,
-		"preferences":         user.Preferences,
-	}
+func (ms *MemoryStore) getUserStats(ctx context.Context, username string) (*UserStats, error) {
+	return ms.repo.userStats(ctx, username)
 }
 
 // Helper function
@@ -204,47 +186,62 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
-var memoryStore = NewMemoryStore(50)
+var memoryStore = NewMemoryStore(newMemoryRepository(), 50)
 
-func queryLLaMA(ctx context.Context, fullPrompt string) (string, error) {
-	url := "http://localhost:11434/api/generate"
+var (
+	ollamaURL    = getEnv("OLLAMA_URL", "http://localhost:11434/api/generate")
+	defaultModel = getEnv("MODEL_NAME", "llama3")
+)
 
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func queryModel(ctx context.Context, model, fullPrompt string) (ModelAnswer, error) {
 	requestBody, err := json.Marshal(OllamaRequest{
-		Model:  "llama3",
+		Model:  model,
 		Prompt: fullPrompt,
 		Stream: false,
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return ModelAnswer{}, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", ollamaURL, bytes.NewBuffer(requestBody))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return ModelAnswer{}, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := memoryStore.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("request failed: %w", err)
+		return ModelAnswer{}, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("LLaMA API returned status %d", resp.StatusCode)
+		return ModelAnswer{}, fmt.Errorf("LLaMA API returned status %d", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return ModelAnswer{}, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	var result OllamaResponse
 	if err := json.Unmarshal(body, &result); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+		return ModelAnswer{}, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
-	return result.Response, nil
+	return ModelAnswer{
+		Text:             result.Response,
+		PromptTokens:     result.PromptEvalCount,
+		CompletionTokens: result.EvalCount,
+		Duration:         time.Duration(result.TotalDuration),
+	}, nil
 }
 
 func handlePrompt(w http.ResponseWriter, r *http.Request) {
@@ -261,31 +258,98 @@ func handlePrompt(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var template PromptTemplate
+	if userInput.TemplateID != "" {
+		if userInput.Prompt != "" {
+			json.NewEncoder(w).Encode(ModelResponse{Error: "Send either template_id or prompt, not both"})
+			return
+		}
+		var err error
+		if template, err = templateStore.get(r.Context(), userInput.TemplateID, userInput.TemplateVersion); err != nil {
+			msg := err.Error()
+			if !errors.Is(err, errTemplateNotFound) {
+				log.Printf("Error loading template %s: %v", userInput.TemplateID, err)
+				msg = "Failed to load template"
+			}
+			json.NewEncoder(w).Encode(ModelResponse{Error: msg})
+			return
+		}
+		if userInput.Prompt, err = template.render(userInput.Variables); err != nil {
+			json.NewEncoder(w).Encode(ModelResponse{Error: "Invalid template variables: " + err.Error()})
+			return
+		}
+	}
+
 	if userInput.Prompt == "" || userInput.User == "" {
 		json.NewEncoder(w).Encode(ModelResponse{Error: "Prompt and user fields are required"})
 		return
 	}
 
-	memoryStore.getOrCreateUser(userInput.User)
-
-	fullPrompt := memoryStore.buildContext(userInput.User, userInput.Prompt)
-
 	ctx, cancel := context.WithTimeout(r.Context(), 25*time.Second)
 	defer cancel()
 
-	response, err := queryLLaMA(ctx, fullPrompt)
+	if err := memoryStore.getOrCreateUser(ctx, userInput.User); err != nil {
+		log.Printf("Error loading user %s: %v", userInput.User, err)
+		json.NewEncoder(w).Encode(ModelResponse{Error: "Failed to load user memory"})
+		return
+	}
+
+	fullPrompt, err := memoryStore.buildContext(ctx, userInput.User, userInput.Prompt)
+	if err != nil {
+		log.Printf("Error building context for user %s: %v", userInput.User, err)
+		json.NewEncoder(w).Encode(ModelResponse{Error: "Failed to load user memory"})
+		return
+	}
+
+	model, downgraded, errMsg, release, err := usageStore.checkBudget(ctx, userInput.User, defaultModel, estimateTokens(fullPrompt))
+	if err != nil {
+		log.Printf("Error checking budget for user %s: %v", userInput.User, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ModelResponse{Error: "Failed to check token budget"})
+		return
+	}
+	defer release()
+	if errMsg != "" {
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(ModelResponse{Error: errMsg})
+		return
+	}
+
+	start := time.Now()
+	answer, err := queryModel(ctx, model, fullPrompt)
 	if err != nil {
-		log.Printf("Error querying LLaMA for user %s: %v", userInput.User, err)
+		log.Printf("Error querying %s for user %s: %v", model, userInput.User, err)
 		json.NewEncoder(w).Encode(ModelResponse{Error: "Failed to get response from AI model"})
 		return
 	}
+	response := answer.Text
+	usage := answerUsage(answer, time.Since(start))
+	if err := usageStore.record(ctx, userInput.User, model, usage); err != nil {
+		log.Printf("Error recording usage for user %s: %v", userInput.User, err)
+	}
 
-	memoryStore.addConversation(userInput.User, userInput.Prompt, response)
+	if err := memoryStore.addConversation(ctx, Conversation{
+		User:            userInput.User,
+		Prompt:          userInput.Prompt,
+		Response:        response,
+		Model:           model,
+		TemplateID:      template.ID,
+		TemplateVersion: template.Version,
+	}); err != nil {
+		log.Printf("Error saving conversation for user %s: %v", userInput.User, err)
+	}
 
 	log.Printf("User %s: %s", userInput.User, userInput.Prompt)
 	log.Printf("Response: %s", response)
 
-	json.NewEncoder(w).Encode(ModelResponse{Response: response})
+	json.NewEncoder(w).Encode(ModelResponse{
+		Response:        response,
+		Model:           model,
+		Downgraded:      downgraded,
+		Usage:           &usage,
+		TemplateID:      template.ID,
+		TemplateVersion: template.Version,
+	})
 }
 
 func handleUserProfile(w http.ResponseWriter, r *http.Request) {
@@ -302,7 +366,12 @@ func handleUserProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	stats := memoryStore.getUserStats(username)
+	stats, err := memoryStore.getUserStats(r.Context(), username)
+	if err != nil {
+		log.Printf("Error loading profile for user %s: %v", username, err)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load user profile"})
+		return
+	}
 	if stats == nil {
 		json.NewEncoder(w).Encode(map[string]string{"error": "User not found"})
 		return
@@ -311,9 +380,74 @@ func handleUserProfile(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(stats)
 }
 
+// newRepository picks the memory backend: MongoDB when MEMORY_BACKEND is
+// "mongo", otherwise process memory.
+func newRepository() Repository {
+	if os.Getenv("MEMORY_BACKEND") != "mongo" {
+		log.Println("Using in-memory store")
+		return newMemoryRepository()
+	}
+
+	dbName := os.Getenv("MONGO_DB")
+	if dbName == "" {
+		dbName = "aiprompt"
+	}
+	conversationTTL := envDuration("CONVERSATION_TTL", 30*24*time.Hour)
+	userTTL := envDuration("USER_TTL", 90*24*time.Hour)
+
+	client := config.ConnectDB()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	repo, err := newMongoRepository(ctx, client.Database(dbName), conversationTTL, userTTL)
+	if err != nil {
+		log.Fatalf("MongoDB setup error: %v", err)
+	}
+	log.Printf("Using MongoDB store (database %s, conversation TTL %s, user TTL %s)", dbName, conversationTTL, userTTL)
+	return repo
+}
+
+// envDuration reads a duration such as "720h"; "0" disables expiry.
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	if value == "0" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %v", key, value, err)
+	}
+	return d
+}
+
 func main() {
+	if budget.Action != budgetReject && budget.Action != budgetDowngrade {
+		log.Fatalf("Invalid BUDGET_ACTION %q: must be %s or %s", budget.Action, budgetReject, budgetDowngrade)
+	}
+	repo := newRepository()
+	memoryStore = NewMemoryStore(repo, 50)
+	usageStore = NewUsageStore(repo)
+	templateStore = NewTemplateStore(repo)
+	compareStore = NewCompareStore(repo)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := templateStore.saveBuiltins(ctx); err != nil {
+		log.Fatalf("Template setup error: %v", err)
+	}
+	cancel()
+
 	http.HandleFunc("/prompt", handlePrompt)
 	http.HandleFunc("/profile", handleUserProfile)
+	http.HandleFunc("/search", handleSearch)
+	http.HandleFunc("/compare", handleCompare)
+	http.HandleFunc("/compare/pick", handleComparePick)
+	http.HandleFunc("/compare/stats", handleCompareStats)
+	http.HandleFunc("/templates", handleTemplates)
+	http.HandleFunc("/usage", handleUsage)
+	http.HandleFunc("/usage/report", handleUsageReport)
 
 	server := &http.Server{
 		Addr:         ":8080",
@@ -0,0 +1,636 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoRepository stores users, conversations and facts in three
+// collections. TTL indexes let MongoDB expire old conversations and users
+// that have not been seen for a while, together with their facts. Token
+// usage is kept in a fourth collection, one document per user, day and
+// model, for usageRetention days, prompt templates in a fifth, one
+// document per version, and the /compare record of each model in a sixth.
+type mongoRepository struct {
+	userColl         *mongo.Collection
+	conversationColl *mongo.Collection
+	factColl         *mongo.Collection
+	usageColl        *mongo.Collection
+	templateColl     *mongo.Collection
+	recordColl       *mongo.Collection
+}
+
+type mongoUser struct {
+	Username    string            `bson:"_id"`
+	Preferences map[string]string `bson:"preferences"`
+	CreatedAt   time.Time         `bson:"created_at"`
+	LastSeen    time.Time         `bson:"last_seen"`
+}
+
+type mongoConversation struct {
+	ID        string    `bson:"_id"`
+	User      string    `bson:"user"`
+	Prompt    string    `bson:"prompt"`
+	Response  string    `bson:"response"`
+	Model     string    `bson:"model,omitempty"`
+	Timestamp time.Time `bson:"timestamp"`
+
+	TemplateID      string `bson:"template_id,omitempty"`
+	TemplateVersion int    `bson:"template_version,omitempty"`
+}
+
+func (doc mongoConversation) conversation() Conversation {
+	return Conversation{
+		ID:              doc.ID,
+		User:            doc.User,
+		Prompt:          doc.Prompt,
+		Response:        doc.Response,
+		Model:           doc.Model,
+		Timestamp:       doc.Timestamp,
+		TemplateID:      doc.TemplateID,
+		TemplateVersion: doc.TemplateVersion,
+	}
+}
+
+type mongoUsage struct {
+	User             string    `bson:"user"`
+	Date             string    `bson:"date"`
+	Model            string    `bson:"model"`
+	Requests         int       `bson:"requests"`
+	PromptTokens     int       `bson:"prompt_tokens"`
+	CompletionTokens int       `bson:"completion_tokens"`
+	TotalTokens      int       `bson:"total_tokens"`
+	DurationMs       int64     `bson:"duration_ms"`
+	Day              time.Time `bson:"day"` // the date as a time, for the TTL index
+}
+
+func (doc mongoUsage) usage() Usage {
+	return Usage{
+		Requests:         doc.Requests,
+		PromptTokens:     doc.PromptTokens,
+		CompletionTokens: doc.CompletionTokens,
+		TotalTokens:      doc.TotalTokens,
+		DurationMs:       doc.DurationMs,
+	}
+}
+
+type mongoTemplate struct {
+	ID          string             `bson:"template_id"`
+	Version     int                `bson:"version"`
+	Description string             `bson:"description,omitempty"`
+	Body        string             `bson:"body"`
+	Variables   []TemplateVariable `bson:"variables"`
+	CreatedAt   time.Time          `bson:"created_at"`
+}
+
+func (doc mongoTemplate) template() PromptTemplate {
+	return PromptTemplate{
+		ID:          doc.ID,
+		Version:     doc.Version,
+		Description: doc.Description,
+		Body:        doc.Body,
+		Variables:   doc.Variables,
+		CreatedAt:   doc.CreatedAt,
+	}
+}
+
+type mongoModelRecord struct {
+	Model          string `bson:"_id"`
+	Comparisons    int    `bson:"comparisons"`
+	Wins           int    `bson:"wins"`
+	Errors         int    `bson:"errors"`
+	TotalLatencyMs int64  `bson:"total_latency_ms"`
+	Answered       int    `bson:"answered"`
+}
+
+type mongoFact struct {
+	User      string    `bson:"user"`
+	Fact      string    `bson:"fact"`
+	CreatedAt time.Time `bson:"created_at"`
+	LastSeen  time.Time `bson:"last_seen"` // follows the user's, so facts expire with them
+}
+
+// newMongoRepository creates the indexes the repository relies on. A TTL
+// of zero disables expiry for that collection.
+func newMongoRepository(ctx context.Context, db *mongo.Database, conversationTTL, userTTL time.Duration) (*mongoRepository, error) {
+	mr := &mongoRepository{
+		userColl:         db.Collection("users"),
+		conversationColl: db.Collection("conversations"),
+		factColl:         db.Collection("facts"),
+		usageColl:        db.Collection("usage"),
+		templateColl:     db.Collection("templates"),
+		recordColl:       db.Collection("compare_records"),
+	}
+
+	ttl := func(field string, d time.Duration) []mongo.IndexModel {
+		if d <= 0 {
+			return nil
+		}
+		return []mongo.IndexModel{{
+			Keys:    bson.D{{Key: field, Value: 1}},
+			Options: options.Index().SetName(field + "_ttl").SetExpireAfterSeconds(int32(d.Seconds())),
+		}}
+	}
+
+	indexes := []struct {
+		coll   *mongo.Collection
+		models []mongo.IndexModel
+	}{
+		{mr.userColl, ttl("last_seen", userTTL)},
+		{mr.conversationColl, append([]mongo.IndexModel{{
+			Keys:    bson.D{{Key: "user", Value: 1}, {Key: "timestamp", Value: -1}},
+			Options: options.Index().SetName("user_timestamp"),
+		}, {
+			// Text queries must filter on user, which the prefix makes cheap
+			Keys:    bson.D{{Key: "user", Value: 1}, {Key: "prompt", Value: "text"}, {Key: "response", Value: "text"}},
+			Options: options.Index().SetName("user_text").SetWeights(bson.D{{Key: "prompt", Value: 2}, {Key: "response", Value: 1}}),
+		}}, ttl("timestamp", conversationTTL)...)},
+		{mr.factColl, append([]mongo.IndexModel{{
+			Keys:    bson.D{{Key: "user", Value: 1}, {Key: "fact", Value: 1}},
+			Options: options.Index().SetName("user_fact").SetUnique(true),
+		}, {
+			Keys:    bson.D{{Key: "user", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("user_created_at"),
+		}}, ttl("last_seen", userTTL)...)},
+		{mr.usageColl, append([]mongo.IndexModel{{
+			Keys:    bson.D{{Key: "user", Value: 1}, {Key: "date", Value: 1}, {Key: "model", Value: 1}},
+			Options: options.Index().SetName("user_date_model").SetUnique(true),
+		}, {
+			Keys:    bson.D{{Key: "date", Value: 1}},
+			Options: options.Index().SetName("date"),
+		}}, ttl("day", usageRetention*24*time.Hour)...)},
+		{mr.templateColl, []mongo.IndexModel{{
+			Keys:    bson.D{{Key: "template_id", Value: 1}, {Key: "version", Value: -1}},
+			Options: options.Index().SetName("template_version").SetUnique(true),
+		}}},
+	}
+	for _, idx := range indexes {
+		if len(idx.models) == 0 {
+			continue
+		}
+		if _, err := idx.coll.Indexes().CreateMany(ctx, idx.models); err != nil {
+			return nil, fmt.Errorf("failed to create indexes on %s (drop the old TTL index if the TTL changed): %w", idx.coll.Name(), err)
+		}
+	}
+	return mr, nil
+}
+
+func (mr *mongoRepository) touchUser(ctx context.Context, username string) error {
+	now := time.Now()
+	_, err := mr.userColl.UpdateOne(ctx,
+		bson.M{"_id": username},
+		bson.M{
+			"$set":         bson.M{"last_seen": now},
+			"$setOnInsert": bson.M{"created_at": now, "preferences": bson.M{}},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+
+	// The facts TTL index counts from each fact's own last_seen; keep it in
+	// step so a returning user does not lose facts they stated long ago.
+	if _, err := mr.factColl.UpdateMany(ctx, bson.M{"user": username}, bson.M{"$set": bson.M{"last_seen": now}}); err != nil {
+		return fmt.Errorf("failed to update facts: %w", err)
+	}
+	return nil
+}
+
+func (mr *mongoRepository) addConversation(ctx context.Context, conv Conversation, maxHistory int) error {
+	_, err := mr.conversationColl.InsertOne(ctx, mongoConversation{
+		ID:        conv.ID,
+		User:      conv.User,
+		Prompt:    conv.Prompt,
+		Response:  conv.Response,
+		Model:     conv.Model,
+		Timestamp: conv.Timestamp,
+
+		TemplateID:      conv.TemplateID,
+		TemplateVersion: conv.TemplateVersion,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert conversation: %w", err)
+	}
+
+	// Maintain conversation history limit
+	return trimOldest(ctx, mr.conversationColl, conv.User, "timestamp", maxHistory)
+}
+
+func (mr *mongoRepository) recentConversations(ctx context.Context, username string, limit int) ([]Conversation, error) {
+	cursor, err := mr.conversationColl.Find(ctx,
+		bson.M{"user": username},
+		options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversations: %w", err)
+	}
+
+	var docs []mongoConversation
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to read conversations: %w", err)
+	}
+
+	// Newest first from the index, oldest first for the context
+	result := make([]Conversation, len(docs))
+	for i, doc := range docs {
+		result[len(docs)-1-i] = doc.conversation()
+	}
+	return result, nil
+}
+
+func (mr *mongoRepository) addFact(ctx context.Context, username, fact string, maxFacts int) error {
+	now := time.Now()
+	_, err := mr.factColl.UpdateOne(ctx,
+		bson.M{"user": username, "fact": fact},
+		bson.M{
+			"$set":         bson.M{"last_seen": now},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store fact: %w", err)
+	}
+	return trimOldest(ctx, mr.factColl, username, "created_at", maxFacts)
+}
+
+func (mr *mongoRepository) facts(ctx context.Context, username string) ([]string, error) {
+	cursor, err := mr.factColl.Find(ctx,
+		bson.M{"user": username},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query facts: %w", err)
+	}
+
+	var docs []mongoFact
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to read facts: %w", err)
+	}
+
+	result := make([]string, len(docs))
+	for i, doc := range docs {
+		result[i] = doc.Fact
+	}
+	return result, nil
+}
+
+func (mr *mongoRepository) userStats(ctx context.Context, username string) (*UserStats, error) {
+	var user mongoUser
+	err := mr.userColl.FindOne(ctx, bson.M{"_id": username}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+
+	conversations, err := mr.conversationColl.CountDocuments(ctx, bson.M{"user": username})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count conversations: %w", err)
+	}
+	facts, err := mr.factColl.CountDocuments(ctx, bson.M{"user": username})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count facts: %w", err)
+	}
+
+	if user.Preferences == nil {
+		user.Preferences = make(map[string]string)
+	}
+	return &UserStats{
+		Username:           user.Username,
+		TotalConversations: int(conversations),
+		PersonalFacts:      int(facts),
+		LastSeen:           user.LastSeen,
+		Preferences:        user.Preferences,
+	}, nil
+}
+
+func (mr *mongoRepository) searchConversations(ctx context.Context, q SearchQuery) ([]SearchHit, int, error) {
+	filter := bson.M{"user": q.User}
+	if len(q.Words) > 0 {
+		// The text index stems the raw words itself; quoting them would
+		// turn each into a phrase matched against the unstemmed text
+		filter["$text"] = bson.M{"$search": strings.Join(q.Words, " ")}
+	}
+	timeRange := bson.M{}
+	if !q.From.IsZero() {
+		timeRange["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		timeRange["$lt"] = q.To
+	}
+	if len(timeRange) > 0 {
+		filter["timestamp"] = timeRange
+	}
+
+	// $text matches any of the words and stems them its own way, so with
+	// terms the index only narrows the candidates: every match is read and
+	// kept only if it contains all terms as the memory backend stems them.
+	// A user's history is capped, which keeps this cheap.
+	opts := options.Find()
+	if len(q.Terms) == 0 {
+		total, err := mr.conversationColl.CountDocuments(ctx, filter)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to count search results: %w", err)
+		}
+		opts.SetSkip(int64((q.Page - 1) * q.PageSize)).SetLimit(int64(q.PageSize))
+		opts.SetSort(bson.D{{Key: "timestamp", Value: -1}})
+		hits, err := mr.findHits(ctx, filter, opts, nil)
+		return hits, int(total), err
+	}
+
+	if q.Newest {
+		opts.SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	} else {
+		opts.SetProjection(bson.M{"score": bson.M{"$meta": "textScore"}, "user": 1, "prompt": 1, "response": 1, "model": 1, "timestamp": 1, "template_id": 1, "template_version": 1})
+		opts.SetSort(bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: "timestamp", Value: -1}})
+	}
+	hits, err := mr.findHits(ctx, filter, opts, q.Terms)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := len(hits)
+	from := (q.Page - 1) * q.PageSize
+	if from > total {
+		from = total
+	}
+	to := from + q.PageSize
+	if to > total {
+		to = total
+	}
+	return hits[from:to], total, nil
+}
+
+// findHits runs a search query and drops conversations missing any of terms.
+func (mr *mongoRepository) findHits(ctx context.Context, filter bson.M, opts *options.FindOptions, terms []string) ([]SearchHit, error) {
+	cursor, err := mr.conversationColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search conversations: %w", err)
+	}
+
+	var docs []struct {
+		mongoConversation `bson:",inline"`
+		Score             float64 `bson:"score"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to read search results: %w", err)
+	}
+
+	hits := make([]SearchHit, 0, len(docs))
+	for _, doc := range docs {
+		conv := doc.conversation()
+		if !containsTerms(conv, terms) {
+			continue
+		}
+		hits = append(hits, SearchHit{Conversation: conv, Score: doc.Score})
+	}
+	return hits, nil
+}
+
+func (mr *mongoRepository) recordUsage(ctx context.Context, user, model, date string, u Usage) error {
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return fmt.Errorf("invalid usage date %q: %w", date, err)
+	}
+	_, err = mr.usageColl.UpdateOne(ctx,
+		bson.M{"user": user, "date": date, "model": model},
+		bson.M{
+			"$inc": bson.M{
+				"requests":          u.Requests,
+				"prompt_tokens":     u.PromptTokens,
+				"completion_tokens": u.CompletionTokens,
+				"total_tokens":      u.TotalTokens,
+				"duration_ms":       u.DurationMs,
+			},
+			"$setOnInsert": bson.M{"day": day},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record usage: %w", err)
+	}
+	return nil
+}
+
+func (mr *mongoRepository) usageDays(ctx context.Context, user, from, to string) ([]DayReport, error) {
+	cursor, err := mr.usageColl.Find(ctx, bson.M{"user": user, "date": bson.M{"$gte": from, "$lte": to}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage: %w", err)
+	}
+	var docs []mongoUsage
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to read usage: %w", err)
+	}
+
+	byDate := make(map[string]*DayReport)
+	for _, doc := range docs {
+		day := byDate[doc.Date]
+		if day == nil {
+			day = &DayReport{Date: doc.Date, ByModel: make(map[string]Usage)}
+			byDate[doc.Date] = day
+		}
+		day.Usage.add(doc.usage())
+		u := day.ByModel[doc.Model]
+		u.add(doc.usage())
+		day.ByModel[doc.Model] = u
+	}
+	result := make([]DayReport, 0, len(byDate))
+	for _, day := range byDate {
+		result = append(result, *day)
+	}
+	return result, nil
+}
+
+func (mr *mongoRepository) usageByUser(ctx context.Context, from, to string) ([]UsageSummary, error) {
+	sum := func(field string) bson.M { return bson.M{"$sum": "$" + field} }
+	cursor, err := mr.usageColl.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"date": bson.M{"$gte": from, "$lte": to}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":               "$user",
+			"requests":          sum("requests"),
+			"prompt_tokens":     sum("prompt_tokens"),
+			"completion_tokens": sum("completion_tokens"),
+			"total_tokens":      sum("total_tokens"),
+			"duration_ms":       sum("duration_ms"),
+		}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate usage: %w", err)
+	}
+	var docs []struct {
+		User       string `bson:"_id"`
+		mongoUsage `bson:",inline"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to read usage: %w", err)
+	}
+
+	result := make([]UsageSummary, 0, len(docs))
+	for _, doc := range docs {
+		if doc.Requests > 0 {
+			result = append(result, UsageSummary{User: doc.User, Usage: doc.usage()})
+		}
+	}
+	return result, nil
+}
+
+func (mr *mongoRepository) addTemplate(ctx context.Context, t PromptTemplate) error {
+	_, err := mr.templateColl.InsertOne(ctx, mongoTemplate{
+		ID:          t.ID,
+		Version:     t.Version,
+		Description: t.Description,
+		Body:        t.Body,
+		Variables:   t.Variables,
+		CreatedAt:   t.CreatedAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return errTemplateVersionTaken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert template: %w", err)
+	}
+	return nil
+}
+
+func (mr *mongoRepository) template(ctx context.Context, id string, version int) (*PromptTemplate, error) {
+	filter := bson.M{"template_id": id}
+	if version != 0 {
+		filter["version"] = version
+	}
+	var doc mongoTemplate
+	err := mr.templateColl.FindOne(ctx, filter, options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}})).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template: %w", err)
+	}
+	t := doc.template()
+	return &t, nil
+}
+
+func (mr *mongoRepository) templateHistory(ctx context.Context, id string) ([]PromptTemplate, error) {
+	cursor, err := mr.templateColl.Find(ctx,
+		bson.M{"template_id": id},
+		options.Find().SetSort(bson.D{{Key: "version", Value: 1}}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query templates: %w", err)
+	}
+	var docs []mongoTemplate
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to read templates: %w", err)
+	}
+
+	result := make([]PromptTemplate, len(docs))
+	for i, doc := range docs {
+		result[i] = doc.template()
+	}
+	return result, nil
+}
+
+func (mr *mongoRepository) latestTemplates(ctx context.Context) ([]PromptTemplate, error) {
+	cursor, err := mr.templateColl.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "template_id", Value: 1}, {Key: "version", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$template_id", "latest": bson.M{"$first": "$$ROOT"}}}},
+		{{Key: "$replaceWith", Value: "$latest"}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query templates: %w", err)
+	}
+	var docs []mongoTemplate
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to read templates: %w", err)
+	}
+
+	result := make([]PromptTemplate, len(docs))
+	for i, doc := range docs {
+		result[i] = doc.template()
+	}
+	return result, nil
+}
+
+func (mr *mongoRepository) updateModelRecord(ctx context.Context, model string, delta modelRecord) error {
+	_, err := mr.recordColl.UpdateOne(ctx,
+		bson.M{"_id": model},
+		bson.M{"$inc": bson.M{
+			"comparisons":      delta.Comparisons,
+			"wins":             delta.Wins,
+			"errors":           delta.Errors,
+			"total_latency_ms": delta.totalLatencyMs,
+			"answered":         delta.answered,
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update compare record: %w", err)
+	}
+	return nil
+}
+
+func (mr *mongoRepository) modelRecords(ctx context.Context) (map[string]modelRecord, error) {
+	cursor, err := mr.recordColl.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query compare records: %w", err)
+	}
+	var docs []mongoModelRecord
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to read compare records: %w", err)
+	}
+
+	result := make(map[string]modelRecord, len(docs))
+	for _, doc := range docs {
+		result[doc.Model] = modelRecord{
+			Comparisons:    doc.Comparisons,
+			Wins:           doc.Wins,
+			Errors:         doc.Errors,
+			totalLatencyMs: doc.TotalLatencyMs,
+			answered:       doc.Answered,
+		}
+	}
+	return result, nil
+}
+
+// trimOldest deletes the user's documents beyond the newest keep, ordered
+// by the given time field.
+func trimOldest(ctx context.Context, coll *mongo.Collection, username, field string, keep int) error {
+	cursor, err := coll.Find(ctx,
+		bson.M{"user": username},
+		options.Find().
+			SetSort(bson.D{{Key: field, Value: -1}}).
+			SetSkip(int64(keep)).
+			SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to find old %s: %w", coll.Name(), err)
+	}
+
+	var old []struct {
+		ID interface{} `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &old); err != nil {
+		return fmt.Errorf("failed to read old %s: %w", coll.Name(), err)
+	}
+	if len(old) == 0 {
+		return nil
+	}
+
+	ids := make([]interface{}, len(old))
+	for i, doc := range old {
+		ids[i] = doc.ID
+	}
+	if _, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return fmt.Errorf("failed to trim %s: %w", coll.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// newTestMongoRepository connects to MONGO_URI and returns a repository
+// on a fresh database that is dropped when the test ends. Tests using it
+// are skipped when MONGO_URI is not set.
+func newTestMongoRepository(t *testing.T) (*mongoRepository, *mongo.Database) {
+	t.Helper()
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		t.Skip("MONGO_URI not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+
+	db := client.Database(fmt.Sprintf("aiprompt_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+
+	repo, err := newMongoRepository(ctx, db, time.Hour, 2*time.Hour)
+	if err != nil {
+		t.Fatalf("newMongoRepository: %v", err)
+	}
+	return repo, db
+}
+
+func TestMongoTTLIndexes(t *testing.T) {
+	_, db := newTestMongoRepository(t)
+	ctx := context.Background()
+
+	want := map[string]map[string]int32{
+		"conversations": {"timestamp_ttl": int32(time.Hour.Seconds())},
+		"users":         {"last_seen_ttl": int32((2 * time.Hour).Seconds())},
+		"facts":         {"last_seen_ttl": int32((2 * time.Hour).Seconds())},
+		"usage":         {"day_ttl": int32((usageRetention * 24 * time.Hour).Seconds())},
+	}
+	for coll, indexes := range want {
+		cursor, err := db.Collection(coll).Indexes().List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var specs []bson.M
+		if err := cursor.All(ctx, &specs); err != nil {
+			t.Fatal(err)
+		}
+		for name, seconds := range indexes {
+			found := false
+			for _, spec := range specs {
+				if spec["name"] == name {
+					found = true
+					if got := fmt.Sprint(spec["expireAfterSeconds"]); got != fmt.Sprint(seconds) {
+						t.Errorf("%s.%s expires after %s seconds, want %d", coll, name, got, seconds)
+					}
+				}
+			}
+			if !found {
+				t.Errorf("%s has no index %s", coll, name)
+			}
+		}
+	}
+}
+
+func TestMongoTouchUserKeepsFacts(t *testing.T) {
+	repo, db := newTestMongoRepository(t)
+	ctx := context.Background()
+
+	if err := repo.addFact(ctx, "gina", "likes tea", 10); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-24 * time.Hour)
+	if _, err := db.Collection("facts").UpdateMany(ctx, bson.M{"user": "gina"}, bson.M{"$set": bson.M{"last_seen": old}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.touchUser(ctx, "gina"); err != nil {
+		t.Fatal(err)
+	}
+
+	var fact mongoFact
+	if err := db.Collection("facts").FindOne(ctx, bson.M{"user": "gina"}).Decode(&fact); err != nil {
+		t.Fatal(err)
+	}
+	if !fact.LastSeen.After(old) {
+		t.Errorf("fact last_seen %v was not refreshed by touchUser", fact.LastSeen)
+	}
+}
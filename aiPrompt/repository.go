@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Repository persists users, their conversations and personal facts.
+// MemoryStore runs against either the in-memory or the MongoDB backend.
+type Repository interface {
+	// touchUser creates the user if needed and updates LastSeen.
+	touchUser(ctx context.Context, username string) error
+	// addConversation stores a turn and keeps only the newest maxHistory.
+	addConversation(ctx context.Context, conv Conversation, maxHistory int) error
+	// recentConversations returns up to limit turns, oldest first.
+	recentConversations(ctx context.Context, username string, limit int) ([]Conversation, error)
+	// addFact stores a fact once and keeps only the newest maxFacts.
+	addFact(ctx context.Context, username, fact string, maxFacts int) error
+	// facts returns the user's facts, oldest first.
+	facts(ctx context.Context, username string) ([]string, error)
+	// userStats returns nil if the user does not exist.
+	userStats(ctx context.Context, username string) (*UserStats, error)
+	// searchConversations returns one page of matches and the total count.
+	searchConversations(ctx context.Context, q SearchQuery) ([]SearchHit, int, error)
+
+	// recordUsage adds u to the user's usage of model on date (YYYY-MM-DD).
+	recordUsage(ctx context.Context, user, model, date string, u Usage) error
+	// usageDays returns the user's usage per day in [from, to], in no
+	// particular order.
+	usageDays(ctx context.Context, user, from, to string) ([]DayReport, error)
+	// usageByUser sums each user's usage in [from, to]; users without
+	// requests are left out.
+	usageByUser(ctx context.Context, from, to string) ([]UsageSummary, error)
+
+	// addTemplate stores a template version; it returns
+	// errTemplateVersionTaken if that ID and version already exist.
+	addTemplate(ctx context.Context, t PromptTemplate) error
+	// template returns one version of a template, 0 meaning the latest, or
+	// nil if it does not exist.
+	template(ctx context.Context, id string, version int) (*PromptTemplate, error)
+	// templateHistory returns every version of a template, oldest first.
+	templateHistory(ctx context.Context, id string) ([]PromptTemplate, error)
+	// latestTemplates returns the latest version of every template.
+	latestTemplates(ctx context.Context) ([]PromptTemplate, error)
+
+	// updateModelRecord adds delta to a model's /compare record.
+	updateModelRecord(ctx context.Context, model string, delta modelRecord) error
+	// modelRecords returns the /compare record of every model.
+	modelRecords(ctx context.Context) (map[string]modelRecord, error)
+}
+
+type UserStats struct {
+	Username           string            `json:"username"`
+	TotalConversations int               `json:"total_conversations"`
+	PersonalFacts      int               `json:"personal_facts"`
+	LastSeen           time.Time         `json:"last_seen"`
+	Preferences        map[string]string `json:"preferences"`
+}
+
+// memoryRepository keeps everything in process memory; it is lost on restart.
+type memoryRepository struct {
+	sync.RWMutex
+	users map[string]*UserProfile
+	index map[string]map[string]map[string]int // user -> term -> conversation ID -> occurrences
+	usage map[string]map[string]*dayUsage      // user -> date -> usage
+
+	templates map[string][]PromptTemplate // oldest version first
+	records   map[string]modelRecord      // model -> /compare record
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{
+		users: make(map[string]*UserProfile),
+		index: make(map[string]map[string]map[string]int),
+		usage: make(map[string]map[string]*dayUsage),
+
+		templates: make(map[string][]PromptTemplate),
+		records:   make(map[string]modelRecord),
+	}
+}
+
+// indexConversation adds (delta 1) or removes (delta -1) a conversation's
+// terms from the user's inverted index. Callers must hold the lock.
+func (mr *memoryRepository) indexConversation(conv Conversation, delta int) {
+	terms := mr.index[conv.User]
+	if terms == nil {
+		terms = make(map[string]map[string]int)
+		mr.index[conv.User] = terms
+	}
+	for _, word := range tokenize(conv.Prompt + " " + conv.Response) {
+		term := stem(word)
+		postings := terms[term]
+		if postings == nil {
+			postings = make(map[string]int)
+			terms[term] = postings
+		}
+		if postings[conv.ID] += delta; postings[conv.ID] <= 0 {
+			delete(postings, conv.ID)
+			if len(postings) == 0 {
+				delete(terms, term)
+			}
+		}
+	}
+}
+
+func (mr *memoryRepository) touchUser(_ context.Context, username string) error {
+	mr.Lock()
+	defer mr.Unlock()
+
+	if user, exists := mr.users[username]; exists {
+		user.LastSeen = time.Now()
+		return nil
+	}
+
+	mr.users[username] = &UserProfile{
+		Username:      username,
+		Conversations: make([]Conversation, 0),
+		PersonalFacts: make([]string, 0),
+		Preferences:   make(map[string]string),
+		LastSeen:      time.Now(),
+	}
+	return nil
+}
+
+func (mr *memoryRepository) addConversation(_ context.Context, conv Conversation, maxHistory int) error {
+	mr.Lock()
+	defer mr.Unlock()
+
+	user := mr.users[conv.User]
+	if user == nil {
+		return nil
+	}
+
+	user.Conversations = append(user.Conversations, conv)
+	mr.indexConversation(conv, 1)
+
+	// Maintain conversation history limit
+	if len(user.Conversations) > maxHistory {
+		for _, old := range user.Conversations[:len(user.Conversations)-maxHistory] {
+			mr.indexConversation(old, -1)
+		}
+		user.Conversations = user.Conversations[len(user.Conversations)-maxHistory:]
+	}
+	return nil
+}
+
+func (mr *memoryRepository) recentConversations(_ context.Context, username string, limit int) ([]Conversation, error) {
+	mr.RLock()
+	defer mr.RUnlock()
+
+	user := mr.users[username]
+	if user == nil {
+		return nil, nil
+	}
+
+	recent := user.Conversations
+	if len(recent) > limit {
+		recent = recent[len(recent)-limit:]
+	}
+	return append([]Conversation(nil), recent...), nil
+}
+
+func (mr *memoryRepository) addFact(_ context.Context, username, fact string, maxFacts int) error {
+	mr.Lock()
+	defer mr.Unlock()
+
+	user := mr.users[username]
+	if user == nil || contains(user.PersonalFacts, fact) {
+		return nil
+	}
+
+	user.PersonalFacts = append(user.PersonalFacts, fact)
+	if len(user.PersonalFacts) > maxFacts {
+		user.PersonalFacts = user.PersonalFacts[len(user.PersonalFacts)-maxFacts:]
+	}
+	return nil
+}
+
+func (mr *memoryRepository) facts(_ context.Context, username string) ([]string, error) {
+	mr.RLock()
+	defer mr.RUnlock()
+
+	user := mr.users[username]
+	if user == nil {
+		return nil, nil
+	}
+	return append([]string(nil), user.PersonalFacts...), nil
+}
+
+func (mr *memoryRepository) userStats(_ context.Context, username string) (*UserStats, error) {
+	mr.RLock()
+	defer mr.RUnlock()
+
+	user := mr.users[username]
+	if user == nil {
+		return nil, nil
+	}
+
+	prefs := make(map[string]string, len(user.Preferences))
+	for k, v := range user.Preferences {
+		prefs[k] = v
+	}
+	return &UserStats{
+		Username:           user.Username,
+		TotalConversations: len(user.Conversations),
+		PersonalFacts:      len(user.PersonalFacts),
+		LastSeen:           user.LastSeen,
+		Preferences:        prefs,
+	}, nil
+}
+
+func (mr *memoryRepository) searchConversations(_ context.Context, q SearchQuery) ([]SearchHit, int, error) {
+	mr.RLock()
+	defer mr.RUnlock()
+
+	user := mr.users[q.User]
+	if user == nil {
+		return []SearchHit{}, 0, nil
+	}
+
+	// Score each conversation by how often the terms occur; a conversation
+	// missing any term is dropped.
+	var scores map[string]int
+	for _, term := range q.Terms {
+		postings := mr.index[q.User][term]
+		next := make(map[string]int)
+		for id, n := range postings {
+			if prev, ok := scores[id]; ok || scores == nil {
+				next[id] = prev + n
+			}
+		}
+		scores = next
+	}
+
+	hits := make([]SearchHit, 0)
+	for _, conv := range user.Conversations {
+		if !q.inRange(conv.Timestamp) {
+			continue
+		}
+		score, ok := scores[conv.ID]
+		if scores != nil && !ok {
+			continue
+		}
+		hits = append(hits, SearchHit{Conversation: conv, Score: float64(score)})
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		if !q.Newest && hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Conversation.Timestamp.After(hits[j].Conversation.Timestamp)
+	})
+
+	total := len(hits)
+	start := (q.Page - 1) * q.PageSize
+	if start > total {
+		start = total
+	}
+	end := start + q.PageSize
+	if end > total {
+		end = total
+	}
+	return hits[start:end], total, nil
+}
+
+func (mr *memoryRepository) recordUsage(_ context.Context, user, model, date string, u Usage) error {
+	mr.Lock()
+	defer mr.Unlock()
+
+	days := mr.usage[user]
+	if days == nil {
+		days = make(map[string]*dayUsage)
+		mr.usage[user] = days
+	}
+	day := days[date]
+	if day == nil {
+		day = &dayUsage{byModel: make(map[string]*Usage)}
+		days[date] = day
+
+		// A new day is a good moment to forget the oldest ones
+		cutoff := usageDate(time.Now().AddDate(0, 0, -usageRetention))
+		for d := range days {
+			if d < cutoff {
+				delete(days, d)
+			}
+		}
+	}
+	day.add(u)
+	if day.byModel[model] == nil {
+		day.byModel[model] = &Usage{}
+	}
+	day.byModel[model].add(u)
+	return nil
+}
+
+func (mr *memoryRepository) usageDays(_ context.Context, user, from, to string) ([]DayReport, error) {
+	mr.RLock()
+	defer mr.RUnlock()
+
+	result := make([]DayReport, 0)
+	for date, day := range mr.usage[user] {
+		if date < from || date > to {
+			continue
+		}
+		byModel := make(map[string]Usage, len(day.byModel))
+		for model, u := range day.byModel {
+			byModel[model] = *u
+		}
+		result = append(result, DayReport{Date: date, Usage: day.Usage, ByModel: byModel})
+	}
+	return result, nil
+}
+
+func (mr *memoryRepository) usageByUser(_ context.Context, from, to string) ([]UsageSummary, error) {
+	mr.RLock()
+	defer mr.RUnlock()
+
+	result := make([]UsageSummary, 0)
+	for user, days := range mr.usage {
+		summary := UsageSummary{User: user}
+		for date, day := range days {
+			if date >= from && date <= to {
+				summary.Usage.add(day.Usage)
+			}
+		}
+		if summary.Usage.Requests > 0 {
+			result = append(result, summary)
+		}
+	}
+	return result, nil
+}
+
+func (mr *memoryRepository) addTemplate(_ context.Context, t PromptTemplate) error {
+	mr.Lock()
+	defer mr.Unlock()
+
+	history := mr.templates[t.ID]
+	if t.Version != len(history)+1 {
+		return errTemplateVersionTaken
+	}
+	mr.templates[t.ID] = append(history, t)
+	return nil
+}
+
+func (mr *memoryRepository) template(_ context.Context, id string, version int) (*PromptTemplate, error) {
+	mr.RLock()
+	defer mr.RUnlock()
+
+	history := mr.templates[id]
+	if version == 0 {
+		version = len(history)
+	}
+	if version < 1 || version > len(history) {
+		return nil, nil
+	}
+	t := history[version-1]
+	return &t, nil
+}
+
+func (mr *memoryRepository) templateHistory(_ context.Context, id string) ([]PromptTemplate, error) {
+	mr.RLock()
+	defer mr.RUnlock()
+	return append([]PromptTemplate{}, mr.templates[id]...), nil
+}
+
+func (mr *memoryRepository) latestTemplates(_ context.Context) ([]PromptTemplate, error) {
+	mr.RLock()
+	defer mr.RUnlock()
+
+	result := make([]PromptTemplate, 0, len(mr.templates))
+	for _, history := range mr.templates {
+		result = append(result, history[len(history)-1])
+	}
+	return result, nil
+}
+
+func (mr *memoryRepository) updateModelRecord(_ context.Context, model string, delta modelRecord) error {
+	mr.Lock()
+	defer mr.Unlock()
+
+	r := mr.records[model]
+	r.add(delta)
+	mr.records[model] = r
+	return nil
+}
+
+func (mr *memoryRepository) modelRecords(_ context.Context) (map[string]modelRecord, error) {
+	mr.RLock()
+	defer mr.RUnlock()
+
+	result := make(map[string]modelRecord, len(mr.records))
+	for model, r := range mr.records {
+		result[model] = r
+	}
+	return result, nil
+}
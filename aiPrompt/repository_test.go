@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// forEachRepository runs a contract test against the in-memory repository
+// and, when MONGO_URI is set, against MongoDB.
+func forEachRepository(t *testing.T, test func(t *testing.T, repo Repository)) {
+	t.Run("memory", func(t *testing.T) { test(t, newMemoryRepository()) })
+	t.Run("mongo", func(t *testing.T) {
+		repo, _ := newTestMongoRepository(t)
+		test(t, repo)
+	})
+}
+
+func TestUsersAndConversations(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+
+		if stats, err := repo.userStats(ctx, "alice"); err != nil || stats != nil {
+			t.Fatalf("unknown user: stats %+v, error %v", stats, err)
+		}
+		if err := repo.touchUser(ctx, "alice"); err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now().Truncate(time.Millisecond)
+		for i := 0; i < 5; i++ {
+			conv := Conversation{
+				ID:        fmt.Sprintf("alice_%d", i),
+				User:      "alice",
+				Prompt:    fmt.Sprintf("question %d", i),
+				Response:  fmt.Sprintf("answer %d", i),
+				Timestamp: start.Add(time.Duration(i) * time.Second),
+			}
+			if err := repo.addConversation(ctx, conv, 3); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		recent, err := repo.recentConversations(ctx, "alice", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recent) != 3 || recent[0].ID != "alice_2" || recent[2].ID != "alice_4" {
+			t.Fatalf("kept %+v, want the newest three oldest first", recent)
+		}
+
+		stats, err := repo.userStats(ctx, "alice")
+		if err != nil || stats == nil {
+			t.Fatalf("stats %+v, error %v", stats, err)
+		}
+		if stats.TotalConversations != 3 || stats.LastSeen.IsZero() {
+			t.Errorf("stats %+v", stats)
+		}
+	})
+}
+
+func TestFactsAreUnique(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+		if err := repo.touchUser(ctx, "bob"); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, fact := range []string{"likes Go", "lives in Pune", "likes Go", "has a cat"} {
+			if err := repo.addFact(ctx, "bob", fact, 10); err != nil {
+				t.Fatal(err)
+			}
+		}
+		facts, err := repo.facts(ctx, "bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(facts) != 3 || facts[0] != "likes Go" {
+			t.Errorf("facts %q, want three, oldest first", facts)
+		}
+
+		if err := repo.addFact(ctx, "bob", "drinks tea", 2); err != nil {
+			t.Fatal(err)
+		}
+		if facts, _ := repo.facts(ctx, "bob"); len(facts) != 2 || facts[1] != "drinks tea" {
+			t.Errorf("after trimming facts %q", facts)
+		}
+	})
+}
+
+func TestSearchConversations(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+
+		now := time.Now().Truncate(time.Millisecond)
+		turns := []Conversation{
+			{ID: "c1", User: "carol", Prompt: "How do goroutines work?", Response: "They are cheap threads.", Timestamp: now.Add(-3 * time.Hour)},
+			{ID: "c2", User: "carol", Prompt: "Best pizza topping?", Response: "Mushrooms.", Timestamp: now.Add(-2 * time.Hour)},
+			{ID: "c3", User: "carol", Prompt: "Explain channels", Response: "Goroutines talk over channels.", Timestamp: now.Add(-time.Hour)},
+			{ID: "d1", User: "dave", Prompt: "goroutine leak", Response: "Cancel the context.", Timestamp: now},
+		}
+		for _, user := range []string{"carol", "dave"} {
+			if err := repo.touchUser(ctx, user); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, conv := range turns {
+			if err := repo.addConversation(ctx, conv, 50); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		q := SearchQuery{User: "carol", Words: distinct(tokenize("goroutines")), Newest: true, Page: 1, PageSize: 10}
+		q.Terms = searchTerms("goroutines")
+		hits, total, err := repo.searchConversations(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 2 || len(hits) != 2 || hits[0].Conversation.ID != "c3" || hits[1].Conversation.ID != "c1" {
+			t.Fatalf("total %d, hits %+v", total, hits)
+		}
+
+		q.From = now.Add(-90 * time.Minute)
+		if hits, total, err := repo.searchConversations(ctx, q); err != nil || total != 1 || hits[0].Conversation.ID != "c3" {
+			t.Errorf("with a time range: total %d, hits %+v, error %v", total, hits, err)
+		}
+
+		// Both backends require every term, not just one of them.
+		q = SearchQuery{User: "carol", Words: distinct(tokenize("goroutines channels")), Terms: searchTerms("goroutines channels"), Page: 1, PageSize: 10}
+		if hits, total, err := repo.searchConversations(ctx, q); err != nil || total != 1 || hits[0].Conversation.ID != "c3" {
+			t.Errorf("two terms: total %d, hits %+v, error %v", total, hits, err)
+		}
+
+		q = SearchQuery{User: "carol", Words: []string{"pizza"}, Terms: []string{"pizza"}, Page: 1, PageSize: 10}
+		if hits, _, err := repo.searchConversations(ctx, q); err != nil || len(hits) != 1 || hits[0].Score <= 0 {
+			t.Errorf("relevance search: hits %+v, error %v", hits, err)
+		}
+	})
+}
+
+func TestUsage(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+
+		// Recent days, as both backends forget usage older than
+		// usageRetention days.
+		day1 := usageDate(time.Now().AddDate(0, 0, -1))
+		day2 := usageDate(time.Now())
+		records := []struct {
+			user, model, date string
+			tokens            int
+		}{
+			{"erin", "llama3", day1, 100},
+			{"erin", "llama3", day1, 50},
+			{"erin", "llama3.2:1b", day2, 10},
+			{"frank", "llama3", day2, 7},
+		}
+		for _, r := range records {
+			if err := repo.recordUsage(ctx, r.user, r.model, r.date, Usage{Requests: 1, TotalTokens: r.tokens}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		days, err := repo.usageDays(ctx, "erin", day1, day2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		byDate := make(map[string]DayReport)
+		for _, day := range days {
+			byDate[day.Date] = day
+		}
+		if d := byDate[day1]; d.Usage.Requests != 2 || d.Usage.TotalTokens != 150 {
+			t.Errorf("%s: %+v", day1, d)
+		}
+		if d := byDate[day2]; d.ByModel["llama3.2:1b"].TotalTokens != 10 {
+			t.Errorf("%s: %+v", day2, d)
+		}
+
+		summaries, err := repo.usageByUser(ctx, day2, day2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		totals := make(map[string]int)
+		for _, s := range summaries {
+			totals[s.User] = s.Usage.TotalTokens
+		}
+		if len(totals) != 2 || totals["erin"] != 10 || totals["frank"] != 7 {
+			t.Errorf("usage by user %v", totals)
+		}
+	})
+}
+
+func TestTemplates(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+
+		for _, tmpl := range []PromptTemplate{
+			{ID: "summary", Version: 1, Body: "Summarize {{text}}"},
+			{ID: "summary", Version: 2, Body: "Summarize briefly: {{text}}"},
+			{ID: "translate", Version: 1, Body: "Translate {{text}}"},
+		} {
+			tmpl.CreatedAt = time.Now()
+			if err := repo.addTemplate(ctx, tmpl); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := repo.addTemplate(ctx, PromptTemplate{ID: "summary", Version: 2, Body: "again"}); !errors.Is(err, errTemplateVersionTaken) {
+			t.Errorf("duplicate version: got %v", err)
+		}
+
+		latest, err := repo.template(ctx, "summary", 0)
+		if err != nil || latest == nil || latest.Version != 2 {
+			t.Fatalf("latest %+v, error %v", latest, err)
+		}
+		if missing, err := repo.template(ctx, "summary", 9); err != nil || missing != nil {
+			t.Errorf("missing version: %+v, error %v", missing, err)
+		}
+		if history, err := repo.templateHistory(ctx, "summary"); err != nil || len(history) != 2 || history[0].Version != 1 {
+			t.Errorf("history %+v, error %v", history, err)
+		}
+		if all, err := repo.latestTemplates(ctx); err != nil || len(all) != 2 {
+			t.Errorf("latest templates %+v, error %v", all, err)
+		}
+	})
+}
+
+func TestModelRecords(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+
+		for _, delta := range []modelRecord{
+			{answered: 1, totalLatencyMs: 300},
+			{Comparisons: 1, Wins: 1},
+			{Errors: 1},
+		} {
+			if err := repo.updateModelRecord(ctx, "llama3", delta); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		records, err := repo.modelRecords(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := modelRecord{Comparisons: 1, Wins: 1, Errors: 1, totalLatencyMs: 300, answered: 1}
+		if got := records["llama3"]; len(records) != 1 || got != want {
+			t.Errorf("records %+v, want llama3 %+v", records, want)
+		}
+	})
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SearchQuery selects conversations from one user's history. Terms are
+// AND-ed; an empty Terms list matches every conversation in the range.
+// Repositories with a text index may pass Words to it to find candidates
+// and rank them, but must still check the Terms with containsTerms.
+type SearchQuery struct {
+	User     string
+	Terms    []string // normalised with searchTerms
+	Words    []string // distinct lower-case query words, unstemmed
+	From     time.Time
+	To       time.Time // exclusive
+	Newest   bool      // sort by time instead of relevance
+	Page     int       // 1-based
+	PageSize int
+}
+
+type SearchHit struct {
+	Conversation Conversation
+	Score        float64
+}
+
+type SearchResult struct {
+	ID              string    `json:"id"`
+	Timestamp       time.Time `json:"timestamp"`
+	Score           float64   `json:"score,omitempty"`
+	PromptSnippet   string    `json:"prompt_snippet"`
+	ResponseSnippet string    `json:"response_snippet"`
+}
+
+type SearchResponse struct {
+	Query    string         `json:"query,omitempty"`
+	Total    int            `json:"total"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"page_size"`
+	HasMore  bool           `json:"has_more"`
+	Results  []SearchResult `json:"results"`
+}
+
+const (
+	snippetWidth    = 160
+	highlightPrefix = "<mark>"
+	highlightSuffix = "</mark>"
+)
+
+// tokenize splits text into lower-case words.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// stem strips plural endings so "goroutines" finds "goroutine" and
+// "queries" finds "query".
+func stem(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case len(word) > 3 && strings.HasSuffix(word, "es") && strings.ContainsAny(word[len(word)-3:len(word)-2], "sxz"),
+		len(word) > 4 && (strings.HasSuffix(word, "ches") || strings.HasSuffix(word, "shes")):
+		return word[:len(word)-2]
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+// searchTerms turns a query string into distinct stemmed terms.
+func searchTerms(query string) []string {
+	terms := make([]string, 0)
+	for _, word := range tokenize(query) {
+		if term := stem(word); !contains(terms, term) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// containsTerms reports whether every term occurs in the conversation's
+// prompt or response, stemmed the way the memory index stems them.
+func containsTerms(conv Conversation, terms []string) bool {
+	if len(terms) == 0 {
+		return true
+	}
+	found := make(map[string]bool)
+	for _, word := range tokenize(conv.Prompt + " " + conv.Response) {
+		found[stem(word)] = true
+	}
+	for _, term := range terms {
+		if !found[term] {
+			return false
+		}
+	}
+	return true
+}
+
+// distinct returns words without repeats, in their original order.
+func distinct(words []string) []string {
+	result := make([]string, 0, len(words))
+	for _, word := range words {
+		if !contains(result, word) {
+			result = append(result, word)
+		}
+	}
+	return result
+}
+
+// highlight returns a window of text around the first matching word with
+// every match wrapped in <mark> tags. The text itself is HTML-escaped, so
+// the marks are the only markup in the snippet. Without a match it returns
+// the start of the text.
+func highlight(text string, terms []string) string {
+	type span struct{ start, end int }
+	var matches []span
+
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			if contains(terms, stem(strings.ToLower(text[start:end]))) {
+				matches = append(matches, span{start, end})
+			}
+			start = -1
+		}
+	}
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+		} else {
+			flush(i)
+		}
+	}
+	flush(len(text))
+
+	from, to := 0, len(text)
+	if len(matches) > 0 {
+		from = matches[0].start - snippetWidth/4
+	}
+	if from < 0 {
+		from = 0
+	}
+	if to-from > snippetWidth {
+		to = from + snippetWidth
+	}
+	from = wordBoundary(text, from, -1)
+	to = wordBoundary(text, to, 1)
+
+	var sb strings.Builder
+	if from > 0 {
+		sb.WriteString("…")
+	}
+	pos := from
+	for _, m := range matches {
+		if m.start < from || m.end > to {
+			continue
+		}
+		sb.WriteString(html.EscapeString(text[pos:m.start]))
+		sb.WriteString(highlightPrefix + html.EscapeString(text[m.start:m.end]) + highlightSuffix)
+		pos = m.end
+	}
+	sb.WriteString(html.EscapeString(text[pos:to]))
+	if to < len(text) {
+		sb.WriteString("…")
+	}
+	return sb.String()
+}
+
+// wordBoundary moves i in direction dir until it sits on a rune start
+// outside a word. It gives up on the word after a few bytes so text without
+// spaces still yields a short snippet.
+func wordBoundary(text string, i, dir int) int {
+	for n := 0; i > 0 && i < len(text); n++ {
+		if utf8.RuneStart(text[i]) {
+			r, _ := utf8.DecodeRuneInString(text[i:])
+			if n > 24 || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				break
+			}
+		}
+		i += dir
+	}
+	return i
+}
+
+// parseSearchTime accepts RFC 3339 timestamps and plain dates.
+func parseSearchTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// parseSince reads relative ranges such as "7d", "2w" or "36h".
+func parseSince(value string) (time.Duration, error) {
+	if n := len(value); n > 1 && (value[n-1] == 'd' || value[n-1] == 'w') {
+		count, err := strconv.Atoi(value[:n-1])
+		if err != nil || count <= 0 {
+			return 0, fmt.Errorf("invalid since %q", value)
+		}
+		unit := 24 * time.Hour
+		if value[n-1] == 'w' {
+			unit *= 7
+		}
+		return time.Duration(count) * unit, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid since %q", value)
+	}
+	return d, nil
+}
+
+func parseSearchQuery(r *http.Request) (SearchQuery, error) {
+	params := r.URL.Query()
+	q := SearchQuery{
+		User:     params.Get("user"),
+		Terms:    searchTerms(params.Get("q")),
+		Words:    distinct(tokenize(params.Get("q"))),
+		Newest:   params.Get("sort") == "newest",
+		Page:     1,
+		PageSize: 20,
+	}
+
+	if q.User == "" {
+		return q, fmt.Errorf("user parameter is required")
+	}
+	if s := params.Get("sort"); s != "" && s != "newest" && s != "relevance" {
+		return q, fmt.Errorf("sort must be relevance or newest")
+	}
+	if v := params.Get("from"); v != "" {
+		t, err := parseSearchTime(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid from %q (use RFC 3339 or YYYY-MM-DD)", v)
+		}
+		q.From = t
+	}
+	if v := params.Get("to"); v != "" {
+		t, err := parseSearchTime(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid to %q (use RFC 3339 or YYYY-MM-DD)", v)
+		}
+		if len(v) == len("2006-01-02") {
+			t = t.AddDate(0, 0, 1) // a plain date includes the whole day
+		}
+		q.To = t
+	}
+	if v := params.Get("since"); v != "" {
+		d, err := parseSince(v)
+		if err != nil {
+			return q, err
+		}
+		q.From = time.Now().Add(-d)
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return q, fmt.Errorf("from must be before to")
+	}
+	if v := params.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return q, fmt.Errorf("page must be a positive integer")
+		}
+		q.Page = n
+	}
+	if v := params.Get("page_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			return q, fmt.Errorf("page_size must be between 1 and 100")
+		}
+		q.PageSize = n
+	}
+	if len(q.Terms) == 0 {
+		q.Newest = true
+	}
+	return q, nil
+}
+
+// inRange reports whether t falls in the query's [From, To) window.
+func (q SearchQuery) inRange(t time.Time) bool {
+	return (q.From.IsZero() || !t.Before(q.From)) && (q.To.IsZero() || t.Before(q.To))
+}
+
+// handleSearch searches a user's conversation history:
+// GET /search?user=alice&q=goroutines&since=7d&page=1&page_size=20
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"Only GET method allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := parseSearchQuery(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	hits, total, err := memoryStore.repo.searchConversations(r.Context(), q)
+	if err != nil {
+		log.Printf("Error searching conversations for user %s: %v", q.User, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Search failed"})
+		return
+	}
+
+	results := make([]SearchResult, len(hits))
+	for i, hit := range hits {
+		results[i] = SearchResult{
+			ID:              hit.Conversation.ID,
+			Timestamp:       hit.Conversation.Timestamp,
+			Score:           hit.Score,
+			PromptSnippet:   highlight(hit.Conversation.Prompt, q.Terms),
+			ResponseSnippet: highlight(hit.Conversation.Response, q.Terms),
+		}
+	}
+
+	json.NewEncoder(w).Encode(SearchResponse{
+		Query:    r.URL.Query().Get("q"),
+		Total:    total,
+		Page:     q.Page,
+		PageSize: q.PageSize,
+		HasMore:  q.Page*q.PageSize < total,
+		Results:  results,
+	})
+}
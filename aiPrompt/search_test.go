@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("How do Go-routines work? 2 ways, café!")
+	want := []string{"how", "do", "go", "routines", "work", "2", "ways", "café"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokenize = %q, want %q", got, want)
+	}
+}
+
+func TestStem(t *testing.T) {
+	tests := []struct{ word, want string }{
+		{"goroutines", "goroutine"},
+		{"queries", "query"},
+		{"boxes", "box"},
+		{"matches", "match"},
+		{"wishes", "wish"},
+		{"class", "class"},
+		{"gas", "gas"},
+		{"ties", "tie"},
+		{"go", "go"},
+	}
+	for _, tt := range tests {
+		if got := stem(tt.word); got != tt.want {
+			t.Errorf("stem(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+	if got := searchTerms("Queries query QUERY goroutines"); !reflect.DeepEqual(got, []string{"query", "goroutine"}) {
+		t.Errorf("searchTerms = %q", got)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		terms []string
+		want  string
+	}{
+		{"stemmed match", "Goroutines talk over channels.", []string{"goroutine", "channel"},
+			"<mark>Goroutines</mark> talk over <mark>channels</mark>."},
+		{"no match", "Mushrooms.", []string{"pizza"}, "Mushrooms."},
+		{"escapes text", "use <b> & goroutine", []string{"goroutine"},
+			"use &lt;b&gt; &amp; <mark>goroutine</mark>"},
+		{"part of a word", "goroutineish", []string{"goroutine"}, "goroutineish"},
+	}
+	for _, tt := range tests {
+		if got := highlight(tt.text, tt.terms); got != tt.want {
+			t.Errorf("%s: highlight = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	long := strings.Repeat("filler words here ", 30) + "the goroutine leaked " + strings.Repeat("more text ", 30)
+	got := highlight(long, []string{"goroutine"})
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") || !strings.Contains(got, "<mark>goroutine</mark>") {
+		t.Errorf("long text snippet %q", got)
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	q, err := parseSearchQuery(httptest.NewRequest("GET", "/search?user=alice&q=Goroutines+leaks&from=2025-03-01&to=2025-03-02&page=2&page_size=5", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(q.Terms, []string{"goroutine", "leak"}) || !reflect.DeepEqual(q.Words, []string{"goroutines", "leaks"}) {
+		t.Errorf("terms %q, words %q", q.Terms, q.Words)
+	}
+	if q.Newest || q.Page != 2 || q.PageSize != 5 {
+		t.Errorf("query %+v", q)
+	}
+	if want := time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC); !q.To.Equal(want) {
+		t.Errorf("to %v, want the end of the day %v", q.To, want)
+	}
+
+	q, err = parseSearchQuery(httptest.NewRequest("GET", "/search?user=alice&since=7d", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !q.Newest || time.Since(q.From) < 7*24*time.Hour-time.Minute {
+		t.Errorf("since=7d: %+v", q)
+	}
+
+	for _, query := range []string{
+		"q=go",
+		"user=alice&sort=oldest",
+		"user=alice&from=yesterday",
+		"user=alice&from=2025-03-02&to=2025-03-01",
+		"user=alice&since=0d",
+		"user=alice&page=0",
+		"user=alice&page_size=101",
+	} {
+		if _, err := parseSearchQuery(httptest.NewRequest("GET", "/search?"+query, nil)); err == nil {
+			t.Errorf("%s: no error", query)
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Template variable types.
+const (
+	varString  = "string"  // single line
+	varText    = "text"    // multi-line, e.g. code or a document
+	varNumber  = "number"  // any JSON number
+	varInteger = "integer" // whole JSON number
+	varBoolean = "boolean"
+	varEnum    = "enum" // one of Options
+)
+
+const (
+	maxTemplateBody   = 8000
+	maxVariableValue  = 20000
+	maxRenderedPrompt = 32000
+)
+
+var (
+	templateIDPattern   = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+	variableNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	placeholderPattern  = regexp.MustCompile(`\{\{\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*\}\}`)
+)
+
+type TemplateVariable struct {
+	Name        string          `json:"name"`
+	Type        string          `json:"type"`
+	Description string          `json:"description,omitempty"`
+	Required    bool            `json:"required,omitempty"`
+	Default     json.RawMessage `json:"default,omitempty"`
+	Options     []string        `json:"options,omitempty"`    // enum values
+	MaxLength   int             `json:"max_length,omitempty"` // string and text, 0 means maxVariableValue
+}
+
+// PromptTemplate is one version of a named template. The body refers to
+// variables as {{name}}.
+type PromptTemplate struct {
+	ID          string             `json:"id"`
+	Version     int                `json:"version"`
+	Description string             `json:"description,omitempty"`
+	Body        string             `json:"body"`
+	Variables   []TemplateVariable `json:"variables"`
+	CreatedAt   time.Time          `json:"created_at"`
+}
+
+// format validates a JSON value against the variable's type and returns the
+// text inserted into the prompt.
+func (v TemplateVariable) format(raw json.RawMessage) (string, error) {
+	switch v.Type {
+	case varString, varText, varEnum:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", fmt.Errorf("%s must be a string", v.Name)
+		}
+		if v.Type == varString && strings.ContainsAny(s, "\r\n") {
+			return "", fmt.Errorf("%s must be a single line", v.Name)
+		}
+		if v.Type == varEnum && !contains(v.Options, s) {
+			return "", fmt.Errorf("%s must be one of %s", v.Name, strings.Join(v.Options, ", "))
+		}
+		limit := v.MaxLength
+		if limit <= 0 || limit > maxVariableValue {
+			limit = maxVariableValue
+		}
+		if len(s) > limit {
+			return "", fmt.Errorf("%s must be at most %d characters", v.Name, limit)
+		}
+		return s, nil
+
+	case varNumber, varInteger:
+		var n float64
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return "", fmt.Errorf("%s must be a number", v.Name)
+		}
+		if v.Type == varInteger && n != math.Trunc(n) {
+			return "", fmt.Errorf("%s must be an integer", v.Name)
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+
+	case varBoolean:
+		var b bool
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return "", fmt.Errorf("%s must be true or false", v.Name)
+		}
+		return strconv.FormatBool(b), nil
+	}
+	return "", fmt.Errorf("%s has unknown type %q", v.Name, v.Type)
+}
+
+func (t PromptTemplate) validate() error {
+	var errs []error
+
+	if !templateIDPattern.MatchString(t.ID) {
+		errs = append(errs, errors.New("id must be 1-64 lower-case letters, digits, '-' or '_'"))
+	}
+	if strings.TrimSpace(t.Body) == "" || len(t.Body) > maxTemplateBody {
+		errs = append(errs, fmt.Errorf("body must be between 1 and %d characters", maxTemplateBody))
+	}
+
+	declared := make(map[string]bool)
+	for _, v := range t.Variables {
+		if !variableNamePattern.MatchString(v.Name) {
+			errs = append(errs, fmt.Errorf("invalid variable name %q", v.Name))
+			continue
+		}
+		if declared[v.Name] {
+			errs = append(errs, fmt.Errorf("variable %s is declared twice", v.Name))
+		}
+		declared[v.Name] = true
+
+		switch v.Type {
+		case varString, varText, varNumber, varInteger, varBoolean:
+		case varEnum:
+			if len(v.Options) == 0 {
+				errs = append(errs, fmt.Errorf("enum variable %s needs options", v.Name))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("variable %s has unknown type %q (use string, text, number, integer, boolean or enum)", v.Name, v.Type))
+			continue
+		}
+		if len(v.Default) > 0 {
+			if _, err := v.format(v.Default); err != nil {
+				errs = append(errs, fmt.Errorf("default: %w", err))
+			}
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, m := range placeholderPattern.FindAllStringSubmatch(t.Body, -1) {
+		used[m[1]] = true
+		if !declared[m[1]] {
+			errs = append(errs, fmt.Errorf("body uses undeclared variable %s", m[1]))
+		}
+	}
+	for name := range declared {
+		if !used[name] {
+			errs = append(errs, fmt.Errorf("variable %s is declared but not used in the body", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// render fills in the variables. Values are substituted in a single pass,
+// so a value containing {{...}} is inserted literally and never expanded.
+func (t PromptTemplate) render(values map[string]json.RawMessage) (string, error) {
+	var errs []error
+
+	known := make(map[string]bool, len(t.Variables))
+	formatted := make(map[string]string, len(t.Variables))
+	for _, v := range t.Variables {
+		known[v.Name] = true
+		raw, ok := values[v.Name]
+		if !ok || string(raw) == "null" {
+			if v.Required {
+				errs = append(errs, fmt.Errorf("%s is required", v.Name))
+				continue
+			}
+			raw = v.Default
+		}
+		if len(raw) == 0 {
+			formatted[v.Name] = ""
+			continue
+		}
+		s, err := v.format(raw)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		formatted[v.Name] = s
+	}
+	for name := range values {
+		if !known[name] {
+			errs = append(errs, fmt.Errorf("unknown variable %s", name))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return "", err
+	}
+
+	prompt := placeholderPattern.ReplaceAllStringFunc(t.Body, func(placeholder string) string {
+		return formatted[placeholderPattern.FindStringSubmatch(placeholder)[1]]
+	})
+	if len(prompt) > maxRenderedPrompt {
+		return "", fmt.Errorf("rendered prompt is longer than %d characters", maxRenderedPrompt)
+	}
+	return prompt, nil
+}
+
+var (
+	errTemplateNotFound     = errors.New("template not found")
+	errTemplateVersionTaken = errors.New("template version already exists")
+)
+
+// builtinTemplates are saved at startup; saving an unchanged template is a
+// no-op, so they only gain a version when they are edited here.
+var builtinTemplates = []PromptTemplate{
+	{
+		ID:          "summarize",
+		Description: "Summarize a piece of text.",
+		Body:        "Summarize the following text in {{style}} form, in at most {{max_words}} words.\n\nText:\n{{text}}",
+		Variables: []TemplateVariable{
+			{Name: "text", Type: varText, Required: true},
+			{Name: "style", Type: varEnum, Options: []string{"paragraph", "bullet"}, Default: json.RawMessage(`"paragraph"`)},
+			{Name: "max_words", Type: varInteger, Default: json.RawMessage(`150`)},
+		},
+	},
+	{
+		ID:          "review-code",
+		Description: "Review code for bugs, readability and performance.",
+		Body:        "Review this {{language}} code. Point out bugs first, then readability and performance issues. Focus: {{focus}}.\n\nCode:\n{{code}}",
+		Variables: []TemplateVariable{
+			{Name: "code", Type: varText, Required: true},
+			{Name: "language", Type: varString, Default: json.RawMessage(`"Go"`)},
+			{Name: "focus", Type: varString, Default: json.RawMessage(`"correctness"`)},
+		},
+	},
+}
+
+// TemplateStore versions templates in the repository, so the template a
+// stored conversation refers to stays available across restarts.
+type TemplateStore struct {
+	repo Repository
+}
+
+func NewTemplateStore(repo Repository) *TemplateStore {
+	return &TemplateStore{repo: repo}
+}
+
+var templateStore = NewTemplateStore(memoryStore.repo)
+
+// saveBuiltins stores the builtin templates.
+func (ts *TemplateStore) saveBuiltins(ctx context.Context) error {
+	for _, t := range builtinTemplates {
+		if _, err := ts.save(ctx, t); err != nil {
+			return fmt.Errorf("builtin template %s: %w", t.ID, err)
+		}
+	}
+	return nil
+}
+
+// save stores t as the next version of its ID. Saving a template identical
+// to the latest version returns that version instead of creating a new one.
+func (ts *TemplateStore) save(ctx context.Context, t PromptTemplate) (PromptTemplate, error) {
+	if err := t.validate(); err != nil {
+		return PromptTemplate{}, err
+	}
+
+	// A concurrent save of the same ID takes the version we computed;
+	// start over from the new latest version
+	for attempt := 0; attempt < 5; attempt++ {
+		latest, err := ts.repo.template(ctx, t.ID, 0)
+		if err != nil {
+			return PromptTemplate{}, &storageError{err}
+		}
+		if latest != nil && sameTemplate(*latest, t) {
+			return *latest, nil
+		}
+
+		t.Version = 1
+		if latest != nil {
+			t.Version = latest.Version + 1
+		}
+		t.CreatedAt = time.Now()
+		err = ts.repo.addTemplate(ctx, t)
+		if err == nil {
+			return t, nil
+		}
+		if !errors.Is(err, errTemplateVersionTaken) {
+			return PromptTemplate{}, &storageError{err}
+		}
+	}
+	return PromptTemplate{}, &storageError{fmt.Errorf("template %q is being saved concurrently", t.ID)}
+}
+
+// storageError marks a failure of the repository rather than of the
+// input; TemplateStore wraps every repository error in one.
+type storageError struct {
+	err error
+}
+
+func (e *storageError) Error() string { return e.err.Error() }
+func (e *storageError) Unwrap() error { return e.err }
+
+// sameTemplate compares everything but the version metadata.
+func sameTemplate(a, b PromptTemplate) bool {
+	a.Version, a.CreatedAt = 0, time.Time{}
+	b.Version, b.CreatedAt = 0, time.Time{}
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+// get returns a template version; version 0 means the latest. A missing
+// template or version is reported with errTemplateNotFound.
+func (ts *TemplateStore) get(ctx context.Context, id string, version int) (PromptTemplate, error) {
+	if version < 0 {
+		return PromptTemplate{}, fmt.Errorf("%w: %q has no version %d", errTemplateNotFound, id, version)
+	}
+	t, err := ts.repo.template(ctx, id, version)
+	if err != nil {
+		return PromptTemplate{}, &storageError{err}
+	}
+	if t == nil {
+		if version == 0 {
+			return PromptTemplate{}, fmt.Errorf("%w: %q", errTemplateNotFound, id)
+		}
+		return PromptTemplate{}, fmt.Errorf("%w: %q has no version %d", errTemplateNotFound, id, version)
+	}
+	return *t, nil
+}
+
+func (ts *TemplateStore) history(ctx context.Context, id string) ([]PromptTemplate, error) {
+	history, err := ts.repo.templateHistory(ctx, id)
+	if err != nil {
+		return nil, &storageError{err}
+	}
+	return history, nil
+}
+
+func (ts *TemplateStore) list(ctx context.Context) ([]PromptTemplate, error) {
+	result, err := ts.repo.latestTemplates(ctx)
+	if err != nil {
+		return nil, &storageError{err}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+// handleTemplates lists the latest templates (GET), shows one template
+// (GET ?id=x, optionally &version=n or &history=true) and saves a new
+// version (POST).
+func handleTemplates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			templates, err := templateStore.list(r.Context())
+			if err != nil {
+				writeTemplateError(w, err)
+				return
+			}
+			json.NewEncoder(w).Encode(templates)
+			return
+		}
+		if r.URL.Query().Get("history") == "true" {
+			history, err := templateStore.history(r.Context(), id)
+			if err != nil {
+				writeTemplateError(w, err)
+				return
+			}
+			json.NewEncoder(w).Encode(history)
+			return
+		}
+		version := 0
+		if v := r.URL.Query().Get("version"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "version must be an integer"})
+				return
+			}
+			version = n
+		}
+		t, err := templateStore.get(r.Context(), id, version)
+		if err != nil {
+			writeTemplateError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(t)
+
+	case http.MethodPost:
+		var t PromptTemplate
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON format"})
+			return
+		}
+		saved, err := templateStore.save(r.Context(), t)
+		if err != nil {
+			writeTemplateError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(saved)
+
+	default:
+		http.Error(w, `{"error":"Only GET and POST methods allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// writeTemplateError reports a missing template as 404, a repository
+// failure as 500 and anything else as an invalid request.
+func writeTemplateError(w http.ResponseWriter, err error) {
+	var storage *storageError
+	switch {
+	case errors.Is(err, errTemplateNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.As(err, &storage):
+		log.Printf("Error accessing templates: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to access templates"})
+		return
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
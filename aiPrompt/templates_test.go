@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTemplateValidate(t *testing.T) {
+	valid := PromptTemplate{
+		ID:   "review",
+		Body: "Review this {{lang}} code:\n{{ code }}",
+		Variables: []TemplateVariable{
+			{Name: "lang", Type: varEnum, Options: []string{"go", "python"}, Default: json.RawMessage(`"go"`)},
+			{Name: "code", Type: varText, Required: true},
+		},
+	}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("valid template: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*PromptTemplate)
+		want   string
+	}{
+		{"bad id", func(t *PromptTemplate) { t.ID = "Review!" }, "id must be"},
+		{"empty body", func(t *PromptTemplate) { t.Body = "  " }, "body must be"},
+		{"long body", func(t *PromptTemplate) { t.Body += strings.Repeat("x", maxTemplateBody) }, "body must be"},
+		{"undeclared variable", func(t *PromptTemplate) { t.Body += " {{style}}" }, "undeclared variable style"},
+		{"unused variable", func(t *PromptTemplate) {
+			t.Variables = append(t.Variables, TemplateVariable{Name: "tone", Type: varString})
+		}, "tone is declared but not used"},
+		{"declared twice", func(t *PromptTemplate) {
+			t.Variables = append(t.Variables, TemplateVariable{Name: "code", Type: varText})
+		}, "declared twice"},
+		{"bad name", func(t *PromptTemplate) { t.Variables[1].Name = "1code" }, "invalid variable name"},
+		{"unknown type", func(t *PromptTemplate) { t.Variables[1].Type = "blob" }, "unknown type"},
+		{"enum without options", func(t *PromptTemplate) { t.Variables[0].Options = nil; t.Variables[0].Default = nil }, "needs options"},
+		{"bad default", func(t *PromptTemplate) { t.Variables[0].Default = json.RawMessage(`"rust"`) }, "default: lang must be one of"},
+	}
+	for _, tt := range tests {
+		tmpl := valid
+		tmpl.Variables = append([]TemplateVariable(nil), valid.Variables...)
+		tt.change(&tmpl)
+		err := tmpl.validate()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestTemplateRender(t *testing.T) {
+	tmpl := PromptTemplate{
+		ID:   "summary",
+		Body: "Summarize in {{words}} words ({{lang}}, bullets {{bullets}}, temp {{temp}}): {{text}}",
+		Variables: []TemplateVariable{
+			{Name: "text", Type: varText, Required: true, MaxLength: 40},
+			{Name: "words", Type: varInteger, Default: json.RawMessage(`50`)},
+			{Name: "lang", Type: varEnum, Options: []string{"en", "hi"}, Default: json.RawMessage(`"en"`)},
+			{Name: "bullets", Type: varBoolean},
+			{Name: "temp", Type: varNumber},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		values string
+		want   string // rendered prompt, or the error it must contain
+		fails  bool
+	}{
+		{"defaults", `{"text": "a b c"}`,
+			"Summarize in 50 words (en, bullets , temp ): a b c", false},
+		{"all values", `{"text": "x", "words": 20, "lang": "hi", "bullets": true, "temp": 0.5}`,
+			"Summarize in 20 words (hi, bullets true, temp 0.5): x", false},
+		{"value is not expanded", `{"text": "{{lang}} {{ words }}"}`,
+			"Summarize in 50 words (en, bullets , temp ): {{lang}} {{ words }}", false},
+		{"null uses the default", `{"text": "x", "words": null}`,
+			"Summarize in 50 words (en, bullets , temp ): x", false},
+		{"missing required", `{}`, "text is required", true},
+		{"too long", `{"text": "` + strings.Repeat("x", 41) + `"}`, "at most 40 characters", true},
+		{"not an integer", `{"text": "x", "words": 2.5}`, "words must be an integer", true},
+		{"number as string", `{"text": "x", "temp": "0.5"}`, "temp must be a number", true},
+		{"not in enum", `{"text": "x", "lang": "fr"}`, "lang must be one of en, hi", true},
+		{"not a boolean", `{"text": "x", "bullets": "yes"}`, "bullets must be true or false", true},
+		{"wrong type", `{"text": 5}`, "text must be a string", true},
+		{"unknown variable", `{"text": "x", "tone": "dry"}`, "unknown variable tone", true},
+	}
+	for _, tt := range tests {
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(tt.values), &values); err != nil {
+			t.Fatal(err)
+		}
+		got, err := tmpl.render(values)
+		switch {
+		case tt.fails && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		case !tt.fails && (err != nil || got != tt.want):
+			t.Errorf("%s: got %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	line := PromptTemplate{Body: "{{s}}", Variables: []TemplateVariable{{Name: "s", Type: varString}}}
+	if _, err := line.render(map[string]json.RawMessage{"s": json.RawMessage(`"a\nb"`)}); err == nil {
+		t.Error("string variable accepted a newline")
+	}
+}
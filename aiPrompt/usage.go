@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Budget settings, per user. A budget of 0 is unlimited. Days and months
+// are counted in UTC.
+//
+//	DAILY_TOKEN_BUDGET=200000
+//	MONTHLY_TOKEN_BUDGET=3000000
+//	BUDGET_ACTION=downgrade        # or reject (the default)
+//	BUDGET_DOWNGRADE_MODEL=llama3.2:1b
+var budget = BudgetConfig{
+	Daily:          envInt("DAILY_TOKEN_BUDGET", 0),
+	Monthly:        envInt("MONTHLY_TOKEN_BUDGET", 0),
+	Action:         getEnv("BUDGET_ACTION", budgetReject),
+	DowngradeModel: getEnv("BUDGET_DOWNGRADE_MODEL", "llama3.2:1b"),
+}
+
+const (
+	budgetReject    = "reject"
+	budgetDowngrade = "downgrade"
+)
+
+// usageRetention is how many days of usage are kept for reports.
+const usageRetention = 400
+
+type BudgetConfig struct {
+	Daily          int    `json:"daily"`
+	Monthly        int    `json:"monthly"`
+	Action         string `json:"action"`
+	DowngradeModel string `json:"downgrade_model,omitempty"`
+}
+
+// Usage is what one or more model calls cost.
+type Usage struct {
+	Requests         int   `json:"requests"`
+	PromptTokens     int   `json:"prompt_tokens"`
+	CompletionTokens int   `json:"completion_tokens"`
+	TotalTokens      int   `json:"total_tokens"`
+	DurationMs       int64 `json:"duration_ms"`
+}
+
+func (u *Usage) add(o Usage) {
+	u.Requests += o.Requests
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+	u.TotalTokens += o.TotalTokens
+	u.DurationMs += o.DurationMs
+}
+
+// dayUsage is one user's usage on one day, in total and by model.
+type dayUsage struct {
+	Usage
+	byModel map[string]*Usage
+}
+
+type DayReport struct {
+	Date    string           `json:"date"`
+	Usage   Usage            `json:"usage"`
+	ByModel map[string]Usage `json:"by_model"`
+}
+
+type BudgetStatus struct {
+	BudgetConfig
+	UsedToday     int  `json:"used_today"`
+	UsedThisMonth int  `json:"used_this_month"`
+	Exceeded      bool `json:"exceeded"`
+}
+
+type UserUsageReport struct {
+	User   string       `json:"user"`
+	Total  Usage        `json:"total"`
+	Days   []DayReport  `json:"days"`
+	Budget BudgetStatus `json:"budget"`
+}
+
+type UsageSummary struct {
+	User  string `json:"user"`
+	Usage Usage  `json:"usage"`
+}
+
+type UsageReport struct {
+	From  string         `json:"from"`
+	To    string         `json:"to"`
+	Total Usage          `json:"total"`
+	Users []UsageSummary `json:"users"` // heaviest first
+}
+
+// UsageStore counts tokens per user and per UTC day in the repository.
+// Requests in flight hold a reservation against the budget, so concurrent
+// requests cannot together spend more than what is left.
+type UsageStore struct {
+	sync.Mutex
+	repo     Repository
+	reserved map[string]int // user -> tokens reserved by requests in flight in this process
+}
+
+func NewUsageStore(repo Repository) *UsageStore {
+	return &UsageStore{repo: repo, reserved: make(map[string]int)}
+}
+
+var usageStore = NewUsageStore(memoryStore.repo)
+
+// reservedCompletionTokens is what a reservation allows for the answer on
+// top of the prompt, whose size is estimated from its length.
+const reservedCompletionTokens = 1024
+
+func estimateTokens(prompt string) int {
+	return len(prompt)/4 + reservedCompletionTokens
+}
+
+func usageDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// answerUsage converts a model answer into a usage record. Ollama reports
+// its own processing time; the wall time covers calls where it does not.
+func answerUsage(answer ModelAnswer, wall time.Duration) Usage {
+	d := answer.Duration
+	if d <= 0 {
+		d = wall
+	}
+	return Usage{
+		Requests:         1,
+		PromptTokens:     answer.PromptTokens,
+		CompletionTokens: answer.CompletionTokens,
+		TotalTokens:      answer.PromptTokens + answer.CompletionTokens,
+		DurationMs:       d.Milliseconds(),
+	}
+}
+
+func (us *UsageStore) record(ctx context.Context, user, model string, u Usage) error {
+	return us.repo.recordUsage(ctx, user, model, usageDate(time.Now()), u)
+}
+
+// used returns the user's total tokens today and this month.
+func (us *UsageStore) used(ctx context.Context, user string, now time.Time) (int, int, error) {
+	today := usageDate(now)
+	month := today[:len("2006-01")]
+	days, err := us.repo.usageDays(ctx, user, month+"-01", today)
+	if err != nil {
+		return 0, 0, err
+	}
+	var daily, monthly int
+	for _, day := range days {
+		if day.Date == today {
+			daily = day.Usage.TotalTokens
+		}
+		monthly += day.Usage.TotalTokens
+	}
+	return daily, monthly, nil
+}
+
+func (us *UsageStore) budgetStatus(ctx context.Context, user string) (BudgetStatus, error) {
+	daily, monthly, err := us.used(ctx, user, time.Now())
+	if err != nil {
+		return BudgetStatus{}, err
+	}
+	return BudgetStatus{
+		BudgetConfig:  budget,
+		UsedToday:     daily,
+		UsedThisMonth: monthly,
+		Exceeded: (budget.Daily > 0 && daily >= budget.Daily) ||
+			(budget.Monthly > 0 && monthly >= budget.Monthly),
+	}, nil
+}
+
+// reserve holds tokens against the user's budget until release is called,
+// which must happen after the request's usage is recorded. If the tokens
+// do not fit, nothing is reserved and the exhausted period ("Daily" or
+// "Monthly") is returned.
+func (us *UsageStore) reserve(ctx context.Context, user string, tokens int) (func(), string, error) {
+	daily, monthly, err := us.used(ctx, user, time.Now())
+	if err != nil {
+		return nil, "", err
+	}
+
+	us.Lock()
+	defer us.Unlock()
+
+	pending := us.reserved[user] + tokens
+	switch {
+	case budget.Daily > 0 && daily+pending > budget.Daily:
+		return nil, "Daily", nil
+	case budget.Monthly > 0 && monthly+pending > budget.Monthly:
+		return nil, "Monthly", nil
+	}
+	us.reserved[user] = pending
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			us.Lock()
+			defer us.Unlock()
+			if us.reserved[user] -= tokens; us.reserved[user] <= 0 {
+				delete(us.reserved, user)
+			}
+		})
+	}, "", nil
+}
+
+// checkBudget decides which model serves the user's next request and
+// reserves its estimated tokens. Once a budget is used up the request is
+// either rejected (an error message is returned) or served by the cheaper
+// downgrade model. The returned release function is never nil.
+func (us *UsageStore) checkBudget(ctx context.Context, user, model string, tokens int) (string, bool, string, func(), error) {
+	release, period, err := us.reserve(ctx, user, tokens)
+	if err != nil {
+		return "", false, "", func() {}, err
+	}
+	if period == "" {
+		return model, false, "", release, nil
+	}
+
+	if budget.Action == budgetDowngrade && budget.DowngradeModel != "" {
+		return budget.DowngradeModel, model != budget.DowngradeModel, "", func() {}, nil
+	}
+	return "", false, period + " token budget exceeded", func() {}, nil
+}
+
+// userReport lists the user's usage per day in [from, to], newest first.
+func (us *UsageStore) userReport(ctx context.Context, user, from, to string) (UserUsageReport, error) {
+	days, err := us.repo.usageDays(ctx, user, from, to)
+	if err != nil {
+		return UserUsageReport{}, err
+	}
+	report := UserUsageReport{User: user, Days: days}
+	for _, day := range days {
+		report.Total.add(day.Usage)
+	}
+	sort.Slice(report.Days, func(i, j int) bool { return report.Days[i].Date > report.Days[j].Date })
+
+	if report.Budget, err = us.budgetStatus(ctx, user); err != nil {
+		return UserUsageReport{}, err
+	}
+	return report, nil
+}
+
+// report sums every user's usage in [from, to].
+func (us *UsageStore) report(ctx context.Context, from, to string) (UsageReport, error) {
+	users, err := us.repo.usageByUser(ctx, from, to)
+	if err != nil {
+		return UsageReport{}, err
+	}
+
+	report := UsageReport{From: from, To: to, Users: users}
+	for _, summary := range users {
+		report.Total.add(summary.Usage)
+	}
+	sort.Slice(report.Users, func(i, j int) bool {
+		if report.Users[i].Usage.TotalTokens != report.Users[j].Usage.TotalTokens {
+			return report.Users[i].Usage.TotalTokens > report.Users[j].Usage.TotalTokens
+		}
+		return report.Users[i].User < report.Users[j].User
+	})
+	return report, nil
+}
+
+// usageRange reads the report period: ?date=YYYY-MM-DD, ?month=YYYY-MM or
+// ?from=&to= (dates, inclusive). The default is the current month.
+func usageRange(r *http.Request) (string, string, error) {
+	params := r.URL.Query()
+	switch {
+	case params.Get("date") != "":
+		d, err := time.Parse("2006-01-02", params.Get("date"))
+		if err != nil {
+			return "", "", fmt.Errorf("date must be YYYY-MM-DD")
+		}
+		return usageDate(d), usageDate(d), nil
+
+	case params.Get("from") != "" || params.Get("to") != "":
+		from, to := params.Get("from"), params.Get("to")
+		if from == "" {
+			from = "0000-01-01"
+		}
+		if to == "" {
+			to = usageDate(time.Now())
+		}
+		for _, v := range []string{from, to} {
+			if _, err := time.Parse("2006-01-02", v); err != nil {
+				return "", "", fmt.Errorf("from and to must be YYYY-MM-DD")
+			}
+		}
+		if from > to {
+			return "", "", fmt.Errorf("from must not be after to")
+		}
+		return from, to, nil
+	}
+
+	month := params.Get("month")
+	if month == "" {
+		month = time.Now().UTC().Format("2006-01")
+	}
+	start, err := time.Parse("2006-01", month)
+	if err != nil {
+		return "", "", fmt.Errorf("month must be YYYY-MM")
+	}
+	return usageDate(start), usageDate(start.AddDate(0, 1, -1)), nil
+}
+
+// handleUsage reports one user's usage per day and budget status:
+// GET /usage?user=alice&month=2025-06
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"Only GET method allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := r.URL.Query().Get("user")
+	if user == "" {
+		json.NewEncoder(w).Encode(map[string]string{"error": "User parameter is required"})
+		return
+	}
+	from, to, err := usageRange(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	report, err := usageStore.userReport(r.Context(), user, from, to)
+	if err != nil {
+		log.Printf("Error loading usage for user %s: %v", user, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load usage"})
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// handleUsageReport ranks all users by tokens used in a period:
+// GET /usage/report?date=2025-06-01
+func handleUsageReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"Only GET method allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, err := usageRange(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	report, err := usageStore.report(r.Context(), from, to)
+	if err != nil {
+		log.Printf("Error loading usage report: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load usage"})
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+func envInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Fatalf("Invalid %s %q: must be a non-negative integer", key, value)
+	}
+	return n
+}
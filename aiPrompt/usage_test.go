@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func withBudget(t *testing.T, b BudgetConfig) {
+	t.Helper()
+	previous := budget
+	budget = b
+	t.Cleanup(func() { budget = previous })
+}
+
+func recordToday(t *testing.T, us *UsageStore, user string, tokens int) {
+	t.Helper()
+	if err := us.record(context.Background(), user, "llama3", Usage{Requests: 1, TotalTokens: tokens}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReserveArithmetic(t *testing.T) {
+	withBudget(t, BudgetConfig{Daily: 1000, Action: budgetReject})
+	us := NewUsageStore(newMemoryRepository())
+	ctx := context.Background()
+	recordToday(t, us, "alice", 400)
+
+	release1, period, err := us.reserve(ctx, "alice", 300)
+	if err != nil || period != "" {
+		t.Fatalf("first reservation: %q, %v", period, err)
+	}
+	// 400 used + 300 reserved + 300 = 1000 still fits, one more token does not
+	if _, period, _ := us.reserve(ctx, "alice", 301); period != "Daily" {
+		t.Errorf("over budget: period %q, want Daily", period)
+	}
+	release2, period, _ := us.reserve(ctx, "alice", 300)
+	if period != "" {
+		t.Fatalf("exact fit rejected: %q", period)
+	}
+	if us.reserved["alice"] != 600 {
+		t.Errorf("reserved %d, want 600", us.reserved["alice"])
+	}
+
+	// Releasing twice must not give back more than was reserved
+	release1()
+	release1()
+	if us.reserved["alice"] != 300 {
+		t.Errorf("after release reserved %d, want 300", us.reserved["alice"])
+	}
+	release2()
+	if _, ok := us.reserved["alice"]; ok {
+		t.Errorf("reservation left after releasing everything: %v", us.reserved)
+	}
+
+	// Other users have their own budget
+	if _, period, _ := us.reserve(ctx, "bob", 1000); period != "" {
+		t.Errorf("bob rejected: %q", period)
+	}
+}
+
+func TestCheckBudgetRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		budget BudgetConfig
+		used   int
+		want   string
+	}{
+		{"unlimited", BudgetConfig{Action: budgetReject}, 1 << 30, ""},
+		{"within daily", BudgetConfig{Daily: 1000, Action: budgetReject}, 100, ""},
+		{"daily exceeded", BudgetConfig{Daily: 1000, Monthly: 1 << 30, Action: budgetReject}, 900, "Daily token budget exceeded"},
+		{"monthly exceeded", BudgetConfig{Monthly: 1000, Action: budgetReject}, 900, "Monthly token budget exceeded"},
+		{"downgrade without a model", BudgetConfig{Daily: 1000, Action: budgetDowngrade}, 900, "Daily token budget exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withBudget(t, tt.budget)
+			us := NewUsageStore(newMemoryRepository())
+			recordToday(t, us, "alice", tt.used)
+
+			model, downgraded, errMsg, release, err := us.checkBudget(context.Background(), "alice", "llama3", 200)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer release()
+			if errMsg != tt.want {
+				t.Errorf("error %q, want %q", errMsg, tt.want)
+			}
+			if downgraded || (tt.want == "" && model != "llama3") || (tt.want != "" && model != "") {
+				t.Errorf("model %q, downgraded %v", model, downgraded)
+			}
+			if tt.want != "" && len(us.reserved) != 0 {
+				t.Errorf("rejected request reserved %v", us.reserved)
+			}
+		})
+	}
+}
+
+func TestCheckBudgetDowngrades(t *testing.T) {
+	withBudget(t, BudgetConfig{Daily: 1000, Action: budgetDowngrade, DowngradeModel: "llama3.2:1b"})
+	us := NewUsageStore(newMemoryRepository())
+	recordToday(t, us, "alice", 900)
+
+	model, downgraded, errMsg, release, err := us.checkBudget(context.Background(), "alice", "llama3", 200)
+	if err != nil || errMsg != "" {
+		t.Fatalf("error %q, %v", errMsg, err)
+	}
+	if model != "llama3.2:1b" || !downgraded {
+		t.Errorf("model %q, downgraded %v", model, downgraded)
+	}
+	if len(us.reserved) != 0 {
+		t.Errorf("downgraded request reserved %v", us.reserved)
+	}
+	release() // a no-op, but callers always call it
+	if len(us.reserved) != 0 {
+		t.Errorf("release changed reservations: %v", us.reserved)
+	}
+
+	// Asking for the downgrade model itself is not a downgrade
+	if model, downgraded, _, _, _ := us.checkBudget(context.Background(), "alice", "llama3.2:1b", 200); model != "llama3.2:1b" || downgraded {
+		t.Errorf("model %q, downgraded %v", model, downgraded)
+	}
+}
+
+func TestBudgetStatus(t *testing.T) {
+	withBudget(t, BudgetConfig{Daily: 1000, Monthly: 5000, Action: budgetReject})
+	us := NewUsageStore(newMemoryRepository())
+	recordToday(t, us, "alice", 600)
+	recordToday(t, us, "alice", 400)
+
+	status, err := us.budgetStatus(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.UsedToday != 1000 || status.UsedThisMonth != 1000 || !status.Exceeded {
+		t.Errorf("status %+v", status)
+	}
+}
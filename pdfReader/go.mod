@@ -1,3 +1,3 @@
-module .pdf
+module pdfreader
 
 go 1.24.3
@@ -0,0 +1,190 @@
+package readpdf
+
+import (
+	"bytes"
+	"strings"
+)
+
+// textOutput collects shown strings. A string shown on a new line is
+// separated by a newline, one moved along the same line by a space.
+type textOutput struct {
+	text    strings.Builder
+	lineY   float64 // y of the text line matrix, reset by BT
+	shownY  float64 // y of the line moved to last, across text objects
+	leading float64
+	newLine bool
+	newWord bool
+}
+
+func (out *textOutput) write(s string) {
+	if s == "" {
+		return
+	}
+	if out.text.Len() > 0 {
+		if out.newLine {
+			out.text.WriteByte('\n')
+		} else if out.newWord {
+			out.text.WriteByte(' ')
+		}
+	}
+	out.text.WriteString(s)
+	out.newLine, out.newWord = false, false
+}
+
+func (out *textOutput) moveTo(y float64) {
+	if y != out.shownY {
+		out.newLine = true
+	} else {
+		out.newWord = true
+	}
+	out.lineY, out.shownY = y, y
+}
+
+// pageText runs the text operators of a page's content stream and returns
+// the text it shows
+func (doc *document) pageText(p page, content []byte) string {
+	out := &textOutput{}
+	doc.showText(content, p.resources, out, 0)
+	return out.text.String()
+}
+
+// showText interprets a content stream with the given resources. Fonts
+// are looked up in the resources on each Tf, and form XObjects are run
+// with their own resources so text drawn through them is kept.
+func (doc *document) showText(content []byte, resources pdfDict, out *textOutput, depth int) {
+	if depth > 8 {
+		return
+	}
+	fonts := doc.resolveDict(resources["Font"])
+	current := defaultFont
+	var operands []interface{}
+
+	show := func(s []byte) {
+		out.write(doc.pr.cleanPDFText(current.decode(s)))
+	}
+	num := func(i int) float64 {
+		if i < len(operands) {
+			v, _ := asFloat(operands[i])
+			return v
+		}
+		return 0
+	}
+
+	lx := &objectLexer{data: content}
+	for !lx.atEnd() {
+		obj, err := lx.nextObject()
+		if err != nil {
+			// Drop a damaged token rather than the rest of the page
+			operands = operands[:0]
+			continue
+		}
+		op, ok := obj.(pdfKeyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+
+		switch op {
+		case "BT":
+			// Each text object starts with an identity matrix, so Td
+			// offsets do not carry over from the previous one
+			out.lineY = 0
+			out.newWord = true
+		case "Tf":
+			if name, ok := firstName(operands); ok {
+				current = doc.loadFont(fonts[name])
+			}
+		case "Td":
+			out.moveTo(out.lineY + num(1))
+		case "TD":
+			out.leading = -num(1)
+			out.moveTo(out.lineY + num(1))
+		case "TL":
+			out.leading = num(0)
+		case "Tm":
+			out.moveTo(num(5))
+		case "T*":
+			out.moveTo(out.lineY - out.leading)
+		case "Tj":
+			if s, ok := lastString(operands); ok {
+				show(s)
+			}
+		case "'", "\"":
+			out.moveTo(out.lineY - out.leading)
+			if s, ok := lastString(operands); ok {
+				show(s)
+			}
+		case "TJ":
+			items, _ := lastArray(operands)
+			for _, item := range items {
+				if s, ok := item.([]byte); ok {
+					show(s)
+				} else if n, ok := asFloat(item); ok && n < -200 {
+					// A large negative adjustment is a word gap
+					out.newWord = true
+				}
+			}
+		case "Do":
+			name, ok := firstName(operands)
+			if !ok {
+				break
+			}
+			form, ok := doc.resolve(doc.resolveDict(resources["XObject"])[name]).(*pdfStream)
+			if !ok || form.dict["Subtype"] != pdfName("Form") {
+				break
+			}
+			data, err := doc.decodeStream(form)
+			if err != nil {
+				break // an undecodable form is skipped like an image
+			}
+			formResources := doc.resolveDict(form.dict["Resources"])
+			if formResources == nil {
+				formResources = resources
+			}
+			doc.showText(data, formResources, out, depth+1)
+		case "BI":
+			lx.pos = skipInlineImage(content, lx.pos)
+		}
+		operands = operands[:0]
+	}
+}
+
+func firstName(operands []interface{}) (pdfName, bool) {
+	if len(operands) == 0 {
+		return "", false
+	}
+	name, ok := operands[0].(pdfName)
+	return name, ok
+}
+
+func lastString(operands []interface{}) ([]byte, bool) {
+	if len(operands) == 0 {
+		return nil, false
+	}
+	s, ok := operands[len(operands)-1].([]byte)
+	return s, ok
+}
+
+func lastArray(operands []interface{}) (pdfArray, bool) {
+	if len(operands) == 0 {
+		return nil, false
+	}
+	a, ok := operands[len(operands)-1].(pdfArray)
+	return a, ok
+}
+
+// skipInlineImage returns the position after the EI that ends the binary
+// data of an inline image starting at pos
+func skipInlineImage(content []byte, pos int) int {
+	for {
+		i := bytes.Index(content[pos:], []byte("EI"))
+		if i < 0 {
+			return len(content)
+		}
+		at := pos + i
+		if at > 0 && isWhite(content[at-1]) && (at+2 >= len(content) || isWhite(content[at+2])) {
+			return at + 2
+		}
+		pos = at + 2
+	}
+}
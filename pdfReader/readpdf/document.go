@@ -0,0 +1,495 @@
+package readpdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// xrefEntry locates an object: either at a byte offset in the file or at
+// an index inside a compressed object stream
+type xrefEntry struct {
+	offset   int // byte offset, or the object stream's number
+	index    int
+	inStream bool
+	free     bool
+}
+
+// document holds the parsed cross-reference data of a PDF in memory
+type document struct {
+	pr      *PDFReader
+	data    []byte
+	xref    map[int]xrefEntry
+	trailer pdfDict
+	objStms map[int]map[int]interface{} // parsed object streams by number
+	fonts   map[pdfRef]*font
+}
+
+// page is a leaf of the page tree with its inherited resources
+type page struct {
+	dict      pdfDict
+	resources pdfDict
+}
+
+var startxrefRegex = regexp.MustCompile(`startxref\s+(\d+)`)
+
+// openDocument reads the cross-reference sections of a PDF. When they are
+// missing or damaged the objects are found by scanning the file instead.
+func (pr *PDFReader) openDocument(data []byte) (*document, error) {
+	doc := &document{
+		pr:      pr,
+		data:    data,
+		xref:    make(map[int]xrefEntry),
+		objStms: make(map[int]map[int]interface{}),
+		fonts:   make(map[pdfRef]*font),
+	}
+
+	if err := doc.readXref(); err != nil || doc.trailer["Root"] == nil {
+		if pr.verbose {
+			fmt.Printf("Cross-reference data unusable (%v), scanning for objects\n", err)
+		}
+		doc.xref = make(map[int]xrefEntry)
+		doc.trailer = nil
+		if err := doc.scanObjects(); err != nil {
+			return nil, err
+		}
+	}
+	if doc.trailer["Encrypt"] != nil {
+		return nil, fmt.Errorf("encrypted PDF files are not supported")
+	}
+
+	if pr.verbose {
+		fmt.Printf("Found %d objects in cross-reference data\n", len(doc.xref))
+	}
+	return doc, nil
+}
+
+// readXref follows the chain of xref sections from startxref. The newest
+// section is read first, so entries already present win.
+func (doc *document) readXref() error {
+	tail := doc.data
+	if len(tail) > 2048 {
+		tail = tail[len(tail)-2048:]
+	}
+	matches := startxrefRegex.FindAllSubmatch(tail, -1)
+	if len(matches) == 0 {
+		return fmt.Errorf("startxref not found")
+	}
+	offset, _ := strconv.Atoi(string(matches[len(matches)-1][1]))
+
+	seen := make(map[int]bool)
+	for offset > 0 && !seen[offset] {
+		seen[offset] = true
+		if offset >= len(doc.data) {
+			return fmt.Errorf("xref offset %d is past the end of the file", offset)
+		}
+
+		var trailer pdfDict
+		var err error
+		if bytes.HasPrefix(doc.data[offset:], []byte("xref")) {
+			trailer, err = doc.readXrefTable(offset)
+		} else {
+			trailer, err = doc.readXrefStream(offset)
+		}
+		if err != nil {
+			return err
+		}
+		if doc.trailer == nil {
+			doc.trailer = trailer
+		}
+
+		// Hybrid-reference files list compressed objects in a separate stream
+		if stm, ok := asInt(trailer["XRefStm"]); ok && !seen[stm] {
+			seen[stm] = true
+			if _, err := doc.readXrefStream(stm); err != nil {
+				return err
+			}
+		}
+		offset, _ = asInt(trailer["Prev"])
+	}
+	return nil
+}
+
+func (doc *document) addXref(num int, e xrefEntry) {
+	if _, ok := doc.xref[num]; !ok {
+		doc.xref[num] = e
+	}
+}
+
+// readXrefTable reads a classic "xref" table and the trailer after it
+func (doc *document) readXrefTable(offset int) (pdfDict, error) {
+	lx := &objectLexer{data: doc.data, pos: offset + len("xref")}
+	for {
+		tok, err := lx.nextToken()
+		if err != nil {
+			return nil, fmt.Errorf("xref table: %w", err)
+		}
+		if tok == pdfKeyword("trailer") {
+			break
+		}
+
+		first, ok1 := tok.(int64)
+		countTok, err := lx.nextToken()
+		count, ok2 := countTok.(int64)
+		if err != nil || !ok1 || !ok2 {
+			return nil, fmt.Errorf("xref table: bad subsection header at offset %d", lx.pos)
+		}
+		for i := 0; i < int(count); i++ {
+			off, _ := lx.nextToken()
+			lx.nextToken() // generation
+			kind, err := lx.nextToken()
+			if err != nil {
+				return nil, fmt.Errorf("xref table: %w", err)
+			}
+			switch n, _ := off.(int64); kind {
+			case pdfKeyword("n"):
+				doc.addXref(int(first)+i, xrefEntry{offset: int(n)})
+			case pdfKeyword("f"):
+				doc.addXref(int(first)+i, xrefEntry{free: true})
+			}
+		}
+	}
+
+	obj, err := lx.nextObject()
+	if err != nil {
+		return nil, fmt.Errorf("trailer: %w", err)
+	}
+	trailer, ok := obj.(pdfDict)
+	if !ok {
+		return nil, fmt.Errorf("trailer is not a dictionary")
+	}
+	return trailer, nil
+}
+
+// readXrefStream reads a cross-reference stream (PDF 1.5). Its dictionary
+// is also the trailer.
+func (doc *document) readXrefStream(offset int) (pdfDict, error) {
+	obj, err := doc.parseObjectAt(offset)
+	if err != nil {
+		return nil, fmt.Errorf("xref stream: %w", err)
+	}
+	s, ok := obj.(*pdfStream)
+	if !ok {
+		return nil, fmt.Errorf("xref stream: object at offset %d is not a stream", offset)
+	}
+	data, err := doc.decodeStream(s)
+	if err != nil {
+		return nil, fmt.Errorf("xref stream: %w", err)
+	}
+
+	w, _ := s.dict["W"].(pdfArray)
+	if len(w) != 3 {
+		return nil, fmt.Errorf("xref stream: /W must have three entries")
+	}
+	var widths [3]int
+	for i := range widths {
+		widths[i], _ = asInt(w[i])
+	}
+	rowLen := widths[0] + widths[1] + widths[2]
+	if rowLen == 0 {
+		return nil, fmt.Errorf("xref stream: empty /W")
+	}
+
+	// field reads column i of a row; an absent type column means type 1
+	field := func(row []byte, i, def int) int {
+		if widths[i] == 0 {
+			return def
+		}
+		start := 0
+		for j := 0; j < i; j++ {
+			start += widths[j]
+		}
+		v := 0
+		for _, b := range row[start : start+widths[i]] {
+			v = v<<8 | int(b)
+		}
+		return v
+	}
+
+	size, _ := asInt(s.dict["Size"])
+	index := pdfArray{int64(0), int64(size)}
+	if idx, ok := s.dict["Index"].(pdfArray); ok {
+		index = idx
+	}
+
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		first, _ := asInt(index[i])
+		count, _ := asInt(index[i+1])
+		for j := 0; j < count && pos+rowLen <= len(data); j++ {
+			row := data[pos : pos+rowLen]
+			pos += rowLen
+			switch field(row, 0, 1) {
+			case 0:
+				doc.addXref(first+j, xrefEntry{free: true})
+			case 1:
+				doc.addXref(first+j, xrefEntry{offset: field(row, 1, 0)})
+			case 2:
+				doc.addXref(first+j, xrefEntry{offset: field(row, 1, 0), index: field(row, 2, 0), inStream: true})
+			}
+		}
+	}
+	return s.dict, nil
+}
+
+var objHeaderRegex = regexp.MustCompile(`(?m)(?:^|[^0-9])(\d+)\s+(\d+)\s+obj\b`)
+
+// scanObjects rebuilds the xref by searching the file for "num gen obj".
+// Later definitions replace earlier ones, as after an incremental update.
+func (doc *document) scanObjects() error {
+	for _, m := range objHeaderRegex.FindAllSubmatchIndex(doc.data, -1) {
+		num, _ := strconv.Atoi(string(doc.data[m[2]:m[3]]))
+		doc.xref[num] = xrefEntry{offset: m[2]}
+	}
+	if len(doc.xref) == 0 {
+		return fmt.Errorf("no PDF objects found")
+	}
+
+	doc.trailer = make(pdfDict)
+	if i := bytes.LastIndex(doc.data, []byte("trailer")); i >= 0 {
+		lx := &objectLexer{data: doc.data, pos: i + len("trailer")}
+		if obj, err := lx.nextObject(); err == nil {
+			if trailer, ok := obj.(pdfDict); ok {
+				doc.trailer = trailer
+			}
+		}
+	}
+	if doc.trailer["Root"] != nil {
+		return nil
+	}
+
+	// No usable trailer: find the catalog itself
+	for num := range doc.xref {
+		if d := doc.resolveDict(pdfRef{num, 0}); d["Type"] == pdfName("Catalog") {
+			doc.trailer["Root"] = pdfRef{num, 0}
+			return nil
+		}
+	}
+	return fmt.Errorf("document catalog not found")
+}
+
+// parseObjectAt reads "num gen obj ... endobj" at offset. A stream's data
+// is sliced using /Length from its dictionary.
+func (doc *document) parseObjectAt(offset int) (interface{}, error) {
+	lx := &objectLexer{data: doc.data, pos: offset}
+	for i := 0; i < 2; i++ {
+		tok, err := lx.nextToken()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := tok.(int64); !ok {
+			return nil, fmt.Errorf("no object header at offset %d", offset)
+		}
+	}
+	if tok, err := lx.nextToken(); err != nil || tok != pdfKeyword("obj") {
+		return nil, fmt.Errorf("no object header at offset %d", offset)
+	}
+
+	obj, err := lx.nextObject()
+	if err != nil {
+		return nil, err
+	}
+	d, ok := obj.(pdfDict)
+	if !ok {
+		return obj, nil
+	}
+	save := lx.pos
+	if tok, err := lx.nextToken(); err != nil || tok != pdfKeyword("stream") {
+		lx.pos = save
+		return d, nil
+	}
+
+	// Stream data starts after the end of line that follows "stream"
+	start := lx.pos
+	if start < len(doc.data) && doc.data[start] == '\r' {
+		start++
+	}
+	if start < len(doc.data) && doc.data[start] == '\n' {
+		start++
+	}
+
+	length, ok := asInt(doc.resolve(d["Length"]))
+	if !ok || length < 0 || start+length > len(doc.data) ||
+		!bytes.Contains(doc.data[start+length:min(start+length+32, len(doc.data))], []byte("endstream")) {
+		// Missing or wrong /Length: fall back to the endstream keyword
+		end := bytes.Index(doc.data[start:], []byte("endstream"))
+		if end < 0 {
+			return nil, fmt.Errorf("stream at offset %d has no endstream", offset)
+		}
+		length = len(bytes.TrimRight(doc.data[start:start+end], "\r\n"))
+	}
+	return &pdfStream{dict: d, raw: doc.data[start : start+length]}, nil
+}
+
+// object loads indirect object num. Free and unknown objects are null.
+func (doc *document) object(num int) (interface{}, error) {
+	e, ok := doc.xref[num]
+	if !ok || e.free {
+		return nil, nil
+	}
+	if !e.inStream {
+		return doc.parseObjectAt(e.offset)
+	}
+
+	objects, ok := doc.objStms[e.offset]
+	if !ok {
+		var err error
+		if objects, err = doc.readObjectStream(e.offset); err != nil {
+			return nil, err
+		}
+		doc.objStms[e.offset] = objects
+	}
+	return objects[num], nil
+}
+
+// readObjectStream parses every object compressed into object stream num
+func (doc *document) readObjectStream(num int) (map[int]interface{}, error) {
+	e := doc.xref[num]
+	if e.inStream || e.free {
+		return nil, fmt.Errorf("object stream %d is not a top-level object", num)
+	}
+	obj, err := doc.parseObjectAt(e.offset)
+	if err != nil {
+		return nil, fmt.Errorf("object stream %d: %w", num, err)
+	}
+	s, ok := obj.(*pdfStream)
+	if !ok {
+		return nil, fmt.Errorf("object stream %d is not a stream", num)
+	}
+	data, err := doc.decodeStream(s)
+	if err != nil {
+		return nil, fmt.Errorf("object stream %d: %w", num, err)
+	}
+
+	// Each header entry takes at least four bytes, which bounds a bogus /N
+	n, _ := asInt(s.dict["N"])
+	first, _ := asInt(s.dict["First"])
+	if n < 0 || n > len(data)/4+1 || first < 0 || first > len(data) {
+		return nil, fmt.Errorf("object stream %d: invalid /N or /First", num)
+	}
+	header := &objectLexer{data: data}
+	objects := make(map[int]interface{}, n)
+	for i := 0; i < n; i++ {
+		numTok, err1 := header.nextToken()
+		offTok, err2 := header.nextToken()
+		objNum, ok1 := numTok.(int64)
+		off, ok2 := offTok.(int64)
+		if err1 != nil || err2 != nil || !ok1 || !ok2 {
+			return nil, fmt.Errorf("object stream %d: bad header", num)
+		}
+		lx := &objectLexer{data: data, pos: first + int(off)}
+		if off < 0 || lx.pos >= len(data) {
+			continue
+		}
+		if objects[int(objNum)], err = lx.nextObject(); err != nil {
+			return nil, fmt.Errorf("object stream %d: object %d: %w", num, objNum, err)
+		}
+	}
+	return objects, nil
+}
+
+// resolve follows indirect references. An object that cannot be read is
+// treated as null, so one damaged object does not lose the whole file.
+func (doc *document) resolve(v interface{}) interface{} {
+	for depth := 0; depth < 32; depth++ {
+		ref, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		obj, err := doc.object(ref.num)
+		if err != nil {
+			if doc.pr.verbose {
+				fmt.Printf("Skipping object %d: %v\n", ref.num, err)
+			}
+			return nil
+		}
+		v = obj
+	}
+	return nil
+}
+
+// resolveDict resolves v to a dictionary, or a stream's dictionary
+func (doc *document) resolveDict(v interface{}) pdfDict {
+	switch o := doc.resolve(v).(type) {
+	case pdfDict:
+		return o
+	case *pdfStream:
+		return o.dict
+	}
+	return nil
+}
+
+// pages walks the page tree in document order. Resources are inherited
+// from the nearest ancestor that has them.
+func (doc *document) pages() ([]page, error) {
+	catalog := doc.resolveDict(doc.trailer["Root"])
+	if catalog == nil {
+		return nil, fmt.Errorf("document catalog not found")
+	}
+
+	var result []page
+	visited := make(map[pdfRef]bool)
+	var walk func(node interface{}, resources pdfDict, depth int) error
+	walk = func(node interface{}, resources pdfDict, depth int) error {
+		if ref, ok := node.(pdfRef); ok {
+			if visited[ref] {
+				return fmt.Errorf("page tree contains a cycle at object %d", ref.num)
+			}
+			visited[ref] = true
+		}
+		if depth > 64 {
+			return fmt.Errorf("page tree is too deep")
+		}
+		d := doc.resolveDict(node)
+		if d == nil {
+			return nil
+		}
+		if res := doc.resolveDict(d["Resources"]); res != nil {
+			resources = res
+		}
+
+		kids, ok := doc.resolve(d["Kids"]).(pdfArray)
+		if d["Type"] == pdfName("Page") || !ok {
+			result = append(result, page{dict: d, resources: resources})
+			return nil
+		}
+		for _, kid := range kids {
+			if err := walk(kid, resources, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(catalog["Pages"], nil, 0); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// pageContent decodes and joins the content streams of a page
+func (doc *document) pageContent(p page) ([]byte, error) {
+	var parts pdfArray
+	switch c := doc.resolve(p.dict["Contents"]).(type) {
+	case *pdfStream:
+		parts = pdfArray{c}
+	case pdfArray:
+		parts = c
+	}
+
+	var buf bytes.Buffer
+	for _, part := range parts {
+		s, ok := doc.resolve(part).(*pdfStream)
+		if !ok {
+			continue
+		}
+		data, err := doc.decodeStream(s)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n') // streams may split an operator only at whitespace
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,159 @@
+package readpdf
+
+import (
+	"strconv"
+	"strings"
+)
+
+// winAnsiNames are the glyph names of WinAnsiEncoding (Windows-1252) by
+// code, from 0x20 up; "" marks an unused code
+var winAnsiNames = [256 - 0x20]string{
+	"space", "exclam", "quotedbl", "numbersign", "dollar", "percent", "ampersand", "quotesingle",
+	"parenleft", "parenright", "asterisk", "plus", "comma", "hyphen", "period", "slash",
+	"zero", "one", "two", "three", "four", "five", "six", "seven",
+	"eight", "nine", "colon", "semicolon", "less", "equal", "greater", "question",
+	"at", "A", "B", "C", "D", "E", "F", "G", "H", "I", "J", "K", "L", "M", "N", "O",
+	"P", "Q", "R", "S", "T", "U", "V", "W", "X", "Y", "Z",
+	"bracketleft", "backslash", "bracketright", "asciicircum", "underscore",
+	"grave", "a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m", "n", "o",
+	"p", "q", "r", "s", "t", "u", "v", "w", "x", "y", "z",
+	"braceleft", "bar", "braceright", "asciitilde", "",
+	// 0x80
+	"Euro", "", "quotesinglbase", "florin", "quotedblbase", "ellipsis", "dagger", "daggerdbl",
+	"circumflex", "perthousand", "Scaron", "guilsinglleft", "OE", "", "Zcaron", "",
+	"", "quoteleft", "quoteright", "quotedblleft", "quotedblright", "bullet", "endash", "emdash",
+	"tilde", "trademark", "scaron", "guilsinglright", "oe", "", "zcaron", "Ydieresis",
+	// 0xA0
+	"space", "exclamdown", "cent", "sterling", "currency", "yen", "brokenbar", "section",
+	"dieresis", "copyright", "ordfeminine", "guillemotleft", "logicalnot", "hyphen", "registered", "macron",
+	"degree", "plusminus", "twosuperior", "threesuperior", "acute", "mu", "paragraph", "periodcentered",
+	"cedilla", "onesuperior", "ordmasculine", "guillemotright", "onequarter", "onehalf", "threequarters", "questiondown",
+	"Agrave", "Aacute", "Acircumflex", "Atilde", "Adieresis", "Aring", "AE", "Ccedilla",
+	"Egrave", "Eacute", "Ecircumflex", "Edieresis", "Igrave", "Iacute", "Icircumflex", "Idieresis",
+	"Eth", "Ntilde", "Ograve", "Oacute", "Ocircumflex", "Otilde", "Odieresis", "multiply",
+	"Oslash", "Ugrave", "Uacute", "Ucircumflex", "Udieresis", "Yacute", "Thorn", "germandbls",
+	"agrave", "aacute", "acircumflex", "atilde", "adieresis", "aring", "ae", "ccedilla",
+	"egrave", "eacute", "ecircumflex", "edieresis", "igrave", "iacute", "icircumflex", "idieresis",
+	"eth", "ntilde", "ograve", "oacute", "ocircumflex", "otilde", "odieresis", "divide",
+	"oslash", "ugrave", "uacute", "ucircumflex", "udieresis", "yacute", "thorn", "ydieresis",
+}
+
+// winAnsiEncoding is Windows-1252, the encoding most simple fonts use
+var winAnsiEncoding = func() [256]rune {
+	var enc [256]rune
+	for i := 0x20; i < 0x7f; i++ {
+		enc[i] = rune(i)
+	}
+	for i, r := range []rune("€\x00‚ƒ„…†‡ˆ‰Š‹Œ\x00Ž\x00\x00‘’“”•–—˜™š›œ\x00žŸ") {
+		enc[0x80+i] = r
+	}
+	for i := 0xa0; i < 0x100; i++ {
+		enc[i] = rune(i)
+	}
+	return enc
+}()
+
+// macRomanEncoding is the Mac OS Roman encoding
+var macRomanEncoding = func() [256]rune {
+	var enc [256]rune
+	for i := 0x20; i < 0x7f; i++ {
+		enc[i] = rune(i)
+	}
+	high := "ÄÅÇÉÑÖÜáàâäãåçéèêëíìîïñóòôöõúùûü†°¢£§•¶ß®©™´¨≠ÆØ∞±≤≥¥µ∂∑∏π∫ªºΩæø" +
+		"¿¡¬√ƒ≈∆«»…\u00a0ÀÃÕŒœ–—“”‘’÷◊ÿŸ⁄€‹›ﬁﬂ‡·‚„‰ÂÊÁËÈÍÎÏÌÓÔ\uf8ffÒÚÛÙıˆ˜¯˘˙˚¸˝˛ˇ"
+	for i, r := range []rune(high) {
+		enc[0x80+i] = r
+	}
+	return enc
+}()
+
+// standardEncoding is Adobe StandardEncoding, the default of Type 1 fonts
+var standardEncoding = func() [256]rune {
+	var enc [256]rune
+	for i := 0x20; i < 0x7f; i++ {
+		enc[i] = rune(i)
+	}
+	enc['\''], enc['`'] = '’', '‘'
+	high := map[int]rune{
+		0xa1: '¡', 0xa2: '¢', 0xa3: '£', 0xa4: '⁄', 0xa5: '¥', 0xa6: 'ƒ', 0xa7: '§', 0xa8: '¤',
+		0xa9: '\'', 0xaa: '“', 0xab: '«', 0xac: '‹', 0xad: '›', 0xae: 'ﬁ', 0xaf: 'ﬂ',
+		0xb1: '–', 0xb2: '†', 0xb3: '‡', 0xb4: '·', 0xb6: '¶', 0xb7: '•', 0xb8: '‚', 0xb9: '„',
+		0xba: '”', 0xbb: '»', 0xbc: '…', 0xbd: '‰', 0xbf: '¿',
+		0xc1: '`', 0xc2: '´', 0xc3: 'ˆ', 0xc4: '˜', 0xc5: '¯', 0xc6: '˘', 0xc7: '˙', 0xc8: '¨',
+		0xca: '˚', 0xcb: '¸', 0xcd: '˝', 0xce: '˛', 0xcf: 'ˇ', 0xd0: '—',
+		0xe1: 'Æ', 0xe3: 'ª', 0xe8: 'Ł', 0xe9: 'Ø', 0xea: 'Œ', 0xeb: 'º',
+		0xf1: 'æ', 0xf5: 'ı', 0xf8: 'ł', 0xf9: 'ø', 0xfa: 'œ', 0xfb: 'ß',
+	}
+	for code, r := range high {
+		enc[code] = r
+	}
+	return enc
+}()
+
+// glyphNames maps glyph names used in /Differences arrays to Unicode.
+// Names of the form uniXXXX and uXXXX[XX] are decoded by glyphText.
+var glyphNames = func() map[string]rune {
+	names := map[string]rune{
+		"fi": 'ﬁ', "fl": 'ﬂ', "ff": 'ﬀ', "ffi": 'ﬃ', "ffl": 'ﬄ', "fraction": '⁄', "dotlessi": 'ı',
+		"Lslash": 'Ł', "lslash": 'ł', "ring": '˚', "breve": '˘', "dotaccent": '˙', "hungarumlaut": '˝',
+		"ogonek": '˛', "caron": 'ˇ', "minus": '−', "nbspace": '\u00a0', "sfthyphen": '\u00ad',
+		"notequal": '≠', "infinity": '∞', "lessequal": '≤', "greaterequal": '≥',
+		"partialdiff": '∂', "summation": '∑', "product": '∏', "pi": 'π', "integral": '∫',
+		"Omega": 'Ω', "radical": '√', "approxequal": '≈', "Delta": '∆', "lozenge": '◊',
+		"Gamma": 'Γ', "Theta": 'Θ', "Lambda": 'Λ', "Xi": 'Ξ', "Pi": 'Π', "Sigma": 'Σ', "Phi": 'Φ', "Psi": 'Ψ',
+		"alpha": 'α', "beta": 'β', "gamma": 'γ', "delta": 'δ', "epsilon": 'ε', "zeta": 'ζ', "eta": 'η',
+		"theta": 'θ', "iota": 'ι', "kappa": 'κ', "lambda": 'λ', "nu": 'ν', "xi": 'ξ', "omicron": 'ο',
+		"rho": 'ρ', "sigma": 'σ', "tau": 'τ', "upsilon": 'υ', "phi": 'φ', "chi": 'χ', "psi": 'ψ', "omega": 'ω',
+		"arrowleft": '←', "arrowright": '→', "arrowup": '↑', "arrowdown": '↓', "checkmark": '✓',
+		"middot": '·',
+	}
+	// The first code of a name wins, so space and hyphen stay ASCII
+	for i, name := range winAnsiNames {
+		if name != "" {
+			if _, ok := names[name]; !ok {
+				names[name] = winAnsiEncoding[0x20+i]
+			}
+		}
+	}
+	return names
+}()
+
+// glyphText returns the text of a glyph name: a known name, uniXXXX
+// (possibly several code points), uXXXX to uXXXXXX, a ligature such as
+// f_f_i, or a variant such as a.sc. Unknown names give "".
+func glyphText(glyph string) string {
+	if i := strings.IndexByte(glyph, '.'); i > 0 {
+		glyph = glyph[:i]
+	}
+	if r, ok := glyphNames[glyph]; ok {
+		return string(r)
+	}
+	if strings.Contains(glyph, "_") {
+		var sb strings.Builder
+		for _, part := range strings.Split(glyph, "_") {
+			sb.WriteString(glyphText(part))
+		}
+		return sb.String()
+	}
+
+	if hex, ok := strings.CutPrefix(glyph, "uni"); ok && len(hex) >= 4 && len(hex)%4 == 0 {
+		var sb strings.Builder
+		for i := 0; i < len(hex); i += 4 {
+			v, err := strconv.ParseUint(hex[i:i+4], 16, 16)
+			if err != nil || v >= 0xd800 && v < 0xe000 {
+				return ""
+			}
+			sb.WriteRune(rune(v))
+		}
+		return sb.String()
+	}
+	if hex, ok := strings.CutPrefix(glyph, "u"); ok && len(hex) >= 4 && len(hex) <= 6 {
+		if v, err := strconv.ParseUint(hex, 16, 32); err == nil && v <= 0x10ffff {
+			return string(rune(v))
+		}
+	}
+	if len(glyph) == 1 {
+		return glyph
+	}
+	return ""
+}
@@ -0,0 +1,387 @@
+package readpdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// UnsupportedFilterError is returned when a stream needs a filter this
+// package cannot decode, such as the image filters DCTDecode or JBIG2Decode
+type UnsupportedFilterError struct {
+	Filter string
+}
+
+func (e *UnsupportedFilterError) Error() string {
+	return fmt.Sprintf("unsupported stream filter %s", e.Filter)
+}
+
+// maxDecodedSize caps the output of a single filter, so a small stream
+// cannot inflate into gigabytes
+const maxDecodedSize = 64 << 20
+
+// filterNames maps the abbreviations used in inline images to full names
+var filterNames = map[pdfName]pdfName{
+	"AHx": "ASCIIHexDecode",
+	"A85": "ASCII85Decode",
+	"LZW": "LZWDecode",
+	"Fl":  "FlateDecode",
+	"RL":  "RunLengthDecode",
+	"CCF": "CCITTFaxDecode",
+	"DCT": "DCTDecode",
+}
+
+// decodeStream runs a stream's data through each filter in its /Filter
+// array, with the matching entry of /DecodeParms
+func (doc *document) decodeStream(s *pdfStream) ([]byte, error) {
+	var filters pdfArray
+	switch f := doc.resolve(s.dict["Filter"]).(type) {
+	case pdfName:
+		filters = pdfArray{f}
+	case pdfArray:
+		filters = f
+	}
+	var params pdfArray
+	switch p := doc.resolve(s.dict["DecodeParms"]).(type) {
+	case pdfDict:
+		params = pdfArray{p}
+	case pdfArray:
+		params = p
+	}
+
+	data := s.raw
+	for i, f := range filters {
+		filter, ok := doc.resolve(f).(pdfName)
+		if !ok {
+			return nil, fmt.Errorf("filter %v is not a name", f)
+		}
+		if full, ok := filterNames[filter]; ok {
+			filter = full
+		}
+		var p pdfDict
+		if i < len(params) {
+			p = doc.resolveDict(params[i])
+		}
+
+		var err error
+		if data, err = applyFilter(filter, data, p); err != nil {
+			return nil, err
+		}
+		if doc.pr.verbose {
+			fmt.Printf("Decoded %s: %d bytes\n", filter, len(data))
+		}
+	}
+	return data, nil
+}
+
+// applyFilter decodes data with a single filter
+func applyFilter(filter pdfName, data []byte, params pdfDict) ([]byte, error) {
+	switch filter {
+	case "FlateDecode":
+		out, err := flateDecode(data)
+		if err != nil {
+			return nil, err
+		}
+		return unpredict(out, params)
+	case "LZWDecode":
+		earlyChange := 1
+		if v, ok := asInt(params["EarlyChange"]); ok {
+			earlyChange = v
+		}
+		out, err := lzwDecode(data, earlyChange)
+		if err != nil {
+			return nil, err
+		}
+		return unpredict(out, params)
+	case "ASCIIHexDecode":
+		return asciiHexDecode(data)
+	case "ASCII85Decode":
+		return ascii85Decode(data)
+	case "RunLengthDecode":
+		return runLengthDecode(data)
+	}
+	return nil, &UnsupportedFilterError{Filter: string(filter)}
+}
+
+func flateDecode(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("FlateDecode: %w", err)
+	}
+	defer reader.Close()
+
+	out, err := io.ReadAll(io.LimitReader(reader, maxDecodedSize+1))
+	// Truncated streams are common; keep what was decoded
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("FlateDecode: %w", err)
+	}
+	if len(out) > maxDecodedSize {
+		return nil, fmt.Errorf("FlateDecode: data inflates past %d bytes", maxDecodedSize)
+	}
+	return out, nil
+}
+
+// lzwDecode decodes LZW data with MSB-first codes of 9 to 12 bits. With
+// earlyChange 1 the code width grows one code early, as most writers do.
+func lzwDecode(data []byte, earlyChange int) ([]byte, error) {
+	const (
+		clearCode = 256
+		eodCode   = 257
+	)
+	var (
+		out   []byte
+		table [][]byte
+		prev  []byte
+		width = 9
+		bits  uint32
+		nbits uint
+	)
+	reset := func() {
+		table = table[:0]
+		for i := 0; i < 256; i++ {
+			table = append(table, []byte{byte(i)})
+		}
+		table = append(table, nil, nil) // clear and EOD
+		width, prev = 9, nil
+	}
+	reset()
+
+	for pos := 0; ; {
+		for nbits < uint(width) && pos < len(data) {
+			bits = bits<<8 | uint32(data[pos])
+			nbits += 8
+			pos++
+		}
+		if nbits < uint(width) {
+			break // no EOD marker; accept the data as complete
+		}
+		code := int(bits>>(nbits-uint(width))) & (1<<width - 1)
+		nbits -= uint(width)
+
+		switch {
+		case code == clearCode:
+			reset()
+			continue
+		case code == eodCode:
+			return out, nil
+		}
+
+		var entry []byte
+		switch {
+		case code < len(table) && table[code] != nil:
+			entry = table[code]
+		case code == len(table) && prev != nil:
+			entry = append(append([]byte(nil), prev...), prev[0])
+		default:
+			return nil, fmt.Errorf("LZWDecode: invalid code %d", code)
+		}
+		out = append(out, entry...)
+		if len(out) > maxDecodedSize {
+			return nil, fmt.Errorf("LZWDecode: data expands past %d bytes", maxDecodedSize)
+		}
+
+		if prev != nil && len(table) < 4096 {
+			table = append(table, append(append([]byte(nil), prev...), entry[0]))
+		}
+		prev = entry
+		if len(table)+earlyChange >= 1<<width && width < 12 {
+			width++
+		}
+	}
+	return out, nil
+}
+
+func asciiHexDecode(data []byte) ([]byte, error) {
+	if end := bytes.IndexByte(data, '>'); end >= 0 {
+		data = data[:end]
+	}
+	lx := &objectLexer{data: append(append([]byte{'<'}, data...), '>')}
+	out, err := lx.hexString()
+	if err != nil {
+		return nil, fmt.Errorf("ASCIIHexDecode: %w", err)
+	}
+	return out, nil
+}
+
+func ascii85Decode(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	data = bytes.TrimPrefix(data, []byte("<~"))
+	if end := bytes.Index(data, []byte("~>")); end >= 0 {
+		data = data[:end]
+	}
+	out, err := io.ReadAll(ascii85.NewDecoder(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("ASCII85Decode: %w", err)
+	}
+	return out, nil
+}
+
+// runLengthDecode expands runs: a length byte n < 128 copies the next n+1
+// bytes, n > 128 repeats the next byte 257-n times and 128 ends the data
+func runLengthDecode(data []byte) ([]byte, error) {
+	var out []byte
+	for pos := 0; pos < len(data); {
+		n := int(data[pos])
+		pos++
+		switch {
+		case n == 128:
+			return out, nil
+		case n < 128:
+			if pos+n+1 > len(data) {
+				return nil, fmt.Errorf("RunLengthDecode: literal run past end of data")
+			}
+			out = append(out, data[pos:pos+n+1]...)
+			pos += n + 1
+		default:
+			if pos >= len(data) {
+				return nil, fmt.Errorf("RunLengthDecode: repeat run past end of data")
+			}
+			out = append(out, bytes.Repeat(data[pos:pos+1], 257-n)...)
+			pos++
+		}
+		if len(out) > maxDecodedSize {
+			return nil, fmt.Errorf("RunLengthDecode: data expands past %d bytes", maxDecodedSize)
+		}
+	}
+	return out, nil
+}
+
+// unpredict undoes the /Predictor of Flate and LZW data: 2 is the TIFF
+// predictor, 10 and up are PNG row filters
+func unpredict(data []byte, params pdfDict) ([]byte, error) {
+	predictor, _ := asInt(params["Predictor"])
+	if predictor <= 1 {
+		return data, nil
+	}
+
+	columns, colors, bpc := 1, 1, 8
+	if v, ok := asInt(params["Columns"]); ok {
+		columns = v
+	}
+	if v, ok := asInt(params["Colors"]); ok {
+		colors = v
+	}
+	if v, ok := asInt(params["BitsPerComponent"]); ok {
+		bpc = v
+	}
+	// The parameters come from the file: check them before they size
+	// any buffer
+	switch bpc {
+	case 1, 2, 4, 8, 16:
+	default:
+		return nil, fmt.Errorf("invalid predictor BitsPerComponent %d", bpc)
+	}
+	if colors <= 0 || colors > 32 || columns <= 0 || columns > math.MaxInt32/(colors*bpc) {
+		return nil, fmt.Errorf("invalid predictor parameters: %d columns of %d colors", columns, colors)
+	}
+	rowLen := (colors*bpc*columns + 7) / 8
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	switch {
+	case predictor == 2:
+		if rowLen > len(data) {
+			return nil, fmt.Errorf("TIFF predictor: row of %d bytes is longer than the data", rowLen)
+		}
+		return unpredictTIFF(data, rowLen, colors, bpc)
+	case predictor >= 10:
+		if rowLen+1 > len(data) {
+			return nil, fmt.Errorf("PNG predictor: row of %d bytes is longer than the data", rowLen)
+		}
+		return unpredictPNG(data, rowLen, max(colors*bpc/8, 1))
+	}
+	return nil, fmt.Errorf("unknown predictor %d", predictor)
+}
+
+// unpredictTIFF adds each sample to the sample of the same color
+// component to its left
+func unpredictTIFF(data []byte, rowLen, colors, bpc int) ([]byte, error) {
+	out := append([]byte(nil), data...)
+	for start := 0; start+rowLen <= len(out); start += rowLen {
+		row := out[start : start+rowLen]
+		switch bpc {
+		case 8:
+			for i := colors; i < len(row); i++ {
+				row[i] += row[i-colors]
+			}
+		case 16:
+			for i := 2 * colors; i+1 < len(row); i += 2 {
+				v := uint16(row[i])<<8 | uint16(row[i+1])
+				v += uint16(row[i-2*colors])<<8 | uint16(row[i-2*colors+1])
+				row[i], row[i+1] = byte(v>>8), byte(v)
+			}
+		case 1, 2, 4:
+			samples := rowLen * 8 / bpc
+			mask := byte(1<<bpc - 1)
+			get := func(i int) byte {
+				shift := 8 - bpc - (i*bpc)%8
+				return row[i*bpc/8] >> shift & mask
+			}
+			for i := colors; i < samples; i++ {
+				v := (get(i) + get(i-colors)) & mask
+				shift := 8 - bpc - (i*bpc)%8
+				row[i*bpc/8] = row[i*bpc/8]&^(mask<<shift) | v<<shift
+			}
+		default:
+			return nil, fmt.Errorf("TIFF predictor: unsupported BitsPerComponent %d", bpc)
+		}
+	}
+	return out, nil
+}
+
+// unpredictPNG undoes the PNG filter named by the first byte of each row
+func unpredictPNG(data []byte, rowLen, bpp int) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	prev := make([]byte, rowLen)
+	for pos := 0; pos+rowLen < len(data); pos += rowLen + 1 {
+		kind := data[pos]
+		row := append([]byte(nil), data[pos+1:pos+1+rowLen]...)
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = row[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+			switch kind {
+			case 0:
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			default:
+				return nil, fmt.Errorf("PNG predictor: unknown row filter %d", kind)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := absInt(p-int(a)), absInt(p-int(b)), absInt(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,141 @@
+package readpdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func deflate(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func ascii85Encode(data []byte) []byte {
+	out := make([]byte, ascii85.MaxEncodedLen(len(data)))
+	n := ascii85.Encode(out, data)
+	return append(append([]byte("<~"), out[:n]...), "~>"...)
+}
+
+func TestApplyFilter(t *testing.T) {
+	hello := []byte("Hello, PDF")
+	tests := []struct {
+		filter pdfName
+		data   []byte
+		want   string
+	}{
+		{"ASCIIHexDecode", []byte(hex.EncodeToString(hello) + ">"), "Hello, PDF"},
+		{"ASCIIHexDecode", []byte("48 65\n6C6C 6F7>"), "Hellop"}, // odd digit count pads with 0
+		{"ASCII85Decode", ascii85Encode(hello), "Hello, PDF"},
+		{"FlateDecode", deflate(t, hello), "Hello, PDF"},
+		{"RunLengthDecode", []byte{2, 'a', 'b', 'c', 254, 'x', 128, 'z'}, "abcxxx"},
+		// The example from the PDF reference: "-----A---B" with EarlyChange 1
+		{"LZWDecode", []byte{0x80, 0x0B, 0x60, 0x50, 0x22, 0x0C, 0x0C, 0x85, 0x01}, "-----A---B"},
+	}
+	for _, tt := range tests {
+		got, err := applyFilter(tt.filter, tt.data, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tt.filter, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestFlateKeepsTruncatedData(t *testing.T) {
+	var text bytes.Buffer
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&text, "%d BT (line %d) Tj ET\n", i*7919%1000, i)
+	}
+	data := deflate(t, text.Bytes())
+
+	got, err := applyFilter("FlateDecode", data[:len(data)/2], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) == 0 || !bytes.HasPrefix(text.Bytes(), got) {
+		t.Errorf("truncated stream decoded to %d bytes that are not a prefix of the text", len(got))
+	}
+}
+
+func TestFlateRejectsOversizedOutput(t *testing.T) {
+	data := deflate(t, make([]byte, maxDecodedSize+1))
+	if _, err := applyFilter("FlateDecode", data, nil); err == nil {
+		t.Error("stream inflating past maxDecodedSize was accepted")
+	}
+}
+
+func TestUnsupportedFilter(t *testing.T) {
+	_, err := applyFilter("DCTDecode", []byte{0xff, 0xd8}, nil)
+	var unsupported *UnsupportedFilterError
+	if !errors.As(err, &unsupported) || unsupported.Filter != "DCTDecode" {
+		t.Errorf("got %v, want an UnsupportedFilterError", err)
+	}
+}
+
+func TestDecodeStreamPipeline(t *testing.T) {
+	// PNG predictor rows of three bytes: None, then Sub, then Up
+	rows := []byte{
+		0, 1, 2, 3,
+		1, 4, 1, 1,
+		2, 1, 1, 1,
+	}
+	raw := append([]byte(hex.EncodeToString(deflate(t, rows))), '>')
+	s := &pdfStream{
+		dict: pdfDict{
+			"Filter":      pdfArray{pdfName("AHx"), pdfName("Fl")},
+			"DecodeParms": pdfArray{nil, pdfDict{"Predictor": int64(12), "Columns": int64(3)}},
+		},
+		raw: raw,
+	}
+	doc := &document{pr: &PDFReader{}}
+
+	got, err := doc.decodeStream(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{1, 2, 3, 4, 5, 6, 5, 6, 7}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTIFFPredictor(t *testing.T) {
+	params := pdfDict{"Predictor": int64(2), "Columns": int64(2), "Colors": int64(2)}
+	got, err := unpredict([]byte{1, 10, 1, 10, 5, 5, 1, 1}, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{1, 10, 2, 20, 5, 5, 6, 6}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPredictorRejectsBadParameters(t *testing.T) {
+	for _, params := range []pdfDict{
+		{"Predictor": int64(12), "BitsPerComponent": int64(3)},
+		{"Predictor": int64(12), "Colors": int64(0)},
+		{"Predictor": int64(12), "Colors": int64(64)},
+		{"Predictor": int64(12), "Columns": int64(-1)},
+		{"Predictor": int64(12), "Columns": int64(1 << 40)},
+		{"Predictor": int64(12), "Columns": int64(100)}, // row longer than the data
+		{"Predictor": int64(5)},
+	} {
+		if _, err := unpredict([]byte{0, 1, 2, 3}, params); err == nil {
+			t.Errorf("unpredict accepted %v", params)
+		}
+	}
+}
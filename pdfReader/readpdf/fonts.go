@@ -0,0 +1,307 @@
+package readpdf
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// font maps the codes of shown strings to Unicode text
+type font struct {
+	toUnicode *cmap
+	composite bool        // Type0 font with multi-byte codes
+	codespace *cmap       // codespace of a composite font's encoding CMap
+	utf16     bool        // composite font whose codes are UTF-16 (Uni*-UCS2-H and friends)
+	encoding  [256]string // simple fonts: text for each one-byte code
+}
+
+// defaultFont is used for text shown before any Tf
+var defaultFont = simpleFont(standardEncoding)
+
+func simpleFont(enc [256]rune) *font {
+	f := &font{}
+	for code, r := range enc {
+		if r != 0 {
+			f.encoding[code] = string(r)
+		}
+	}
+	return f
+}
+
+// decode returns the text of a string shown with the font. Codes without
+// a mapping are dropped rather than shown as unrelated characters.
+func (f *font) decode(s []byte) string {
+	if f.utf16 && f.toUnicode == nil {
+		return utf16BE(s)
+	}
+
+	var text strings.Builder
+	for len(s) > 0 {
+		n := 1
+		switch {
+		case f.toUnicode != nil && f.toUnicode.hasCodespace():
+			n = f.toUnicode.codeLength(s)
+		case f.codespace != nil:
+			n = f.codespace.codeLength(s)
+		case f.composite:
+			n = 2
+		}
+		n = min(n, len(s))
+		code := string(s[:n])
+		s = s[n:]
+
+		if f.toUnicode != nil {
+			if t, ok := f.toUnicode.chars[code]; ok {
+				text.WriteString(t)
+				continue
+			}
+		}
+		if !f.composite && n == 1 {
+			text.WriteString(f.encoding[code[0]])
+		}
+	}
+	return text.String()
+}
+
+// loadFont builds the font for a font dictionary. Fonts are cached by
+// object, as every page of a document usually shares them.
+func (doc *document) loadFont(ref interface{}) *font {
+	key, isRef := ref.(pdfRef)
+	if isRef {
+		if f, ok := doc.fonts[key]; ok {
+			return f
+		}
+	}
+
+	f := doc.buildFont(doc.resolveDict(ref))
+	if isRef {
+		doc.fonts[key] = f
+	}
+	return f
+}
+
+func (doc *document) buildFont(d pdfDict) *font {
+	if d == nil {
+		return defaultFont
+	}
+
+	var f *font
+	if d["Subtype"] == pdfName("Type0") {
+		f = &font{composite: true}
+		switch enc := doc.resolve(d["Encoding"]).(type) {
+		case pdfName:
+			// Identity-H/V and most predefined CMaps use two-byte codes
+			f.utf16 = strings.Contains(string(enc), "UCS2") || strings.Contains(string(enc), "UTF16")
+		case *pdfStream:
+			f.codespace = doc.readCMap(enc, d)
+		}
+	} else {
+		f = doc.simpleEncoding(d)
+	}
+
+	if s, ok := doc.resolve(d["ToUnicode"]).(*pdfStream); ok {
+		f.toUnicode = doc.readCMap(s, d)
+	}
+	return f
+}
+
+// simpleEncoding builds the one-byte encoding of a simple font from its
+// base encoding and /Differences
+func (doc *document) simpleEncoding(d pdfDict) *font {
+	base := standardEncoding
+	if d["Subtype"] == pdfName("TrueType") {
+		base = winAnsiEncoding
+	}
+	baseFor := func(v interface{}) {
+		switch doc.resolve(v) {
+		case pdfName("WinAnsiEncoding"):
+			base = winAnsiEncoding
+		case pdfName("MacRomanEncoding"):
+			base = macRomanEncoding
+		case pdfName("StandardEncoding"):
+			base = standardEncoding
+		}
+	}
+
+	enc := doc.resolve(d["Encoding"])
+	encDict, _ := enc.(pdfDict)
+	if encDict != nil {
+		baseFor(encDict["BaseEncoding"])
+	} else {
+		baseFor(enc)
+	}
+	f := simpleFont(base)
+
+	diffs, _ := doc.resolve(encDict["Differences"]).(pdfArray)
+	code := 0
+	for _, item := range diffs {
+		switch v := doc.resolve(item).(type) {
+		case int64:
+			code = int(v)
+		case pdfName:
+			if code >= 0 && code < 256 {
+				f.encoding[code] = glyphText(string(v))
+			}
+			code++
+		}
+	}
+	return f
+}
+
+// readCMap decodes and parses a CMap stream. A CMap that cannot be
+// decoded is skipped, so the font falls back to its encoding.
+func (doc *document) readCMap(s *pdfStream, fontDict pdfDict) *cmap {
+	data, err := doc.decodeStream(s)
+	if err != nil {
+		if doc.pr.verbose {
+			fmt.Printf("Skipping CMap of font %v: %v\n", fontDict["BaseFont"], err)
+		}
+		return nil
+	}
+	return parseCMap(data)
+}
+
+// cmap holds the codespace ranges and bfchar/bfrange mappings of a CMap.
+// Codes are keyed by their raw bytes, so codes of different lengths stay
+// distinct.
+type cmap struct {
+	ranges []codespaceRange
+	chars  map[string]string
+}
+
+type codespaceRange struct {
+	low, high []byte
+}
+
+func (c *cmap) hasCodespace() bool {
+	return len(c.ranges) > 0
+}
+
+// codeLength returns the length of the code at the start of s
+func (c *cmap) codeLength(s []byte) int {
+	for _, r := range c.ranges {
+		if len(r.low) > len(s) {
+			continue
+		}
+		match := true
+		for i := range r.low {
+			if s[i] < r.low[i] || s[i] > r.high[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return len(r.low)
+		}
+	}
+	if len(c.ranges) > 0 {
+		return len(c.ranges[0].low)
+	}
+	return 1
+}
+
+// parseCMap reads a CMap program. Only the operators that matter for
+// text are interpreted, and damaged tokens are skipped.
+func parseCMap(data []byte) *cmap {
+	c := &cmap{chars: make(map[string]string)}
+	lx := &objectLexer{data: data}
+	var operands []interface{}
+	for !lx.atEnd() {
+		obj, err := lx.nextObject()
+		if err != nil {
+			operands = operands[:0]
+			continue
+		}
+		op, ok := obj.(pdfKeyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+
+		switch op {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				low, ok1 := operands[i].([]byte)
+				high, ok2 := operands[i+1].([]byte)
+				if ok1 && ok2 && len(low) == len(high) && len(low) > 0 {
+					c.ranges = append(c.ranges, codespaceRange{low, high})
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].([]byte)
+				dst, ok2 := operands[i+1].([]byte)
+				if ok1 && ok2 {
+					c.chars[string(src)] = utf16BE(dst)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				low, ok1 := operands[i].([]byte)
+				high, ok2 := operands[i+1].([]byte)
+				if !ok1 || !ok2 || len(low) != len(high) {
+					continue
+				}
+				c.addRange(low, bytesValue(high)-bytesValue(low), operands[i+2])
+			}
+		}
+		operands = operands[:0]
+	}
+	return c
+}
+
+// addRange maps count+1 consecutive codes starting at low. The
+// destination is either one string whose last UTF-16 unit is incremented
+// for each code, or an array with a string per code.
+func (c *cmap) addRange(low []byte, count int, dst interface{}) {
+	if count < 0 || count > 0xffff {
+		return
+	}
+	code := append([]byte(nil), low...)
+	for i := 0; i <= count; i++ {
+		switch d := dst.(type) {
+		case []byte:
+			next := append([]byte(nil), d...)
+			if n := len(next); n >= 2 {
+				last := (int(next[n-2])<<8 | int(next[n-1])) + i
+				next[n-2], next[n-1] = byte(last>>8), byte(last)
+			}
+			c.chars[string(code)] = utf16BE(next)
+		case pdfArray:
+			if i < len(d) {
+				if s, ok := d[i].([]byte); ok {
+					c.chars[string(code)] = utf16BE(s)
+				}
+			}
+		}
+		incrementCode(code)
+	}
+}
+
+// incrementCode adds one to a big-endian code in place
+func incrementCode(code []byte) {
+	for i := len(code) - 1; i >= 0; i-- {
+		code[i]++
+		if code[i] != 0 {
+			return
+		}
+	}
+}
+
+func bytesValue(b []byte) int {
+	v := 0
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+	return v
+}
+
+// utf16BE decodes big-endian UTF-16, the text encoding of ToUnicode
+// CMaps; surrogate pairs give characters outside the BMP
+func utf16BE(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,117 @@
+package readpdf
+
+import "testing"
+
+const testCMap = `/CIDInit /ProcSet findresource begin
+12 dict begin
+begincmap
+1 begincodespacerange
+<0000> <FFFF>
+endcodespacerange
+2 beginbfchar
+<0003> <0020>
+<0011> <D83DDE00>
+endbfchar
+2 beginbfrange
+<0024> <0026> <0041>
+<0030> <0031> [<0066006C> <0078>]
+endbfrange
+endcmap
+end end`
+
+func TestParseCMap(t *testing.T) {
+	c := parseCMap([]byte(testCMap))
+
+	want := map[string]string{
+		"\x00\x03": " ",
+		"\x00\x11": "😀",
+		"\x00\x24": "A",
+		"\x00\x25": "B",
+		"\x00\x26": "C",
+		"\x00\x30": "fl",
+		"\x00\x31": "x",
+	}
+	for code, text := range want {
+		if got := c.chars[code]; got != text {
+			t.Errorf("code %x maps to %q, want %q", code, got, text)
+		}
+	}
+	if len(c.chars) != len(want) {
+		t.Errorf("got %d mappings, want %d: %q", len(c.chars), len(want), c.chars)
+	}
+	if !c.hasCodespace() || c.codeLength([]byte{0, 0x24, 0}) != 2 {
+		t.Errorf("codespace %v", c.ranges)
+	}
+}
+
+func TestGlyphText(t *testing.T) {
+	tests := []struct{ glyph, want string }{
+		{"A", "A"},
+		{"eacute", "é"},
+		{"fi", "ﬁ"},
+		{"f_f_i", "ffi"},
+		{"a.sc", "a"},
+		{"uni0041", "A"},
+		{"uni00410042", "AB"},
+		{"u1F600", "😀"},
+		{"uniD800", ""},
+		{"g123", ""},
+	}
+	for _, tt := range tests {
+		if got := glyphText(tt.glyph); got != tt.want {
+			t.Errorf("glyphText(%q) = %q, want %q", tt.glyph, got, tt.want)
+		}
+	}
+}
+
+func TestDifferences(t *testing.T) {
+	doc := &document{pr: &PDFReader{}}
+	f := doc.simpleEncoding(pdfDict{
+		"Subtype": pdfName("Type1"),
+		"Encoding": pdfDict{
+			"BaseEncoding": pdfName("WinAnsiEncoding"),
+			"Differences":  pdfArray{int64(65), pdfName("B"), pdfName("uni00E9"), int64(200), pdfName("fi")},
+		},
+	})
+
+	// 65 and 66 are replaced, 200 too; 67 and 0x93 keep WinAnsi
+	if got, want := f.decode([]byte{65, 66, 67, 200, 0x93}), "BéCﬁ“"; got != want {
+		t.Errorf("decode = %q, want %q", got, want)
+	}
+}
+
+func TestDecodeComposite(t *testing.T) {
+	doc := &document{pr: &PDFReader{}, fonts: make(map[pdfRef]*font)}
+
+	identity := doc.buildFont(pdfDict{
+		"Subtype":   pdfName("Type0"),
+		"Encoding":  pdfName("Identity-H"),
+		"ToUnicode": &pdfStream{dict: pdfDict{}, raw: []byte(testCMap)},
+	})
+	shown := []byte{0, 0x24, 0, 0x03, 0, 0x30, 0, 0x11, 0, 0x99}
+	if got, want := identity.decode(shown), "A fl😀"; got != want {
+		t.Errorf("Identity-H with ToUnicode: decode = %q, want %q", got, want)
+	}
+
+	// Without ToUnicode the glyph IDs mean nothing and are dropped
+	bare := doc.buildFont(pdfDict{"Subtype": pdfName("Type0"), "Encoding": pdfName("Identity-H")})
+	if got := bare.decode(shown); got != "" {
+		t.Errorf("Identity-H without ToUnicode: decode = %q", got)
+	}
+
+	ucs2 := doc.buildFont(pdfDict{"Subtype": pdfName("Type0"), "Encoding": pdfName("UniGB-UCS2-H")})
+	if got, want := ucs2.decode([]byte{0x4e, 0x2d, 0x65, 0x87}), "中文"; got != want {
+		t.Errorf("UCS2 encoding: decode = %q, want %q", got, want)
+	}
+}
+
+func TestTextObjectsResetLinePosition(t *testing.T) {
+	doc := &document{pr: &PDFReader{}, fonts: make(map[pdfRef]*font)}
+	content := []byte(`BT 72 700 Td (Hello) Tj ET
+BT 200 700 Td (world) Tj ET
+BT 72 680 Td (Next) Tj 0 -20 Td (line) Tj ET`)
+
+	if got, want := doc.pageText(page{}, content), "Hello world\nNext\nline"; got != want {
+		t.Errorf("pageText = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,321 @@
+package readpdf
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// PDF objects. Strings stay raw bytes: what they mean depends on the font
+// that shows them.
+type (
+	pdfName    string
+	pdfKeyword string
+	pdfDict    map[pdfName]interface{}
+	pdfArray   []interface{}
+	pdfRef     struct{ num, gen int }
+	pdfStream  struct {
+		dict pdfDict
+		raw  []byte // undecoded stream data
+	}
+)
+
+// objectLexer reads tokens and objects from PDF data
+type objectLexer struct {
+	data []byte
+	pos  int
+}
+
+func isWhite(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == 0
+}
+
+func isDelimiter(c byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
+
+// skipWhite skips whitespace and comments
+func (lx *objectLexer) skipWhite() {
+	for lx.pos < len(lx.data) {
+		c := lx.data[lx.pos]
+		if c == '%' {
+			for lx.pos < len(lx.data) && lx.data[lx.pos] != '\n' && lx.data[lx.pos] != '\r' {
+				lx.pos++
+			}
+			continue
+		}
+		if !isWhite(c) {
+			return
+		}
+		lx.pos++
+	}
+}
+
+func (lx *objectLexer) atEnd() bool {
+	lx.skipWhite()
+	return lx.pos >= len(lx.data)
+}
+
+func (lx *objectLexer) peekByte(n int) byte {
+	if lx.pos+n < len(lx.data) {
+		return lx.data[lx.pos+n]
+	}
+	return 0
+}
+
+// nextToken returns a number, name, string or keyword. The delimiters
+// "<<", ">>", "[" and "]" come back as keywords.
+func (lx *objectLexer) nextToken() (interface{}, error) {
+	lx.skipWhite()
+	if lx.pos >= len(lx.data) {
+		return nil, fmt.Errorf("unexpected end of data")
+	}
+
+	c := lx.data[lx.pos]
+	switch {
+	case c == '(':
+		return lx.literalString()
+	case c == '<' && lx.peekByte(1) == '<':
+		lx.pos += 2
+		return pdfKeyword("<<"), nil
+	case c == '>' && lx.peekByte(1) == '>':
+		lx.pos += 2
+		return pdfKeyword(">>"), nil
+	case c == '<':
+		return lx.hexString()
+	case c == '/':
+		return lx.name(), nil
+	case c == '[' || c == ']' || c == '{' || c == '}':
+		lx.pos++
+		return pdfKeyword(c), nil
+	}
+
+	start := lx.pos
+	for lx.pos < len(lx.data) && !isWhite(lx.data[lx.pos]) && !isDelimiter(lx.data[lx.pos]) {
+		lx.pos++
+	}
+	if lx.pos == start {
+		lx.pos++
+		return nil, fmt.Errorf("unexpected character %q at offset %d", c, start)
+	}
+	word := string(lx.data[start:lx.pos])
+	if n, err := strconv.ParseInt(word, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(word, 64); err == nil {
+		return f, nil
+	}
+	return pdfKeyword(word), nil
+}
+
+// name reads a name, decoding #xx escapes
+func (lx *objectLexer) name() pdfName {
+	lx.pos++ // '/'
+	var buf []byte
+	for lx.pos < len(lx.data) && !isWhite(lx.data[lx.pos]) && !isDelimiter(lx.data[lx.pos]) {
+		c := lx.data[lx.pos]
+		if c == '#' && lx.pos+2 < len(lx.data) {
+			if v, err := strconv.ParseUint(string(lx.data[lx.pos+1:lx.pos+3]), 16, 8); err == nil {
+				buf = append(buf, byte(v))
+				lx.pos += 3
+				continue
+			}
+		}
+		buf = append(buf, c)
+		lx.pos++
+	}
+	return pdfName(buf)
+}
+
+// literalString reads a (string), handling nesting and escapes
+func (lx *objectLexer) literalString() ([]byte, error) {
+	lx.pos++ // '('
+	var buf []byte
+	depth := 1
+	for lx.pos < len(lx.data) {
+		c := lx.data[lx.pos]
+		lx.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return buf, nil
+			}
+		case '\\':
+			if lx.pos >= len(lx.data) {
+				continue
+			}
+			e := lx.data[lx.pos]
+			lx.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if lx.pos < len(lx.data) && lx.data[lx.pos] == '\n' {
+					lx.pos++
+				}
+				continue // escaped end of line
+			case '\n':
+				continue
+			default:
+				if e < '0' || e > '7' {
+					c = e
+					break
+				}
+				v := int(e - '0')
+				for i := 0; i < 2 && lx.pos < len(lx.data) && lx.data[lx.pos] >= '0' && lx.data[lx.pos] <= '7'; i++ {
+					v = v*8 + int(lx.data[lx.pos]-'0')
+					lx.pos++
+				}
+				c = byte(v)
+			}
+		}
+		buf = append(buf, c)
+	}
+	return nil, fmt.Errorf("unterminated string")
+}
+
+// hexString reads a <hex string>; a missing final digit counts as 0
+func (lx *objectLexer) hexString() ([]byte, error) {
+	lx.pos++ // '<'
+	var buf []byte
+	var high byte
+	odd := false
+	for ; lx.pos < len(lx.data); lx.pos++ {
+		c := lx.data[lx.pos]
+		if c == '>' {
+			lx.pos++
+			if odd {
+				buf = append(buf, high<<4)
+			}
+			return buf, nil
+		}
+		if isWhite(c) {
+			continue
+		}
+		v, ok := hexValue(c)
+		if !ok {
+			return nil, fmt.Errorf("invalid hex string at offset %d", lx.pos)
+		}
+		if odd {
+			buf = append(buf, high<<4|v)
+		} else {
+			high = v
+		}
+		odd = !odd
+	}
+	return nil, fmt.Errorf("unterminated hex string")
+}
+
+func hexValue(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// nextObject reads a complete object. "num gen R" is recognised by looking
+// two tokens ahead.
+func (lx *objectLexer) nextObject() (interface{}, error) {
+	tok, err := lx.nextToken()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case int64:
+		save := lx.pos
+		if gen, err := lx.nextToken(); err == nil {
+			if g, ok := gen.(int64); ok {
+				if r, err := lx.nextToken(); err == nil && r == pdfKeyword("R") {
+					return pdfRef{int(t), int(g)}, nil
+				}
+			}
+		}
+		lx.pos = save
+		return t, nil
+
+	case pdfKeyword:
+		switch t {
+		case "<<":
+			d := make(pdfDict)
+			for {
+				lx.skipWhite()
+				if bytes.HasPrefix(lx.data[lx.pos:], []byte(">>")) {
+					lx.pos += 2
+					return d, nil
+				}
+				key, err := lx.nextToken()
+				if err != nil {
+					return nil, err
+				}
+				k, ok := key.(pdfName)
+				if !ok {
+					return nil, fmt.Errorf("dictionary key %v is not a name", key)
+				}
+				value, err := lx.nextObject()
+				if err != nil {
+					return nil, err
+				}
+				d[k] = value
+			}
+		case "[":
+			a := make(pdfArray, 0)
+			for {
+				lx.skipWhite()
+				if lx.pos < len(lx.data) && lx.data[lx.pos] == ']' {
+					lx.pos++
+					return a, nil
+				}
+				value, err := lx.nextObject()
+				if err != nil {
+					return nil, err
+				}
+				a = append(a, value)
+			}
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+	}
+	return tok, nil
+}
+
+// asInt reads an integer operand; reals are truncated
+func asInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	}
+	return 0, false
+}
+
+// asFloat reads a numeric operand
+func asFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}